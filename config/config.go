@@ -28,12 +28,13 @@ import (
 // directly to YAML. Fields that cannot be set in the cloud-config (fields
 // used for internal use) have the YAML tag '-' so that they aren't marshalled.
 type CloudConfig struct {
-	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
-	CoreOS            CoreOS   `yaml:"coreos"`
-	WriteFiles        []File   `yaml:"write_files"`
-	Hostname          string   `yaml:"hostname"`
-	Users             []User   `yaml:"users"`
-	ManageEtcHosts    EtcHosts `yaml:"manage_etc_hosts"`
+	SSHAuthorizedKeys []string   `yaml:"ssh_authorized_keys"`
+	CoreOS            CoreOS     `yaml:"coreos"`
+	WriteFiles        []File     `yaml:"write_files"`
+	Hostname          string     `yaml:"hostname"`
+	Users             []User     `yaml:"users"`
+	ManageEtcHosts    EtcHosts   `yaml:"manage_etc_hosts"`
+	VendorData        VendorData `yaml:"vendor_data"`
 }
 
 type CoreOS struct {
@@ -47,6 +48,14 @@ type CoreOS struct {
 	Units     []Unit    `yaml:"units"`
 }
 
+const (
+	// FrequencyAlways applies an item on every run. This is the default.
+	FrequencyAlways = "always"
+	// FrequencyOncePerInstance applies an item only on the first run on an
+	// instance, as identified by the instance-id of the datasource.
+	FrequencyOncePerInstance = "once-per-instance"
+)
+
 func IsCloudConfig(userdata string) bool {
 	header := strings.SplitN(userdata, "\n", 2)[0]
 
@@ -70,9 +79,13 @@ func NewCloudConfig(contents string) (*CloudConfig, error) {
 
 // Decode decodes the content of cloud config. Currently only WriteFiles section
 // supports several types of encoding and all of them are supported. After
-// decode operation, Encoding type is unset.
+// decode operation, Encoding type is unset. Encrypted content is left as it
+// is, to be decrypted by DecryptSecrets.
 func (cc *CloudConfig) Decode() error {
 	for i, file := range cc.WriteFiles {
+		if file.Encoding == EncodingEncrypted {
+			continue
+		}
 		content, err := DecodeContent(file.Content, file.Encoding)
 		if err != nil {
 			return err
@@ -50,6 +50,11 @@ func DecodeContent(content string, encoding string) ([]byte, error) {
 		}
 
 		return DecodeGzipContent(string(gz))
+
+	case EncodingEncrypted:
+		// Only the base64 is decoded; the ciphertext is decrypted when the
+		// config is applied.
+		return DecodeBase64Content(content)
 	}
 
 	return nil, fmt.Errorf("Unsupported encoding %q", encoding)
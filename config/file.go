@@ -15,9 +15,10 @@
 package config
 
 type File struct {
-	Encoding           string `yaml:"encoding" valid:"^(base64|b64|gz|gzip|gz\\+base64|gzip\\+base64|gz\\+b64|gzip\\+b64)$"`
+	Encoding           string `yaml:"encoding" valid:"^(base64|b64|gz|gzip|gz\\+base64|gzip\\+base64|gz\\+b64|gzip\\+b64|encrypted)$"`
 	Content            string `yaml:"content"`
 	Owner              string `yaml:"owner"`
-	Path               string `yaml:"path"`
+	Path               string `yaml:"path" merge:"key"`
 	RawFilePermissions string `yaml:"permissions" valid:"^0?[0-7]{3,4}$"`
+	Frequency          string `yaml:"frequency" valid:"^(always|once-per-instance)$"`
 }
@@ -0,0 +1,63 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MaxIncludeDepth is the number of nested includes, whether "#include"
+// documents or text/x-include-url parts, which are followed before giving up.
+const MaxIncludeDepth = 5
+
+// IncludeStack holds the URLs of the includes currently being followed, the
+// outermost first.
+type IncludeStack []string
+
+// Push returns the stack with url added, or an error if url is already being
+// followed (an include loop) or the includes are nested too deep.
+func (s IncludeStack) Push(url string) (IncludeStack, error) {
+	for _, u := range s {
+		if u == url {
+			return nil, fmt.Errorf("include loop at %s", url)
+		}
+	}
+	if len(s) >= MaxIncludeDepth {
+		return nil, fmt.Errorf("includes nested more than %d deep", MaxIncludeDepth)
+	}
+	return append(s[:len(s):len(s)], url), nil
+}
+
+// IsInclude returns whether the user-data is a list of URLs from which the
+// actual user-data is to be fetched.
+func IsInclude(userdata string) bool {
+	header := strings.SplitN(userdata, "\n", 2)[0]
+	return strings.TrimRightFunc(header, unicode.IsSpace) == "#include"
+}
+
+// IncludeURLs returns the URLs listed one per line in include user-data,
+// ignoring the header, blank lines and comments.
+func IncludeURLs(userdata string) (urls []string) {
+	for _, line := range strings.Split(userdata, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsInclude(t *testing.T) {
+	for i, tt := range []struct {
+		userdata string
+
+		include bool
+	}{
+		{"#include\nhttp://example.com/a\n", true},
+		{"#include \r\nhttp://example.com/a\r\n", true},
+		{"#include-once\nhttp://example.com/a\n", false},
+		{"#cloud-config\n#include\n", false},
+		{"", false},
+	} {
+		if include := IsInclude(tt.userdata); include != tt.include {
+			t.Errorf("bad include (#%d): want %t, got %t", i, tt.include, include)
+		}
+	}
+}
+
+func TestIncludeURLs(t *testing.T) {
+	userdata := "#include\r\nhttp://example.com/a\r\n\n  # shared keys\nhttp://example.com/b  \n"
+	want := []string{"http://example.com/a", "http://example.com/b"}
+	if urls := IncludeURLs(userdata); !reflect.DeepEqual(want, urls) {
+		t.Errorf("bad urls: want %q, got %q", want, urls)
+	}
+}
+
+func TestIncludeStackPush(t *testing.T) {
+	var s IncludeStack
+	var err error
+	for _, url := range []string{"a", "b", "c", "d", "e"} {
+		if s, err = s.Push(url); err != nil {
+			t.Fatalf("bad error (%s): want nil, got %v", url, err)
+		}
+	}
+	if _, err := s.Push("f"); err == nil {
+		t.Errorf("bad error: want too deep, got nil")
+	}
+
+	a, _ := IncludeStack{}.Push("a")
+	if _, err := a.Push("a"); err == nil {
+		t.Errorf("bad error: want loop, got nil")
+	}
+	// Siblings don't share what they push
+	b, _ := a.Push("b")
+	c, _ := a.Push("c")
+	if want := (IncludeStack{"a", "b"}); !reflect.DeepEqual(want, b) {
+		t.Errorf("bad stack: want %q, got %q", want, b)
+	}
+	if want := (IncludeStack{"a", "c"}); !reflect.DeepEqual(want, c) {
+		t.Errorf("bad stack: want %q, got %q", want, c)
+	}
+}
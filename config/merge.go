@@ -0,0 +1,139 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MergeStrategy determines how the lists of two cloud-configs are merged.
+type MergeStrategy string
+
+const (
+	// MergeAppend merges the items of lists which have a key (users by
+	// name, files by path, units by name and their drop-ins by name) with
+	// the item of the same key in the base, appending any new items. Lists
+	// without a key (e.g. ssh_authorized_keys) are appended.
+	MergeAppend MergeStrategy = "append"
+	// MergeReplace replaces the lists in the base with those set in the
+	// overlay.
+	MergeReplace MergeStrategy = "replace"
+)
+
+// ParseMergeStrategy returns the MergeStrategy with the given name.
+func ParseMergeStrategy(name string) (MergeStrategy, error) {
+	switch s := MergeStrategy(name); s {
+	case MergeAppend, MergeReplace:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown merge strategy %q", name)
+	}
+}
+
+// Merge returns a new CloudConfig with overlay merged on top of base. Options
+// which are set in overlay replace those in base; sections (e.g. etcd2 or
+// update) are merged option by option; lists are merged according to
+// strategy. Either config may be nil. Options cannot be unset by an overlay.
+func Merge(base, overlay *CloudConfig, strategy MergeStrategy) *CloudConfig {
+	var merged CloudConfig
+	if base != nil {
+		merged = *base
+	}
+	if overlay != nil {
+		mergeValue(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(*overlay), strategy)
+	}
+	return &merged
+}
+
+func mergeValue(dst, src reflect.Value, strategy MergeStrategy) {
+	switch src.Kind() {
+	case reflect.Struct:
+		st := src.Type()
+		for i := 0; i < src.NumField(); i++ {
+			if isFieldExported(st.Field(i)) {
+				mergeValue(dst.Field(i), src.Field(i), strategy)
+			}
+		}
+	case reflect.Slice:
+		if src.Len() == 0 {
+			return
+		}
+		if strategy == MergeReplace {
+			dst.Set(src)
+			return
+		}
+		// Copy into a new slice so that base's backing array is never
+		// written to.
+		merged := reflect.MakeSlice(dst.Type(), 0, dst.Len()+src.Len())
+		merged = reflect.AppendSlice(merged, dst)
+		key, ok := mergeKey(src.Type().Elem())
+		for i := 0; i < src.Len(); i++ {
+			item := src.Index(i)
+			j := -1
+			if ok && item.Field(key).String() != "" {
+				j = indexOfKey(merged, key, item.Field(key).Interface())
+			}
+			if j < 0 {
+				merged = reflect.Append(merged, item)
+				continue
+			}
+			m := reflect.New(item.Type()).Elem()
+			m.Set(merged.Index(j))
+			mergeValue(m, item, strategy)
+			merged.Index(j).Set(m)
+		}
+		dst.Set(merged)
+	case reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		merged := reflect.MakeMap(dst.Type())
+		for _, k := range dst.MapKeys() {
+			merged.SetMapIndex(k, dst.MapIndex(k))
+		}
+		for _, k := range src.MapKeys() {
+			merged.SetMapIndex(k, src.MapIndex(k))
+		}
+		dst.Set(merged)
+	default:
+		if src.Interface() != reflect.Zero(src.Type()).Interface() {
+			dst.Set(src)
+		}
+	}
+}
+
+// mergeKey returns the index of the field tagged `merge:"key"` in t, if t is
+// a struct with such a field.
+func mergeKey(t reflect.Type) (int, bool) {
+	if t.Kind() != reflect.Struct {
+		return 0, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("merge") == "key" {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func indexOfKey(items reflect.Value, key int, value interface{}) int {
+	for i := 0; i < items.Len(); i++ {
+		if items.Index(i).Field(key).Interface() == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,130 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	for i, tt := range []struct {
+		base     *CloudConfig
+		overlay  *CloudConfig
+		strategy MergeStrategy
+
+		merged *CloudConfig
+	}{
+		{
+			base:     nil,
+			overlay:  nil,
+			strategy: MergeAppend,
+			merged:   &CloudConfig{},
+		},
+		{
+			base:     &CloudConfig{Hostname: "a"},
+			overlay:  nil,
+			strategy: MergeAppend,
+			merged:   &CloudConfig{Hostname: "a"},
+		},
+		{
+			base:     nil,
+			overlay:  &CloudConfig{Hostname: "b"},
+			strategy: MergeAppend,
+			merged:   &CloudConfig{Hostname: "b"},
+		},
+		{
+			base:     &CloudConfig{Hostname: "a", ManageEtcHosts: "localhost"},
+			overlay:  &CloudConfig{Hostname: "b"},
+			strategy: MergeAppend,
+			merged:   &CloudConfig{Hostname: "b", ManageEtcHosts: "localhost"},
+		},
+		{
+			base:     &CloudConfig{CoreOS: CoreOS{Etcd2: Etcd2{Name: "a", Discovery: "d"}, Update: Update{Group: "stable"}}},
+			overlay:  &CloudConfig{CoreOS: CoreOS{Etcd2: Etcd2{Name: "b"}, Update: Update{RebootStrategy: "off"}}},
+			strategy: MergeReplace,
+			merged:   &CloudConfig{CoreOS: CoreOS{Etcd2: Etcd2{Name: "b", Discovery: "d"}, Update: Update{Group: "stable", RebootStrategy: "off"}}},
+		},
+		{
+			base:     &CloudConfig{SSHAuthorizedKeys: []string{"x"}, WriteFiles: []File{{Path: "/a", Content: "a"}, {Path: "/b", Content: "b"}}},
+			overlay:  &CloudConfig{SSHAuthorizedKeys: []string{"y"}, WriteFiles: []File{{Path: "/b", Owner: "core"}, {Path: "/c"}}},
+			strategy: MergeAppend,
+			merged:   &CloudConfig{SSHAuthorizedKeys: []string{"x", "y"}, WriteFiles: []File{{Path: "/a", Content: "a"}, {Path: "/b", Content: "b", Owner: "core"}, {Path: "/c"}}},
+		},
+		{
+			base:     &CloudConfig{SSHAuthorizedKeys: []string{"x"}, WriteFiles: []File{{Path: "/a", Content: "a"}, {Path: "/b", Content: "b"}}},
+			overlay:  &CloudConfig{SSHAuthorizedKeys: []string{"y"}, WriteFiles: []File{{Path: "/b", Owner: "core"}}},
+			strategy: MergeReplace,
+			merged:   &CloudConfig{SSHAuthorizedKeys: []string{"y"}, WriteFiles: []File{{Path: "/b", Owner: "core"}}},
+		},
+		{
+			base:     &CloudConfig{Users: []User{{Name: "core", Groups: []string{"a"}}, {Groups: []string{"x"}}}},
+			overlay:  &CloudConfig{Users: []User{{Name: "core", Groups: []string{"b"}, Shell: "/bin/sh"}, {Groups: []string{"y"}}}},
+			strategy: MergeAppend,
+			merged:   &CloudConfig{Users: []User{{Name: "core", Groups: []string{"a", "b"}, Shell: "/bin/sh"}, {Groups: []string{"x"}}, {Groups: []string{"y"}}}},
+		},
+		{
+			base: &CloudConfig{CoreOS: CoreOS{Units: []Unit{
+				{Name: "a.service", Content: "a", DropIns: []UnitDropIn{{Name: "1.conf", Content: "1"}, {Name: "2.conf", Content: "2"}}},
+			}}},
+			overlay: &CloudConfig{CoreOS: CoreOS{Units: []Unit{
+				{Name: "a.service", Command: "start", DropIns: []UnitDropIn{{Name: "2.conf", Content: "two"}, {Name: "3.conf", Content: "3"}}},
+				{Name: "b.service", Enable: true},
+			}}},
+			strategy: MergeAppend,
+			merged: &CloudConfig{CoreOS: CoreOS{Units: []Unit{
+				{Name: "a.service", Content: "a", Command: "start", DropIns: []UnitDropIn{{Name: "1.conf", Content: "1"}, {Name: "2.conf", Content: "two"}, {Name: "3.conf", Content: "3"}}},
+				{Name: "b.service", Enable: true},
+			}}},
+		},
+	} {
+		if merged := Merge(tt.base, tt.overlay, tt.strategy); !reflect.DeepEqual(tt.merged, merged) {
+			t.Errorf("bad merge (#%d): want %#v, got %#v", i, tt.merged, merged)
+		}
+	}
+}
+
+func TestMergeDoesNotModifyBase(t *testing.T) {
+	keys := make([]string, 1, 2)
+	keys[0] = "x"
+	files := []File{{Path: "/a", Content: "a"}}
+	base := &CloudConfig{SSHAuthorizedKeys: keys, WriteFiles: files}
+	Merge(base, &CloudConfig{SSHAuthorizedKeys: []string{"y"}, WriteFiles: []File{{Path: "/a", Content: "b"}}}, MergeAppend)
+	if extended := keys[:2]; extended[1] != "" {
+		t.Errorf("bad base: want %q unmodified, got %q", keys, extended)
+	}
+	if files[0].Content != "a" {
+		t.Errorf("bad base: want content %q, got %q", "a", files[0].Content)
+	}
+}
+
+func TestParseMergeStrategy(t *testing.T) {
+	for i, tt := range []struct {
+		name string
+
+		strategy MergeStrategy
+		err      bool
+	}{
+		{"append", MergeAppend, false},
+		{"replace", MergeReplace, false},
+		{"", "", true},
+		{"prepend", "", true},
+	} {
+		strategy, err := ParseMergeStrategy(tt.name)
+		if strategy != tt.strategy || (err != nil) != tt.err {
+			t.Errorf("bad strategy (#%d): want %q (error %t), got %q (%v)", i, tt.strategy, tt.err, strategy, err)
+		}
+	}
+}
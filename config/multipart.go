@@ -0,0 +1,35 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+)
+
+// IsMultipart returns whether the user-data is a MIME multipart message, as
+// produced by e.g. cloud-init's write-mime-multipart or Terraform.
+func IsMultipart(userdata string) bool {
+	for _, line := range strings.Split(userdata, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return false
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "Content-Type") {
+			return strings.HasPrefix(strings.ToLower(strings.TrimSpace(parts[1])), "multipart/")
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+)
+
+func TestIsMultipart(t *testing.T) {
+	for i, tt := range []struct {
+		userdata string
+
+		multipart bool
+	}{
+		{"Content-Type: multipart/mixed; boundary=\"x\"\nMIME-Version: 1.0\n\n--x--\n", true},
+		{"MIME-Version: 1.0\r\ncontent-type: Multipart/Mixed; boundary=x\r\n\r\n--x--\r\n", true},
+		{"Content-Type: text/cloud-config\n\nhostname: foo\n", false},
+		{"#cloud-config\nhostname: foo\n", false},
+		{"#!/bin/bash\n\nContent-Type: multipart/mixed\n", false},
+		{"", false},
+	} {
+		if multipart := IsMultipart(tt.userdata); multipart != tt.multipart {
+			t.Errorf("bad multipart (#%d): want %t, got %t", i, tt.multipart, multipart)
+		}
+	}
+}
@@ -29,3 +29,19 @@ func NewScript(userdata string) (*Script, error) {
 	s := Script(userdata)
 	return &s, nil
 }
+
+// Frequency returns how often the script should be run, as declared by a
+// "# frequency: <frequency>" line among the comments at the top of the
+// script. It defaults to FrequencyAlways.
+func (s Script) Frequency() string {
+	for _, line := range strings.Split(string(s), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		if strings.HasPrefix(line, "frequency:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "frequency:"))
+		}
+	}
+	return FrequencyAlways
+}
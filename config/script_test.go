@@ -0,0 +1,35 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+)
+
+func TestScriptFrequency(t *testing.T) {
+	for _, tt := range []struct {
+		script    string
+		frequency string
+	}{
+		{"#!/bin/bash\necho hi\n", FrequencyAlways},
+		{"#!/bin/bash\n# frequency: once-per-instance\necho hi\n", FrequencyOncePerInstance},
+		{"#!/bin/bash\n#frequency:always\n", FrequencyAlways},
+		{"#!/bin/bash\necho hi\n# frequency: once-per-instance\n", FrequencyAlways},
+	} {
+		if frequency := Script(tt.script).Frequency(); frequency != tt.frequency {
+			t.Errorf("bad frequency (%q): want %q, got %q", tt.script, tt.frequency, frequency)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// EncodingEncrypted is the encoding of write_files content which is
+	// encrypted for the host. The content is the base64 encoded ciphertext;
+	// it is only decrypted when the config is applied.
+	EncodingEncrypted = "encrypted"
+
+	// EncryptedPrefix starts any other string value which is encrypted for
+	// the host. It is followed by the base64 encoded ciphertext.
+	EncryptedPrefix = "encrypted:"
+)
+
+// HasSecrets returns whether the config contains any encrypted values.
+func (cc CloudConfig) HasSecrets() bool {
+	found := false
+	walkStrings(reflect.ValueOf(&cc).Elem(), func(v reflect.Value) error {
+		if strings.HasPrefix(v.String(), EncryptedPrefix) {
+			found = true
+		}
+		return nil
+	})
+	for _, f := range cc.WriteFiles {
+		if f.Encoding == EncodingEncrypted {
+			found = true
+		}
+	}
+	return found
+}
+
+// DecryptSecrets replaces the encrypted values in the config with their
+// plaintext, using decrypt to decrypt each ciphertext.
+func (cc *CloudConfig) DecryptSecrets(decrypt func([]byte) ([]byte, error)) error {
+	if err := walkStrings(reflect.ValueOf(cc).Elem(), func(v reflect.Value) error {
+		if !strings.HasPrefix(v.String(), EncryptedPrefix) {
+			return nil
+		}
+		ciphertext, err := DecodeBase64Content(strings.TrimSpace(strings.TrimPrefix(v.String(), EncryptedPrefix)))
+		if err != nil {
+			return err
+		}
+		plaintext, err := decrypt(ciphertext)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(plaintext))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for i, f := range cc.WriteFiles {
+		if f.Encoding != EncodingEncrypted {
+			continue
+		}
+		ciphertext, err := DecodeContent(f.Content, f.Encoding)
+		if err != nil {
+			return err
+		}
+		plaintext, err := decrypt(ciphertext)
+		if err != nil {
+			return fmt.Errorf("unable to decrypt %s: %v", f.Path, err)
+		}
+		cc.WriteFiles[i].Content = string(plaintext)
+		cc.WriteFiles[i].Encoding = ""
+	}
+	return nil
+}
+
+// walkStrings calls fn with each string reachable from v through exported
+// struct fields and slices.
+func walkStrings(v reflect.Value, fn func(reflect.Value) error) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isFieldExported(v.Type().Field(i)) {
+				continue
+			}
+			if err := walkStrings(v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkStrings(v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		return fn(v)
+	}
+	return nil
+}
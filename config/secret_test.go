@@ -0,0 +1,93 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecryptSecrets(t *testing.T) {
+	// "decrypt" by upper-casing, so that the plaintext is predictable
+	decrypt := func(ciphertext []byte) ([]byte, error) {
+		if string(ciphertext) == "bad" {
+			return nil, errors.New("bad ciphertext")
+		}
+		return bytes.ToUpper(ciphertext), nil
+	}
+
+	for i, tt := range []struct {
+		cc CloudConfig
+
+		secrets bool
+		out     CloudConfig
+		err     bool
+	}{
+		{
+			cc:  CloudConfig{Hostname: "host", WriteFiles: []File{{Path: "/a", Content: "a", Encoding: "base64"}}},
+			out: CloudConfig{Hostname: "host", WriteFiles: []File{{Path: "/a", Content: "a", Encoding: "base64"}}},
+		},
+		{
+			cc: CloudConfig{
+				Users:      []User{{Name: "core", PasswordHash: "encrypted:aGFzaA=="}},
+				CoreOS:     CoreOS{Locksmith: Locksmith{EtcdPassword: "encrypted: c2VjcmV0\n"}},
+				WriteFiles: []File{{Path: "/key.pem", Content: "a2V5", Encoding: EncodingEncrypted}, {Path: "/b", Content: "encrypted:"}},
+			},
+			secrets: true,
+			out: CloudConfig{
+				Users:      []User{{Name: "core", PasswordHash: "HASH"}},
+				CoreOS:     CoreOS{Locksmith: Locksmith{EtcdPassword: "SECRET"}},
+				WriteFiles: []File{{Path: "/key.pem", Content: "KEY"}, {Path: "/b", Content: ""}},
+			},
+		},
+		{
+			cc:      CloudConfig{Hostname: "encrypted:!!!"},
+			secrets: true,
+			err:     true,
+		},
+		{
+			cc:      CloudConfig{WriteFiles: []File{{Path: "/a", Content: "YmFk", Encoding: EncodingEncrypted}}},
+			secrets: true,
+			err:     true,
+		},
+	} {
+		if secrets := tt.cc.HasSecrets(); secrets != tt.secrets {
+			t.Errorf("bad secrets (#%d): want %t, got %t", i, tt.secrets, secrets)
+		}
+		err := tt.cc.DecryptSecrets(decrypt)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if !tt.err && !reflect.DeepEqual(tt.out, tt.cc) {
+			t.Errorf("bad config (#%d): want %#v, got %#v", i, tt.out, tt.cc)
+		}
+	}
+}
+
+func TestDecodeLeavesEncrypted(t *testing.T) {
+	cc := CloudConfig{WriteFiles: []File{
+		{Path: "/a", Content: "YQ==", Encoding: "base64"},
+		{Path: "/b", Content: "Yg==", Encoding: EncodingEncrypted},
+	}}
+	if err := cc.Decode(); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	want := []File{{Path: "/a", Content: "a"}, {Path: "/b", Content: "Yg==", Encoding: EncodingEncrypted}}
+	if !reflect.DeepEqual(want, cc.WriteFiles) {
+		t.Errorf("bad files: want %#v, got %#v", want, cc.WriteFiles)
+	}
+}
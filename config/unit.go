@@ -15,16 +15,17 @@
 package config
 
 type Unit struct {
-	Name    string       `yaml:"name"`
-	Mask    bool         `yaml:"mask"`
-	Enable  bool         `yaml:"enable"`
-	Runtime bool         `yaml:"runtime"`
-	Content string       `yaml:"content"`
-	Command string       `yaml:"command" valid:"^(start|stop|restart|reload|try-restart|reload-or-restart|reload-or-try-restart)$"`
-	DropIns []UnitDropIn `yaml:"drop_ins"`
+	Name      string       `yaml:"name" merge:"key"`
+	Mask      bool         `yaml:"mask"`
+	Enable    bool         `yaml:"enable"`
+	Runtime   bool         `yaml:"runtime"`
+	Content   string       `yaml:"content"`
+	Command   string       `yaml:"command" valid:"^(start|stop|restart|reload|try-restart|reload-or-restart|reload-or-try-restart)$"`
+	DropIns   []UnitDropIn `yaml:"drop_ins"`
+	Frequency string       `yaml:"frequency" valid:"^(always|once-per-instance)$"`
 }
 
 type UnitDropIn struct {
-	Name    string `yaml:"name"`
+	Name    string `yaml:"name" merge:"key"`
 	Content string `yaml:"content"`
 }
@@ -15,7 +15,7 @@
 package config
 
 type User struct {
-	Name                 string   `yaml:"name"`
+	Name                 string   `yaml:"name"                           merge:"key"`
 	PasswordHash         string   `yaml:"passwd"`
 	SSHAuthorizedKeys    []string `yaml:"ssh_authorized_keys"`
 	SSHImportGithubUser  string   `yaml:"coreos_ssh_import_github"       deprecated:"trying to fetch from a remote endpoint introduces too many intermittent errors"`
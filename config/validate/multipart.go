@@ -0,0 +1,176 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/coreos-cloudinit/config"
+)
+
+// FetchIncludeURL fetches the user-data listed by includes so that it can be
+// validated too. If it is nil, only the URLs themselves are checked.
+var FetchIncludeURL func(string) ([]byte, error)
+
+// validateMultipart validates each part of a MIME multipart message. The
+// entries of each part are prefixed with its number (e.g. "part 2.1" is the
+// first part nested in the second).
+func validateMultipart(userdataBytes []byte, stack config.IncludeStack) (report Report, err error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(userdataBytes))
+	if err != nil {
+		report.Error(1, fmt.Sprintf("invalid MIME multipart: %v", err))
+		return report, nil
+	}
+	err = validatePart(&report, "", msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, stack)
+	return report, err
+}
+
+// validatePart validates a single (possibly multipart) part, adding its
+// entries to report.
+func validatePart(report *Report, name, contentType, encoding string, body io.Reader, stack config.IncludeStack) error {
+	fail := func(err error) error {
+		report.Error(1, prefix(name, err.Error()))
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fail(err)
+	}
+	if strings.EqualFold(strings.TrimSpace(encoding), "base64") {
+		if data, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), "")); err != nil {
+			return fail(fmt.Errorf("invalid base64 part: %v", err))
+		}
+	}
+	if data, err = decompressIfGzip(data); err != nil {
+		return fail(err)
+	}
+
+	mediaType := "text/plain"
+	params := map[string]string{}
+	if contentType != "" {
+		if mediaType, params, err = mime.ParseMediaType(contentType); err != nil {
+			return fail(err)
+		}
+	}
+
+	var r Report
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(bytes.NewReader(data), params["boundary"])
+		for i := 1; ; i++ {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fail(err)
+			}
+			partName := fmt.Sprintf("part %d", i)
+			if name != "" {
+				partName = fmt.Sprintf("%s.%d", name, i)
+			}
+			if err := validatePart(report, partName, p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p, stack); err != nil {
+				return err
+			}
+		}
+	case mediaType == "text/cloud-config":
+		r, err = validateCloudConfig(data, Rules)
+	case mediaType == "text/x-shellscript":
+		// Scripts aren't validated
+	case mediaType == "text/x-include-url":
+		r, err = validateInclude(data, stack)
+	default:
+		r, err = validate(data, stack)
+	}
+	report.add(name, r)
+	return err
+}
+
+// validateInclude checks each of the URLs listed by an include and, if
+// FetchIncludeURL is set, validates the documents they refer to.
+func validateInclude(userdataBytes []byte, stack config.IncludeStack) (report Report, err error) {
+	for i, line := range strings.Split(string(userdataBytes), "\n") {
+		rawurl := strings.TrimSpace(line)
+		if rawurl == "" || strings.HasPrefix(rawurl, "#") {
+			continue
+		}
+		if u, err := url.Parse(rawurl); err != nil || !u.IsAbs() {
+			report.Error(i+1, fmt.Sprintf("invalid URL %q", rawurl))
+			continue
+		}
+		if FetchIncludeURL == nil {
+			continue
+		}
+		nested, err := stack.Push(rawurl)
+		if err != nil {
+			report.Error(i+1, err.Error())
+			continue
+		}
+		data, err := FetchIncludeURL(rawurl)
+		if err == nil {
+			data, err = decompressIfGzip(data)
+		}
+		if err != nil {
+			report.Error(i+1, fmt.Sprintf("failed fetching %s: %v", rawurl, err))
+			continue
+		}
+		r, err := validate(data, nested)
+		if err != nil {
+			return report, err
+		}
+		report.add(rawurl, r)
+	}
+	return report, nil
+}
+
+// decompressIfGzip returns the decompressed data if it is gzip compressed,
+// or the data as it is otherwise.
+func decompressIfGzip(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("\x1f\x8b")) {
+		return data, nil
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	return ioutil.ReadAll(gzr)
+}
+
+// add adds the entries of r to the report, prefixing their messages with
+// name.
+func (r *Report) add(name string, o Report) {
+	for _, e := range o.entries {
+		e.message = prefix(name, e.message)
+		r.entries = append(r.entries, e)
+	}
+}
+
+func prefix(name, message string) string {
+	if name == "" {
+		return message
+	}
+	return name + ": " + message
+}
@@ -0,0 +1,135 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateMultipart(t *testing.T) {
+	tests := []struct {
+		config string
+
+		report Report
+	}{
+		{
+			config: "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/cloud-config\n\n#cloud-config\nhostname: a\n--x\nContent-Type: text/x-shellscript\n\n#!/bin/sh\n--x--\n",
+		},
+		{
+			config: "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/x-shellscript\n\n#!/bin/sh\n--x\nContent-Type: text/cloud-config\n\n#cloud-config\ncoreos:\n  update:\n    reboot-strategy: false\n--x--\n",
+			report: Report{entries: []Entry{{entryError, "part 2: invalid value false", 4}}},
+		},
+		{
+			config: "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: multipart/mixed; boundary=\"y\"\n\n--y\n\n#cloud-config\ncoreos:\n  update:\n    reboot-strategy: false\n--y--\n--x--\n",
+			report: Report{entries: []Entry{{entryError, "part 1.1: invalid value false", 4}}},
+		},
+		{
+			config: "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/cloud-config\nContent-Transfer-Encoding: base64\n\n!!!\n--x--\n",
+			report: Report{entries: []Entry{{entryError, "part 1: invalid base64 part: illegal base64 data at input byte 0", 1}}},
+		},
+		{
+			config: "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\n\n{}\n--x--\n",
+			report: Report{entries: []Entry{{entryError, `part 1: must be "#cloud-config" or begin with "#!"`, 1}}},
+		},
+		{
+			config: "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/cloud-config\n\n#cloud-config\n",
+			report: Report{entries: []Entry{
+				{entryError, "part 1: unexpected EOF", 1},
+				{entryError, "multipart: NextPart: EOF", 1},
+			}},
+		},
+	}
+
+	for i, tt := range tests {
+		r, err := Validate([]byte(tt.config))
+		if err != nil {
+			t.Errorf("bad error (case #%d): want %v, got %v", i, nil, err)
+		}
+		if !reflect.DeepEqual(tt.report, r) {
+			t.Errorf("bad report (case #%d): want %+v, got %+v", i, tt.report, r)
+		}
+	}
+}
+
+func TestValidateInclude(t *testing.T) {
+	docs := map[string]string{
+		"http://a": "#cloud-config\nhostname: a\n",
+		"http://b": "#cloud-config\ncoreos:\n  update:\n    reboot-strategy: false\n",
+		"http://c": "#include\nhttp://c\n",
+		"http://m": "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/x-include-url\n\nhttp://m\n--x--\n",
+	}
+	defer func(f func(string) ([]byte, error)) { FetchIncludeURL = f }(FetchIncludeURL)
+
+	tests := []struct {
+		config string
+		fetch  bool
+
+		report Report
+	}{
+		{
+			config: "#include\nhttp://a\nhttp://missing\n",
+		},
+		{
+			config: "#include\n\nnot a url\n",
+			report: Report{entries: []Entry{{entryError, `invalid URL "not a url"`, 3}}},
+		},
+		{
+			config: "#include\nhttp://a\nhttp://b\n",
+			fetch:  true,
+			report: Report{entries: []Entry{{entryError, "http://b: invalid value false", 4}}},
+		},
+		{
+			config: "#include\nhttp://a\nhttp://missing\n",
+			fetch:  true,
+			report: Report{entries: []Entry{{entryError, "failed fetching http://missing: not found", 3}}},
+		},
+		{
+			config: "#include\nhttp://c\n",
+			fetch:  true,
+			report: Report{entries: []Entry{{entryError, "http://c: include loop at http://c", 2}}},
+		},
+		{
+			config: docs["http://m"],
+			fetch:  true,
+			report: Report{entries: []Entry{{entryError, "part 1: http://m: part 1: include loop at http://m", 1}}},
+		},
+		{
+			config: "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/x-include-url\n\nhttp://missing\n--x--\n",
+			fetch:  true,
+			report: Report{entries: []Entry{{entryError, "part 1: failed fetching http://missing: not found", 1}}},
+		},
+	}
+
+	for i, tt := range tests {
+		FetchIncludeURL = nil
+		if tt.fetch {
+			FetchIncludeURL = func(url string) ([]byte, error) {
+				if d, ok := docs[url]; ok {
+					return []byte(d), nil
+				}
+				return nil, errors.New("not found")
+			}
+		}
+		r, err := Validate([]byte(tt.config))
+		if err != nil {
+			t.Errorf("bad error (case #%d): want %v, got %v", i, nil, err)
+		}
+		if !reflect.DeepEqual(tt.report, r) {
+			t.Errorf("bad report (case #%d): want %+v, got %+v", i, tt.report, r)
+		}
+	}
+}
@@ -78,6 +78,13 @@ func TestCheckEncoding(t *testing.T) {
 		{
 			config: "write_files:\n  - encoding: gzip+base64\n    content: H4sIAOC3tVQAA8tIzcnJ5wIAIDA6NgYAAAA=",
 		},
+		{
+			config: "write_files:\n  - encoding: encrypted\n    content: AXNlY3JldA==",
+		},
+		{
+			config:  "write_files:\n  - encoding: encrypted\n    content: not base64",
+			entries: []Entry{{entryError, `content cannot be decoded as "encrypted"`, 3}},
+		},
 		{
 			config:  "write_files:\n  - encoding: custom\n    content: hello",
 			entries: []Entry{{entryError, `content cannot be decoded as "custom"`, 3}},
@@ -32,9 +32,14 @@ var (
 )
 
 // Validate runs a series of validation tests against the given userdata and
-// returns a report detailing all of the issues. Presently, only cloud-configs
-// can be validated.
+// returns a report detailing all of the issues. Cloud-configs are validated
+// on their own, as parts of a MIME multipart message or, if FetchIncludeURL
+// is set, as included documents.
 func Validate(userdataBytes []byte) (Report, error) {
+	return validate(userdataBytes, nil)
+}
+
+func validate(userdataBytes []byte, stack config.IncludeStack) (Report, error) {
 	switch {
 	case len(userdataBytes) == 0:
 		return Report{}, nil
@@ -42,6 +47,10 @@ func Validate(userdataBytes []byte) (Report, error) {
 		return Report{}, nil
 	case config.IsIgnitionConfig(string(userdataBytes)):
 		return Report{}, nil
+	case config.IsMultipart(string(userdataBytes)):
+		return validateMultipart(userdataBytes, stack)
+	case config.IsInclude(string(userdataBytes)):
+		return validateInclude(userdataBytes, stack)
 	case config.IsCloudConfig(string(userdataBytes)):
 		return validateCloudConfig(userdataBytes, Rules)
 	default:
@@ -0,0 +1,59 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+)
+
+func TestVendorDataEnabledValid(t *testing.T) {
+	tests := []struct {
+		value string
+
+		isValid bool
+	}{
+		{value: "", isValid: true},
+		{value: "true", isValid: true},
+		{value: "false", isValid: true},
+		{value: "no", isValid: false},
+	}
+
+	for _, tt := range tests {
+		isValid := (nil == AssertStructValid(VendorData{Enabled: tt.value}))
+		if tt.isValid != isValid {
+			t.Errorf("bad assert (%s): want %t, got %t", tt.value, tt.isValid, isValid)
+		}
+	}
+}
+
+func TestVendorDataDisabled(t *testing.T) {
+	for _, tt := range []struct {
+		contents string
+		disabled bool
+	}{
+		{"#cloud-config\n", false},
+		{"#cloud-config\nvendor_data:\n  enabled: true\n", false},
+		{"#cloud-config\nvendor_data:\n  enabled: false\n", true},
+		{"#cloud-config\nvendor-data:\n  enabled: false\n", true},
+	} {
+		cc, err := NewCloudConfig(tt.contents)
+		if err != nil {
+			t.Fatalf("bad error (%q): want nil, got %v", tt.contents, err)
+		}
+		if disabled := cc.VendorData.Disabled(); disabled != tt.disabled {
+			t.Errorf("bad disabled (%q): want %t, got %t", tt.contents, tt.disabled, disabled)
+		}
+	}
+}
@@ -17,25 +17,35 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	neturl "net/url"
 	"os"
+	"path"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/coreos/coreos-cloudinit/config"
 	"github.com/coreos/coreos-cloudinit/config/validate"
 	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/datasource/cache"
 	"github.com/coreos/coreos-cloudinit/datasource/configdrive"
+	"github.com/coreos/coreos-cloudinit/datasource/detect"
 	"github.com/coreos/coreos-cloudinit/datasource/file"
 	"github.com/coreos/coreos-cloudinit/datasource/metadata/cloudsigma"
 	"github.com/coreos/coreos-cloudinit/datasource/metadata/digitalocean"
 	"github.com/coreos/coreos-cloudinit/datasource/metadata/ec2"
 	"github.com/coreos/coreos-cloudinit/datasource/metadata/gce"
+	"github.com/coreos/coreos-cloudinit/datasource/metadata/openstack"
 	"github.com/coreos/coreos-cloudinit/datasource/metadata/packet"
+	"github.com/coreos/coreos-cloudinit/datasource/nocloud"
 	"github.com/coreos/coreos-cloudinit/datasource/proc_cmdline"
 	"github.com/coreos/coreos-cloudinit/datasource/url"
 	"github.com/coreos/coreos-cloudinit/datasource/vmware"
@@ -50,6 +60,7 @@ const (
 	datasourceInterval    = 100 * time.Millisecond
 	datasourceMaxInterval = 30 * time.Second
 	datasourceTimeout     = 5 * time.Minute
+	datasourceGrace       = 10 * time.Second
 )
 
 var (
@@ -59,6 +70,7 @@ var (
 		sources       struct {
 			file                        string
 			configDrive                 string
+			noCloud                     string
 			waagent                     string
 			metadataService             bool
 			ec2MetadataService          string
@@ -66,16 +78,36 @@ var (
 			cloudSigmaMetadataService   bool
 			digitalOceanMetadataService string
 			packetMetadataService       string
+			openstackMetadataService    string
 			url                         string
 			procCmdLine                 bool
 			vmware                      bool
 			ovfEnv                      string
+			auto                        bool
 		}
 		convertNetconf string
 		workspace      string
 		sshKeyName     string
 		oem            string
 		validate       bool
+		dryRun         bool
+		offline        bool
+		root           string
+		force          bool
+		printMetadata  bool
+		trustedKeys    string
+		secretKeys     string
+		encryptSecret  string
+		overlays       stringSlice
+		mergeStrategy  string
+		http           pkg.HttpConfig
+		order          string
+		grace          time.Duration
+		timeouts       timeouts
+		policies       stringSlice
+		timeoutsFile   string
+		vendorData     string
+		useCache       bool
 	}{}
 	version = "was not built properly"
 )
@@ -85,6 +117,7 @@ func init() {
 	flag.BoolVar(&flags.ignoreFailure, "ignore-failure", false, "Exits with 0 status in the event of malformed input from user-data")
 	flag.StringVar(&flags.sources.file, "from-file", "", "Read user-data from provided file")
 	flag.StringVar(&flags.sources.configDrive, "from-configdrive", "", "Read data from provided cloud-drive directory")
+	flag.StringVar(&flags.sources.noCloud, "from-nocloud", "", "Read data from provided NoCloud (cidata) seed directory")
 	flag.StringVar(&flags.sources.waagent, "from-waagent", "", "Read data from provided waagent directory")
 	flag.BoolVar(&flags.sources.metadataService, "from-metadata-service", false, "[DEPRECATED - Use -from-ec2-metadata] Download data from metadata service")
 	flag.StringVar(&flags.sources.ec2MetadataService, "from-ec2-metadata", "", "Download EC2 data from the provided url")
@@ -92,15 +125,130 @@ func init() {
 	flag.BoolVar(&flags.sources.cloudSigmaMetadataService, "from-cloudsigma-metadata", false, "Download data from CloudSigma server context")
 	flag.StringVar(&flags.sources.digitalOceanMetadataService, "from-digitalocean-metadata", "", "Download DigitalOcean data from the provided url")
 	flag.StringVar(&flags.sources.packetMetadataService, "from-packet-metadata", "", "Download Packet data from metadata service")
+	flag.StringVar(&flags.sources.openstackMetadataService, "from-openstack-metadata", "", "Download OpenStack data from the provided url")
 	flag.StringVar(&flags.sources.url, "from-url", "", "Download user-data from provided url")
 	flag.BoolVar(&flags.sources.procCmdLine, "from-proc-cmdline", false, fmt.Sprintf("Parse %s for '%s=<url>', using the cloud-config served by an HTTP GET to <url>", proc_cmdline.ProcCmdlineLocation, proc_cmdline.ProcCmdlineCloudConfigFlag))
 	flag.BoolVar(&flags.sources.vmware, "from-vmware-guestinfo", false, "Read data from VMware guestinfo")
 	flag.StringVar(&flags.sources.ovfEnv, "from-vmware-ovf-env", "", "Read data from OVF Environment")
+	flag.BoolVar(&flags.sources.auto, "from-auto", false, "Detect the datasources and OEM from DMI data, filesystem labels and the kernel command line")
+	flag.StringVar(&flags.vendorData, "vendor-data", "", "Read vendor-data from provided file, applied after that of the datasource and before the user-data")
 	flag.StringVar(&flags.oem, "oem", "", "Use the settings specific to the provided OEM")
 	flag.StringVar(&flags.convertNetconf, "convert-netconf", "", "Read the network config provided in cloud-drive and translate it from the specified format into networkd unit files")
+	flag.BoolVar(&flags.useCache, "use-cache-on-failure", false, "Apply the user-data and meta-data cached in the workspace if the datasource is unavailable or fails")
 	flag.StringVar(&flags.workspace, "workspace", "/var/lib/coreos-cloudinit", "Base directory coreos-cloudinit should use to store data")
 	flag.StringVar(&flags.sshKeyName, "ssh-key-name", initialize.DefaultSSHKeyName, "Add SSH keys to the system with the given name")
 	flag.BoolVar(&flags.validate, "validate", false, "[EXPERIMENTAL] Validate the user-data but do not apply it to the system")
+	flag.BoolVar(&flags.dryRun, "dry-run", false, "Print the changes that applying the user-data would make, without making them")
+	flag.BoolVar(&flags.offline, "offline", false, "Apply the user-data to the files under -root without using D-Bus or running any units (e.g. when building an image)")
+	flag.StringVar(&flags.root, "root", "/", "Root directory of the system to which the user-data should be applied")
+	flag.BoolVar(&flags.force, "force", false, "Apply every part of the user-data, even if it has not changed since the last run")
+	flag.BoolVar(&flags.printMetadata, "print-metadata", false, "Print the meta-data fetched from the datasource as JSON and exit")
+	flag.StringVar(&flags.trustedKeys, "trusted-keys", "", "Only apply user-data signed by one of the Ed25519 public keys in the provided directory")
+	flag.StringVar(&flags.secretKeys, "secret-keys", "/etc/coreos-cloudinit/secret-keys", "File or directory holding the X25519 private keys with which encrypted values in the cloud-config are decrypted")
+	flag.StringVar(&flags.encryptSecret, "encrypt-secret", "", "Encrypt stdin for the X25519 public key in the provided file, print it as an encrypted cloud-config value and exit")
+	flag.Var(&flags.overlays, "overlay", "Merge the cloud-config in the provided file on top of the user-data (may be given more than once)")
+	flag.StringVar(&flags.mergeStrategy, "merge-strategy", string(config.MergeAppend), "How lists are merged when applying -overlay: 'append' or 'replace'")
+	flag.StringVar(&flags.order, "datasource-order", "", "Comma-separated datasources in order of priority, named after their -from-* flags (e.g. 'configdrive,ec2-metadata'). A datasource is only used if those before it are unavailable or do not become available within -datasource-grace")
+	flag.DurationVar(&flags.grace, "datasource-grace", datasourceGrace, "How long to wait for a datasource of higher priority with -datasource-order before using an available one of lower priority")
+	flag.DurationVar(&flags.timeouts.timeout, "datasource-timeout", datasourceTimeout, "How long to wait for any datasource to become available")
+	flag.DurationVar(&flags.timeouts.defaults.interval, "datasource-interval", datasourceInterval, "How long to wait before checking the availability of a datasource again at first")
+	flag.DurationVar(&flags.timeouts.defaults.maxInterval, "datasource-max-interval", datasourceMaxInterval, "The longest to wait before checking the availability of a datasource again")
+	flag.Var(&flags.policies, "datasource-policy", "Set the timeout, interval or max-interval of a single datasource, e.g. 'ec2-metadata.timeout=30s' (may be given more than once)")
+	flag.StringVar(&flags.timeoutsFile, "timeouts-file", "/etc/coreos-cloudinit/timeouts.conf", fmt.Sprintf("Read '<setting>=<value>' lines for the -datasource-* and -http-* timeout and retry flags from the provided file. They may also be given as '%s<setting>=<value>' in %s", timeoutCmdlinePrefix, proc_cmdline.ProcCmdlineLocation))
+	flag.DurationVar(&flags.http.Timeout, "http-timeout", 10*time.Second, "Timeout of each HTTP(S) request")
+	flag.IntVar(&flags.http.MaxRetries, "http-retries", 15, "How many times to try an HTTP(S) request which fails with a network or server error")
+	flag.DurationVar(&flags.http.InitialBackoff, "http-initial-backoff", 50*time.Millisecond, "How long to wait before retrying an HTTP(S) request at first")
+	flag.DurationVar(&flags.http.MaxBackoff, "http-max-backoff", 5*time.Second, "The longest to wait before retrying an HTTP(S) request")
+	flag.StringVar(&flags.http.Proxy, "http-proxy", "", fmt.Sprintf("Make HTTP(S) requests through the provided proxy instead of the one in HTTPS_PROXY (or '%s=' in %s)", proc_cmdline.ProcCmdlineProxyFlag, proc_cmdline.ProcCmdlineLocation))
+	flag.StringVar(&flags.http.NoProxy, "http-no-proxy", "", fmt.Sprintf("Comma-separated hosts, domains and CIDRs which are not reached through -http-proxy instead of NO_PROXY (or '%s=')", proc_cmdline.ProcCmdlineNoProxyFlag))
+	flag.StringVar(&flags.http.CAFile, "http-ca-file", "", fmt.Sprintf("Trust the PEM certificates in the provided file in addition to the system's for HTTPS requests (or '%s=')", proc_cmdline.ProcCmdlineCAFlag))
+	flag.StringVar(&flags.http.CertFile, "http-client-cert", "", fmt.Sprintf("Present the PEM client certificate in the provided file for HTTPS requests (or '%s=')", proc_cmdline.ProcCmdlineCertFlag))
+	flag.StringVar(&flags.http.KeyFile, "http-client-key", "", fmt.Sprintf("PEM private key of -http-client-cert (or '%s=')", proc_cmdline.ProcCmdlineKeyFlag))
+	flag.StringVar(&flags.http.BearerToken, "http-bearer-token", "", fmt.Sprintf("Send the provided token in an 'Authorization: Bearer' header with HTTPS requests to the host of the config URL (or '%s=')", proc_cmdline.ProcCmdlineTokenFlag))
+}
+
+// loadTimeouts applies the timeout settings from the -timeouts-file and the
+// kernel command line to the flags which were not set explicitly.
+func loadTimeouts() error {
+	set := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		if isTimeoutKey(f.Name) {
+			set[f.Name] = f.Value.String()
+		}
+	})
+	for _, p := range flags.policies {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid -datasource-policy %q: expected <datasource>.<setting>=<value>", p)
+		}
+		set[parts[0]] = parts[1]
+	}
+
+	settings, err := timeoutSettings(flags.timeoutsFile, proc_cmdline.ProcCmdlineLocation, set)
+	if err != nil {
+		return err
+	}
+	return parseTimeouts(settings, &flags.timeouts, &flags.http)
+}
+
+// applyOEMConfig sets the flags of the given OEM, other than those in
+// explicit. An explicit -datasource-order takes precedence over the OEM's.
+func applyOEMConfig(c oemConfig, explicit map[string]bool) {
+	for k, v := range c {
+		if explicit[k] || k == "datasource-order" && flags.order != "" {
+			continue
+		}
+		flag.Set(k, v)
+	}
+}
+
+// explicitFlags returns the names of the flags given on the command line.
+func explicitFlags() map[string]bool {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// httpConfig returns the HTTP client settings given with the -http-* flags,
+// falling back to the options in the kernel command line at cmdline.
+func httpConfig(cmdline string) pkg.HttpConfig {
+	cfg := flags.http
+	// The bearer token is only sent to the host serving the config
+	if u, err := neturl.Parse(flags.sources.url); err == nil {
+		cfg.BearerTokenHost = u.Host
+	}
+	kcfg, err := proc_cmdline.HttpConfig(cmdline)
+	if err != nil {
+		return cfg
+	}
+	for _, opt := range []struct{ flag, kernel *string }{
+		{&cfg.Proxy, &kcfg.Proxy},
+		{&cfg.NoProxy, &kcfg.NoProxy},
+		{&cfg.CAFile, &kcfg.CAFile},
+		{&cfg.CertFile, &kcfg.CertFile},
+		{&cfg.KeyFile, &kcfg.KeyFile},
+		{&cfg.BearerToken, &kcfg.BearerToken},
+		{&cfg.BearerTokenHost, &kcfg.BearerTokenHost},
+	} {
+		if *opt.flag == "" {
+			*opt.flag = *opt.kernel
+		}
+	}
+	return cfg
+}
+
+// stringSlice is a flag.Value which may be given more than once.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
 }
 
 type oemConfig map[string]string
@@ -113,6 +261,7 @@ var (
 		"ec2-compat": {
 			"from-ec2-metadata": "http://169.254.169.254/",
 			"from-configdrive":  "/media/configdrive",
+			"datasource-order":  "configdrive,ec2-metadata",
 		},
 		"gce": {
 			"from-gce-metadata": "http://metadata.google.internal/",
@@ -127,6 +276,10 @@ var (
 		"cloudsigma": {
 			"from-cloudsigma-metadata": "true",
 		},
+		"openstack": {
+			"from-openstack-metadata": "http://169.254.169.254/",
+			"convert-netconf":         "openstack",
+		},
 		"packet": {
 			"from-packet-metadata": "https://metadata.packet.net/",
 		},
@@ -148,10 +301,28 @@ func main() {
 
 	flag.Parse()
 
-	if c, ok := oemConfigs[flags.oem]; ok {
-		for k, v := range c {
-			flag.Set(k, v)
+	if flags.sources.auto {
+		// What is detected only fills in the flags not given explicitly
+		explicit := explicitFlags()
+		detected := detect.Detect("/")
+		for _, reason := range detected.Reasons {
+			log.Printf("Detected %s\n", reason)
+		}
+		if len(detected.Reasons) == 0 {
+			log.Println("Unable to detect any datasources")
+		}
+		if c, ok := oemConfigs[detected.OEM]; ok && flags.oem == "" {
+			applyOEMConfig(c, explicit)
+		}
+		for k, v := range detected.Flags {
+			if !explicit[k] {
+				flag.Set(k, v)
+			}
 		}
+	}
+
+	if c, ok := oemConfigs[flags.oem]; ok {
+		applyOEMConfig(c, nil)
 	} else if flags.oem != "" {
 		oems := make([]string, 0, len(oemConfigs))
 		for k := range oemConfigs {
@@ -166,69 +337,161 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flags.encryptSecret != "" {
+		if err := encryptSecret(flags.encryptSecret, os.Stdin, os.Stdout); err != nil {
+			fmt.Printf("Failed encrypting secret: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	switch flags.convertNetconf {
 	case "":
 	case "debian":
+	case "netplan":
+	case "openstack":
 	case "packet":
 	case "vmware":
 	default:
-		fmt.Printf("Invalid option to -convert-netconf: '%s'. Supported options: 'debian, packet, vmware'\n", flags.convertNetconf)
+		fmt.Printf("Invalid option to -convert-netconf: '%s'. Supported options: 'debian, netplan, openstack, packet, vmware'\n", flags.convertNetconf)
+		os.Exit(2)
+	}
+
+	mergeStrategy, err := config.ParseMergeStrategy(flags.mergeStrategy)
+	if err != nil {
+		fmt.Printf("Invalid option to -merge-strategy: '%s'. Supported options: 'append, replace'\n", flags.mergeStrategy)
 		os.Exit(2)
 	}
 
+	if err := loadTimeouts(); err != nil {
+		fmt.Printf("Invalid timeout settings: %v\n", err)
+		os.Exit(2)
+	}
+
+	if err := pkg.Configure(httpConfig(proc_cmdline.ProcCmdlineLocation)); err != nil {
+		log.Printf("Failed configuring the HTTP client: %v\n", err)
+		os.Exit(1)
+	}
+
 	dss := getDatasources()
 	if len(dss) == 0 {
-		fmt.Println("Provide at least one of --from-file, --from-configdrive, --from-ec2-metadata, --from-gce-metadata, --from-cloudsigma-metadata, --from-packet-metadata, --from-digitalocean-metadata, --from-vmware-guestinfo, --from-waagent, --from-url or --from-proc-cmdline")
+		fmt.Println("Provide at least one of --from-file, --from-configdrive, --from-nocloud, --from-ec2-metadata, --from-gce-metadata, --from-cloudsigma-metadata, --from-packet-metadata, --from-openstack-metadata, --from-digitalocean-metadata, --from-vmware-guestinfo, --from-waagent, --from-url, --from-proc-cmdline or --from-auto")
 		os.Exit(2)
 	}
 
-	ds := selectDatasource(dss)
+	dss, grace, err := orderDatasources(dss, flags.order, flags.grace)
+	if err != nil {
+		fmt.Printf("Invalid option to -datasource-order: %v\n", err)
+		os.Exit(2)
+	}
+
+	workspace := path.Join(flags.root, flags.workspace)
+	requested := make([]datasource.Datasource, 0, len(dss))
+	for _, ds := range dss {
+		requested = append(requested, ds.Datasource)
+	}
+	cached := cache.NewDatasource(workspace, requested)
+	fromCache := false
+	ds := selectDatasource(dss, grace, flags.timeouts)
 	if ds == nil {
-		log.Println("No datasources available in time")
-		os.Exit(1)
+		if !flags.useCache || !cached.IsAvailable() {
+			log.Println("No datasources available in time")
+			os.Exit(1)
+		}
+		warnCached(cached.Saved, "No datasources available in time")
+		ds, fromCache = cached, true
 	}
 
-	log.Printf("Fetching user-data from datasource of type %q\n", ds.Type())
-	userdataBytes, err := ds.FetchUserdata()
-	if err != nil {
-		log.Printf("Failed fetching user-data from datasource: %v. Continuing...\n", err)
+	userdataBytes, udErr, metadata, mdErr := fetchData(ds)
+	if (udErr != nil || mdErr != nil) && !fromCache && flags.useCache && cached.IsAvailable() {
+		warnCached(cached.Saved, fmt.Sprintf("Failed fetching from datasource of type %q", ds.Type()))
+		ds, fromCache = cached, true
+		userdataBytes, udErr, metadata, mdErr = fetchData(ds)
+	}
+	if udErr != nil {
 		failure = true
 	}
+	if mdErr != nil {
+		os.Exit(1)
+	}
+
+	env := initialize.NewEnvironment(flags.root, ds.ConfigRoot(), flags.workspace, flags.sshKeyName, metadata)
+	env.SetDatasourceType(ds.Type())
+
+	// Nothing but the meta-data is needed to print it
+	if flags.printMetadata {
+		if err := printMetadata(env); err != nil {
+			log.Printf("Failed printing meta-data: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	var keys initialize.TrustedKeys
+	var signature []byte
+	rawUserdata := userdataBytes
+	fetchInclude := pkg.NewFetcher().GetRetry
+	if flags.trustedKeys != "" {
+		keys, err = initialize.LoadTrustedKeys(flags.trustedKeys)
+		if err != nil {
+			log.Printf("Failed loading trusted keys: %v\n", err)
+			os.Exit(1)
+		}
+		if signature, err = fetchSignature(ds); err != nil {
+			log.Printf("Refusing to apply user-data: %v\n", err)
+			os.Exit(1)
+		}
+		if userdataBytes, err = keys.Verify(userdataBytes, signature); err != nil {
+			log.Printf("Refusing to apply user-data: %v\n", err)
+			os.Exit(1)
+		}
+		fetchInclude = verifiedFetch(fetchInclude, keys)
+	}
+
+	// Only what was fetched in full (and verified) replaces the cache
+	if udErr == nil && !fromCache && !flags.dryRun {
+		if err := cache.Save(workspace, ds, rawUserdata, signature, metadata); err != nil {
+			log.Printf("Failed caching user-data and meta-data: %v\n", err)
+		}
+	}
+	// Includes are resolved, validated and parsed separately, but each
+	// is only fetched once
+	fetchInclude = fetchOnce(fetchInclude)
+	initialize.FetchIncludeURL = fetchInclude
+	validate.FetchIncludeURL = fetchInclude
 	userdataBytes, err = decompressIfGzip(userdataBytes)
 	if err != nil {
 		log.Printf("Failed decompressing user-data from datasource: %v. Continuing...\n", err)
 		failure = true
 	}
-
-	if report, err := validate.Validate(userdataBytes); err == nil {
-		ret := 0
-		for _, e := range report.Entries() {
-			log.Println(e)
-			ret = 1
-		}
-		if flags.validate {
-			os.Exit(ret)
-		}
-	} else {
-		log.Printf("Failed while validating user_data (%q)\n", err)
+	userdataBytes, err = resolveIncludes(userdataBytes, fetchInclude)
+	if err != nil {
 		if flags.validate {
+			log.Printf("Failed including user-data: %v\n", err)
 			os.Exit(1)
 		}
+		log.Printf("Failed including user-data: %v. Continuing...\n", err)
+		failure = true
 	}
 
-	log.Printf("Fetching meta-data from datasource of type %q\n", ds.Type())
-	metadata, err := ds.FetchMetadata()
-	if err != nil {
-		log.Printf("Failed fetching meta-data from datasource: %v\n", err)
-		os.Exit(1)
+	// Templates can only be validated once they have been rendered
+	templated := initialize.IsTemplate(string(userdataBytes))
+	if !templated {
+		validateUserdata(userdataBytes)
 	}
 
 	// Apply environment to user-data
-	env := initialize.NewEnvironment("/", ds.ConfigRoot(), flags.workspace, flags.sshKeyName, metadata)
-	userdata := env.Apply(string(userdataBytes))
+	userdata, err := env.Render(string(userdataBytes))
+	if err != nil {
+		log.Printf("Failed rendering user-data template: %v\n", err)
+		os.Exit(1)
+	}
+	if templated {
+		validateUserdata([]byte(userdata))
+	}
 
 	var ccu *config.CloudConfig
-	var script *config.Script
+	var scripts []config.Script
 	switch ud, err := initialize.ParseUserData(userdata); err {
 	case initialize.ErrIgnitionConfig:
 		fmt.Printf("Detected an Ignition config. Exiting...")
@@ -238,22 +501,79 @@ func main() {
 		case *config.CloudConfig:
 			ccu = t
 		case *config.Script:
-			script = t
+			scripts = append(scripts, *t)
+		case *initialize.UserData:
+			ccu = t.CloudConfig
+			scripts = t.Scripts
 		}
 	default:
 		fmt.Printf("Failed to parse user-data: %v\nContinuing...\n", err)
 		failure = true
 	}
 
+	if ccu != nil && ccu.VendorData.Disabled() {
+		log.Println("Vendor-data is disabled by user-data")
+	} else if vendor, vendorScripts, err := loadVendordata(ds, flags.vendorData, keys, fetchInclude, env); err != nil {
+		log.Printf("Failed loading vendor-data: %v. Continuing...\n", err)
+		failure = true
+	} else if vendor != nil || len(vendorScripts) > 0 {
+		log.Println("Merging user-data on top of vendor-data")
+		if vendor != nil {
+			ccu = config.Merge(vendor, ccu, mergeStrategy)
+		}
+		scripts = append(vendorScripts, scripts...)
+	}
+
+	if len(flags.overlays) > 0 {
+		if ccu, err = applyOverlays(ccu, flags.overlays, mergeStrategy, env); err != nil {
+			log.Printf("Failed to apply overlay: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	log.Println("Merging cloud-config from meta-data and user-data")
 	cc := mergeConfigs(ccu, metadata)
 
+	if cc.HasSecrets() {
+		keys, err := initialize.LoadSecretKeys(flags.secretKeys)
+		if err != nil {
+			log.Printf("Failed loading secret keys: %v\n", err)
+			os.Exit(1)
+		}
+		// A dry run prints what would be written, so it must not see
+		// the plaintext
+		decrypt := keys.Decrypt
+		if flags.dryRun {
+			decrypt = keys.DecryptRedacted
+		}
+		if err := cc.DecryptSecrets(decrypt); err != nil {
+			log.Printf("Failed decrypting secrets: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	var ifaces []network.InterfaceGenerator
 	if flags.convertNetconf != "" {
 		var err error
 		switch flags.convertNetconf {
 		case "debian":
+			if _, ok := metadata.NetworkConfig.(datasource.OpenStackNetworkData); ok {
+				err = fmt.Errorf("network config is OpenStack network_data.json, not Debian interfaces")
+				break
+			}
 			ifaces, err = network.ProcessDebianNetconf(metadata.NetworkConfig.([]byte))
+		case "netplan":
+			netconf, _ := metadata.NetworkConfig.([]byte)
+			ifaces, err = network.ProcessNetplanNetconf(netconf)
+		case "openstack":
+			switch netconf := metadata.NetworkConfig.(type) {
+			case []byte:
+				// The legacy network_config content is a Debian
+				// interfaces file
+				ifaces, err = network.ProcessDebianNetconf(netconf)
+			case datasource.OpenStackNetworkData:
+				ifaces, err = network.ProcessOpenStackNetconf(netconf)
+			}
 		case "packet":
 			ifaces, err = network.ProcessPacketNetconf(metadata.NetworkConfig.(packet.NetworkData))
 		case "vmware":
@@ -267,15 +587,58 @@ func main() {
 		}
 	}
 
-	if err = initialize.Apply(cc, ifaces, env); err != nil {
+	var host system.Host = system.NewHost(env.Root())
+	if flags.offline {
+		host = system.NewOfflineHost(env.Root())
+	}
+	plan := system.NewPlan(env.Root())
+	if flags.dryRun {
+		host = plan
+	}
+
+	state, err := initialize.LoadState(env.Workspace())
+	if err != nil {
+		log.Printf("Failed to load the applied state, applying everything: %v\n", err)
+		state = initialize.NewState()
+	}
+	if flags.force {
+		state = initialize.NewState()
+	}
+	state.SetInstanceID(metadata.InstanceID)
+	if state.NewInstance() {
+		log.Printf("First run on instance %q\n", metadata.InstanceID)
+	}
+	if !state.SetInputs(userdataBytes, metadata) {
+		log.Println("User-data and meta-data are unchanged since the last run")
+	}
+
+	if err = initialize.Apply(cc, ifaces, env, host, state); err != nil {
 		log.Printf("Failed to apply cloud-config: %v\n", err)
 		os.Exit(1)
 	}
 
-	if script != nil {
-		if err = runScript(*script, env); err != nil {
-			log.Printf("Failed to run script: %v\n", err)
-			os.Exit(1)
+	if !flags.dryRun {
+		if err = state.Save(env.Workspace()); err != nil {
+			log.Printf("Failed to save the applied state: %v\n", err)
+		}
+	}
+
+	if flags.dryRun {
+		fmt.Print(plan)
+	}
+	for _, script := range scripts {
+		switch {
+		case flags.dryRun:
+			fmt.Println("run user-data script")
+		case flags.offline:
+			log.Println("Offline, not running user-data script")
+		case script.Frequency() == config.FrequencyOncePerInstance && !state.NewInstance():
+			log.Println("Not running user-data script, it is only run once per instance")
+		default:
+			if err = runScript(script, env); err != nil {
+				log.Printf("Failed to run script: %v\n", err)
+				os.Exit(1)
+			}
 		}
 	}
 
@@ -284,6 +647,154 @@ func main() {
 	}
 }
 
+// encryptSecret encrypts everything read from in for the public key in the
+// file at keyPath and writes it to out as an encrypted cloud-config value.
+func encryptSecret(keyPath string, in io.Reader, out io.Writer) error {
+	keyData, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	pub, err := initialize.ParseSecretPublicKey(keyData)
+	if err != nil {
+		return err
+	}
+	plaintext, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := initialize.EncryptSecret(pub, plaintext)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s%s\n", config.EncryptedPrefix, base64.StdEncoding.EncodeToString(ciphertext))
+	return err
+}
+
+// fetchData fetches the user-data and meta-data from ds, logging any errors.
+func fetchData(ds datasource.Datasource) (userdata []byte, udErr error, metadata datasource.Metadata, mdErr error) {
+	log.Printf("Fetching user-data from datasource of type %q\n", ds.Type())
+	if userdata, udErr = ds.FetchUserdata(); udErr != nil {
+		log.Printf("Failed fetching user-data from datasource: %v. Continuing...\n", udErr)
+	}
+
+	log.Printf("Fetching meta-data from datasource of type %q\n", ds.Type())
+	if metadata, mdErr = ds.FetchMetadata(); mdErr != nil {
+		log.Printf("Failed fetching meta-data from datasource: %v\n", mdErr)
+	}
+	return
+}
+
+// warnCached warns that the user-data and meta-data cached at the time
+// returned by saved are applied instead of those of the live datasource, and
+// why.
+func warnCached(saved func() (time.Time, error), reason string) {
+	t, err := saved()
+	if err != nil {
+		log.Printf("WARNING: %s, applying the cached user-data and meta-data\n", reason)
+		return
+	}
+	log.Printf("WARNING: %s, applying the user-data and meta-data cached at %s\n", reason, t.Format(time.RFC3339))
+}
+
+// fetchSignature fetches the detached signature of the user-data from ds, if
+// it provides one. Otherwise the signature must be carried by the user-data
+// itself.
+func fetchSignature(ds datasource.Datasource) ([]byte, error) {
+	s, ok := ds.(datasource.Signed)
+	if !ok {
+		return nil, nil
+	}
+	sig, err := s.FetchUserdataSignature()
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching signature: %v", err)
+	}
+	return sig, nil
+}
+
+// verifiedFetch returns a function which fetches a URL with get and checks
+// that what it fetched was signed by one of the keys, using the signature at
+// the URL with datasource.SignatureSuffix appended, or that carried by the
+// document itself. Data URLs have no detached signature, so they must carry
+// their own.
+func verifiedFetch(get func(string) ([]byte, error), keys initialize.TrustedKeys) func(string) ([]byte, error) {
+	return func(url string) ([]byte, error) {
+		data, err := get(url)
+		if err != nil {
+			return nil, err
+		}
+		var sig []byte
+		if !datasource.IsDataURL(url) {
+			sig, err = get(url + datasource.SignatureSuffix)
+			if _, ok := err.(pkg.ErrNotFound); ok {
+				sig = nil
+			} else if err != nil {
+				return nil, fmt.Errorf("failed fetching signature: %v", err)
+			}
+		}
+		if data, err = keys.Verify(data, sig); err != nil {
+			return nil, fmt.Errorf("%s: %v", url, err)
+		}
+		return data, nil
+	}
+}
+
+// printMetadata prints the normalized meta-data of env as JSON.
+func printMetadata(env *initialize.Environment) error {
+	md, err := env.Metadata()
+	if err != nil {
+		return err
+	}
+	out, err := md.JSON()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// fetchOnce returns a fetch function which only calls fetch the first time
+// each URL is asked for, and returns the same result every other time. The
+// same includes are followed when resolving, validating and parsing the
+// user-data.
+func fetchOnce(fetch func(string) ([]byte, error)) func(string) ([]byte, error) {
+	type result struct {
+		data []byte
+		err  error
+	}
+	var mu sync.Mutex
+	fetched := map[string]result{}
+	return func(url string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		r, ok := fetched[url]
+		if !ok {
+			r.data, r.err = fetch(url)
+			fetched[url] = r
+		}
+		return r.data, r.err
+	}
+}
+
+// validateUserdata logs any problems found in the user-data. If -validate was
+// given, it exits instead of returning.
+func validateUserdata(userdataBytes []byte) {
+	if report, err := validate.Validate(userdataBytes); err == nil {
+		ret := 0
+		for _, e := range report.Entries() {
+			log.Println(e)
+			ret = 1
+		}
+		if flags.validate {
+			os.Exit(ret)
+		}
+	} else {
+		log.Printf("Failed while validating user_data (%q)\n", err)
+		if flags.validate {
+			os.Exit(1)
+		}
+	}
+}
+
 // mergeConfigs merges certain options from md (meta-data from the datasource)
 // onto cc (a CloudConfig derived from user-data), if they are not already set
 // on cc (i.e. user-data always takes precedence)
@@ -305,101 +816,366 @@ func mergeConfigs(cc *config.CloudConfig, md datasource.Metadata) (out config.Cl
 	return
 }
 
+// applyOverlays merges the cloud-configs in the given files, in order, on top
+// of cc.
+func applyOverlays(cc *config.CloudConfig, paths []string, strategy config.MergeStrategy, env *initialize.Environment) (*config.CloudConfig, error) {
+	for _, path := range paths {
+		log.Printf("Merging cloud-config from %s\n", path)
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if contents, err = decompressIfGzip(contents); err != nil {
+			return nil, err
+		}
+		rendered, err := env.Render(string(contents))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		ud, err := initialize.ParseUserData(rendered)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		overlay, ok := ud.(*config.CloudConfig)
+		if !ok {
+			return nil, fmt.Errorf("%s: not a cloud-config", path)
+		}
+		cc = config.Merge(cc, overlay, strategy)
+	}
+	return cc, nil
+}
+
+// loadVendordata fetches the vendor-data of the datasource, if it provides
+// any, and reads the vendor-data in path, if given, and parses them in that
+// order like user-data. Their cloud-configs are merged and their scripts are
+// returned in order. If keys are given, vendor-data must be signed inline by
+// one of them.
+func loadVendordata(ds datasource.Datasource, path string, keys initialize.TrustedKeys, fetch func(string) ([]byte, error), env *initialize.Environment) (*config.CloudConfig, []config.Script, error) {
+	type vendordata struct {
+		origin string
+		data   []byte
+	}
+	var vds []vendordata
+	if v, ok := ds.(datasource.Vendordata); ok {
+		log.Printf("Fetching vendor-data from datasource of type %q\n", ds.Type())
+		data, err := v.FetchVendordata()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed fetching vendor-data from datasource: %v", err)
+		}
+		vds = append(vds, vendordata{ds.Type(), data})
+	}
+	if path != "" {
+		log.Printf("Reading vendor-data from %s\n", path)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		vds = append(vds, vendordata{path, data})
+	}
+
+	var cc *config.CloudConfig
+	var scripts []config.Script
+	for _, vd := range vds {
+		if len(vd.data) == 0 {
+			continue
+		}
+		data := vd.data
+		var err error
+		if keys != nil {
+			if data, err = keys.Verify(data, nil); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", vd.origin, err)
+			}
+		}
+		if data, err = decompressIfGzip(data); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", vd.origin, err)
+		}
+		if data, err = resolveIncludes(data, fetch); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", vd.origin, err)
+		}
+		rendered, err := env.Render(string(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", vd.origin, err)
+		}
+
+		ud, err := initialize.ParseUserData(rendered)
+		if err == initialize.ErrIgnitionConfig {
+			log.Printf("Ignoring Ignition config in vendor-data from %s\n", vd.origin)
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", vd.origin, err)
+		}
+		switch t := ud.(type) {
+		case *config.CloudConfig:
+			cc = config.Merge(cc, t, config.MergeAppend)
+		case *config.Script:
+			scripts = append(scripts, *t)
+		case *initialize.UserData:
+			if t.CloudConfig != nil {
+				cc = config.Merge(cc, t.CloudConfig, config.MergeAppend)
+			}
+			scripts = append(scripts, t.Scripts...)
+		}
+	}
+	return cc, scripts, nil
+}
+
 // getDatasources creates a slice of possible Datasources for cloudinit based
 // on the different source command-line flags.
-func getDatasources() []datasource.Datasource {
-	dss := make([]datasource.Datasource, 0, 5)
+func getDatasources() []namedDatasource {
+	dss := make([]namedDatasource, 0, 5)
 	if flags.sources.file != "" {
-		dss = append(dss, file.NewDatasource(flags.sources.file))
+		dss = append(dss, namedDatasource{"file", file.NewDatasource(flags.sources.file)})
 	}
 	if flags.sources.url != "" {
-		dss = append(dss, url.NewDatasource(flags.sources.url))
+		dss = append(dss, namedDatasource{"url", url.NewDatasource(flags.sources.url)})
 	}
 	if flags.sources.configDrive != "" {
-		dss = append(dss, configdrive.NewDatasource(flags.sources.configDrive))
+		dss = append(dss, namedDatasource{"configdrive", configdrive.NewDatasource(flags.sources.configDrive)})
+	}
+	if flags.sources.noCloud != "" {
+		dss = append(dss, namedDatasource{"nocloud", nocloud.NewDatasource(flags.sources.noCloud)})
 	}
 	if flags.sources.metadataService {
-		dss = append(dss, ec2.NewDatasource(ec2.DefaultAddress))
+		dss = append(dss, namedDatasource{"metadata-service", ec2.NewDatasource(ec2.DefaultAddress)})
 	}
 	if flags.sources.ec2MetadataService != "" {
-		dss = append(dss, ec2.NewDatasource(flags.sources.ec2MetadataService))
+		dss = append(dss, namedDatasource{"ec2-metadata", ec2.NewDatasource(flags.sources.ec2MetadataService)})
 	}
 	if flags.sources.gceMetadataService != "" {
-		dss = append(dss, gce.NewDatasource(flags.sources.gceMetadataService))
+		dss = append(dss, namedDatasource{"gce-metadata", gce.NewDatasource(flags.sources.gceMetadataService)})
 	}
 	if flags.sources.cloudSigmaMetadataService {
-		dss = append(dss, cloudsigma.NewServerContextService())
+		dss = append(dss, namedDatasource{"cloudsigma-metadata", cloudsigma.NewServerContextService()})
 	}
 	if flags.sources.digitalOceanMetadataService != "" {
-		dss = append(dss, digitalocean.NewDatasource(flags.sources.digitalOceanMetadataService))
+		dss = append(dss, namedDatasource{"digitalocean-metadata", digitalocean.NewDatasource(flags.sources.digitalOceanMetadataService)})
 	}
 	if flags.sources.waagent != "" {
-		dss = append(dss, waagent.NewDatasource(flags.sources.waagent))
+		dss = append(dss, namedDatasource{"waagent", waagent.NewDatasource(flags.sources.waagent)})
 	}
 	if flags.sources.packetMetadataService != "" {
-		dss = append(dss, packet.NewDatasource(flags.sources.packetMetadataService))
+		dss = append(dss, namedDatasource{"packet-metadata", packet.NewDatasource(flags.sources.packetMetadataService)})
+	}
+	if flags.sources.openstackMetadataService != "" {
+		dss = append(dss, namedDatasource{"openstack-metadata", openstack.NewDatasource(flags.sources.openstackMetadataService)})
 	}
 	if flags.sources.procCmdLine {
-		dss = append(dss, proc_cmdline.NewDatasource())
+		dss = append(dss, namedDatasource{"proc-cmdline", proc_cmdline.NewDatasource()})
 	}
 	if flags.sources.vmware {
-		dss = append(dss, vmware.NewDatasource(""))
+		dss = append(dss, namedDatasource{"vmware-guestinfo", vmware.NewDatasource("")})
 	}
 	if flags.sources.ovfEnv != "" {
-		dss = append(dss, vmware.NewDatasource(flags.sources.ovfEnv))
+		dss = append(dss, namedDatasource{"vmware-ovf-env", vmware.NewDatasource(flags.sources.ovfEnv)})
 	}
 	return dss
 }
 
-// selectDatasource attempts to choose a valid Datasource to use based on its
-// current availability. The first Datasource to report to be available is
-// returned. Datasources will be retried if possible if they are not
-// immediately available. If all Datasources are permanently unavailable or
-// datasourceTimeout is reached before one becomes available, nil is returned.
-func selectDatasource(sources []datasource.Datasource) datasource.Datasource {
-	ds := make(chan datasource.Datasource)
-	stop := make(chan struct{})
-	var wg sync.WaitGroup
-
-	for _, s := range sources {
-		wg.Add(1)
-		go func(s datasource.Datasource) {
-			defer wg.Done()
-
-			duration := datasourceInterval
+// namedDatasource is a Datasource along with the name of the -from-* flag
+// which configured it, by which it is referred to in -datasource-order.
+type namedDatasource struct {
+	name string
+	datasource.Datasource
+}
+
+// datasourceNames are the names which may be used in -datasource-order.
+var datasourceNames = []string{
+	"file", "url", "configdrive", "nocloud", "metadata-service",
+	"ec2-metadata", "gce-metadata", "cloudsigma-metadata",
+	"digitalocean-metadata", "waagent", "packet-metadata",
+	"openstack-metadata", "proc-cmdline", "vmware-guestinfo",
+	"vmware-ovf-env",
+}
+
+// orderDatasources sorts the Datasources into the comma-separated order,
+// followed by those which it does not name, and returns the grace period
+// selectDatasource should give the Datasources of higher priority. Without
+// an order, all Datasources have the same priority and there is no grace
+// period.
+func orderDatasources(dss []namedDatasource, order string, grace time.Duration) ([]namedDatasource, time.Duration, error) {
+	if order == "" {
+		return dss, 0, nil
+	}
+
+	ordered := make([]namedDatasource, 0, len(dss))
+	seen := map[string]bool{}
+	for _, name := range strings.Split(order, ",") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "from-")
+		if !isDatasourceName(name) {
+			return nil, 0, fmt.Errorf("unknown datasource %q. Supported datasources: %q", name, datasourceNames)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		found := false
+		for _, ds := range dss {
+			if ds.name == name {
+				ordered = append(ordered, ds)
+				found = true
+			}
+		}
+		if !found {
+			log.Printf("Datasource %q in -datasource-order is not configured, ignoring\n", name)
+		}
+	}
+	for _, ds := range dss {
+		if !seen[ds.name] {
+			ordered = append(ordered, ds)
+		}
+	}
+	return ordered, grace, nil
+}
+
+func isDatasourceName(name string) bool {
+	for _, n := range datasourceNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// availability reports that the Datasource at the given index either became
+// available or will never be available.
+type availability struct {
+	index     int
+	available bool
+}
+
+// checkDatasources checks the availability of each Datasource in parallel,
+// retrying those whose availability may change according to their
+// retryPolicy, until ctx is cancelled. Checks which are in flight when ctx is
+// cancelled are aborted if the Datasource supports it.
+func checkDatasources(ctx context.Context, sources []namedDatasource, t timeouts) <-chan availability {
+	results := make(chan availability)
+	for i, s := range sources {
+		go func(i int, s namedDatasource, policy retryPolicy) {
+			start := time.Now()
+			duration := policy.interval
 			for {
 				log.Printf("Checking availability of %q\n", s.Type())
-				if s.IsAvailable() {
-					ds <- s
-					return
-				} else if !s.AvailabilityChanges() {
+				var available bool
+				if ac, ok := s.Datasource.(datasource.AvailabilityContext); ok {
+					available = ac.IsAvailableContext(ctx)
+				} else {
+					available = s.IsAvailable()
+				}
+				giveUp := policy.timeout > 0 && time.Since(start) >= policy.timeout
+				if giveUp && !available {
+					log.Printf("Giving up on datasource %q after %v\n", s.name, policy.timeout)
+				}
+				if available || giveUp || !s.AvailabilityChanges() {
+					select {
+					case results <- availability{i, available}:
+					case <-ctx.Done():
+					}
 					return
 				}
 				select {
-				case <-stop:
+				case <-ctx.Done():
 					return
 				case <-time.After(duration):
-					duration = pkg.ExpBackoff(duration, datasourceMaxInterval)
+					duration = pkg.ExpBackoff(duration, policy.maxInterval)
 				}
 			}
-		}(s)
+		}(i, s, t.policy(s.name))
+	}
+	return results
+}
+
+// selectDatasource attempts to choose a valid Datasource to use based on its
+// current availability and priority. Datasources are given in order of
+// priority and are retried if possible if they are not immediately
+// available. An available Datasource is returned as soon as all of the
+// Datasources before it are permanently unavailable or grace has passed. If
+// all Datasources are permanently unavailable or the timeout is reached before
+// one becomes available, nil is returned.
+func selectDatasource(sources []namedDatasource, grace time.Duration, t timeouts) datasource.Datasource {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := checkDatasources(ctx, sources, t)
+
+	pending := make([]bool, len(sources))
+	available := make([]bool, len(sources))
+	for i := range sources {
+		pending[i] = true
 	}
+	remaining := len(sources)
+
+	graceOver := grace <= 0
+	var graceTimer <-chan time.Time
+	if !graceOver {
+		graceTimer = time.After(grace)
+	}
+	timeout := time.After(t.timeout)
+
+	for {
+		if i, higher := firstAvailable(sources, pending, available); i >= 0 {
+			s := sources[i]
+			switch {
+			case grace <= 0:
+				log.Printf("Using datasource %q (%q): first to become available\n", s.name, s.Type())
+			case len(higher) == 0:
+				log.Printf("Using datasource %q (%q): no datasource of higher priority is available\n", s.name, s.Type())
+			case graceOver:
+				log.Printf("Using datasource %q (%q): %q did not become available within %v\n", s.name, s.Type(), higher, grace)
+			}
+			if len(higher) == 0 || graceOver {
+				for j := i + 1; j < len(sources); j++ {
+					if available[j] {
+						log.Printf("Skipping available datasource %q: %q has higher priority\n", sources[j].name, s.name)
+					}
+				}
+				return s.Datasource
+			}
+		}
+		if remaining == 0 {
+			return nil
+		}
 
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+		select {
+		case r := <-results:
+			pending[r.index] = false
+			available[r.index] = r.available
+			remaining--
+			if !r.available {
+				log.Printf("Datasource %q is not available\n", sources[r.index].name)
+			} else if higher := pendingBefore(sources, pending, r.index); len(higher) > 0 && !graceOver {
+				log.Printf("Datasource %q is available, waiting up to %v for %q of higher priority\n", sources[r.index].name, grace, higher)
+			}
+		case <-graceTimer:
+			graceOver = true
+			graceTimer = nil
+		case <-timeout:
+			return nil
+		}
+	}
+}
 
-	var s datasource.Datasource
-	select {
-	case s = <-ds:
-	case <-done:
-	case <-time.After(datasourceTimeout):
+// firstAvailable returns the index of the first available Datasource and the
+// names of the Datasources before it whose availability is still pending, or
+// -1 if none is available.
+func firstAvailable(sources []namedDatasource, pending, available []bool) (int, []string) {
+	for i := range sources {
+		if available[i] {
+			return i, pendingBefore(sources, pending, i)
+		}
 	}
+	return -1, nil
+}
 
-	close(stop)
-	return s
+// pendingBefore returns the names of the Datasources before index i whose
+// availability is still pending.
+func pendingBefore(sources []namedDatasource, pending []bool, i int) []string {
+	var names []string
+	for j := 0; j < i; j++ {
+		if pending[j] {
+			names = append(names, sources[j].name)
+		}
+	}
+	return names
 }
 
 // TODO(jonboulle): this should probably be refactored and moved into a different module
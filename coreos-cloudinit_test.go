@@ -16,13 +16,25 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"encoding/base64"
 	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/coreos/coreos-cloudinit/config"
 	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/initialize"
+	"github.com/coreos/coreos-cloudinit/pkg"
 )
 
 func TestMergeConfigs(t *testing.T) {
@@ -145,3 +157,534 @@ func TestDecompressIfGzip(t *testing.T) {
 	}
 
 }
+
+func TestApplyOverlays(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	overlays := map[string]string{
+		"site.yml":   "#cloud-config\nhostname: site\nwrite_files:\n  - path: /etc/motd\n    content: site\n",
+		"keys.yml":   "#cloud-config\nssh_authorized_keys:\n  - $public_ipv4\n",
+		"script.sh":  "#!/bin/sh\n",
+		"broken.yml": "#cloud-config\nhostname: [\n",
+	}
+	for name, contents := range overlays {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("unable to write overlay: %v", err)
+		}
+	}
+	env := initialize.NewEnvironment("/", "", dir, "", datasource.Metadata{PublicIPv4: net.ParseIP("1.2.3.4")})
+
+	for i, tt := range []struct {
+		cc       *config.CloudConfig
+		overlays []string
+		strategy config.MergeStrategy
+
+		out *config.CloudConfig
+		err bool
+	}{
+		{
+			cc:       nil,
+			overlays: []string{"site.yml"},
+			strategy: config.MergeAppend,
+			out:      &config.CloudConfig{Hostname: "site", WriteFiles: []config.File{{Path: "/etc/motd", Content: "site"}}},
+		},
+		{
+			cc:       &config.CloudConfig{Hostname: "ud", SSHAuthorizedKeys: []string{"ud"}, WriteFiles: []config.File{{Path: "/etc/motd", Content: "ud", Owner: "core"}}},
+			overlays: []string{"site.yml", "keys.yml"},
+			strategy: config.MergeAppend,
+			out:      &config.CloudConfig{Hostname: "site", SSHAuthorizedKeys: []string{"ud", "1.2.3.4"}, WriteFiles: []config.File{{Path: "/etc/motd", Content: "site", Owner: "core"}}},
+		},
+		{
+			cc:       &config.CloudConfig{SSHAuthorizedKeys: []string{"ud"}},
+			overlays: []string{"keys.yml"},
+			strategy: config.MergeReplace,
+			out:      &config.CloudConfig{SSHAuthorizedKeys: []string{"1.2.3.4"}},
+		},
+		{
+			overlays: []string{"script.sh"},
+			err:      true,
+		},
+		{
+			overlays: []string{"broken.yml"},
+			err:      true,
+		},
+		{
+			overlays: []string{"missing.yml"},
+			err:      true,
+		},
+	} {
+		var paths []string
+		for _, o := range tt.overlays {
+			paths = append(paths, path.Join(dir, o))
+		}
+		out, err := applyOverlays(tt.cc, paths, tt.strategy, env)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if !tt.err && !reflect.DeepEqual(tt.out, out) {
+			t.Errorf("bad config (#%d): want %#v, got %#v", i, tt.out, out)
+		}
+	}
+}
+
+type vendorDatasource struct {
+	datasource.Datasource
+	data []byte
+	err  error
+}
+
+func (ds vendorDatasource) FetchVendordata() ([]byte, error) {
+	return ds.data, ds.err
+}
+
+func (ds vendorDatasource) Type() string {
+	return "vendor"
+}
+
+func TestLoadVendordata(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	keys := initialize.TrustedKeys{key.Public().(ed25519.PublicKey)}
+	signed := []byte("#cloud-config\nhostname: signed\n")
+	signed = append([]byte("#signature: ed25519 "+base64.StdEncoding.EncodeToString(ed25519.Sign(key, signed))+"\n"), signed...)
+
+	files := map[string]string{
+		"config.yml": "#cloud-config\nhostname: file\nssh_authorized_keys:\n  - file\n",
+		"script.sh":  "#!/bin/sh\n",
+		"signed.yml": string(signed),
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("unable to write vendor-data: %v", err)
+		}
+	}
+	env := initialize.NewEnvironment("/", "", dir, "", datasource.Metadata{})
+	fetch := func(url string) ([]byte, error) {
+		return nil, pkg.ErrNotFound{Err: errors.New("not found")}
+	}
+
+	for i, tt := range []struct {
+		ds   datasource.Datasource
+		file string
+		keys initialize.TrustedKeys
+
+		cc      *config.CloudConfig
+		scripts []config.Script
+		err     bool
+	}{
+		{
+			ds: testDatasource{},
+		},
+		{
+			ds: vendorDatasource{},
+		},
+		{
+			ds: vendorDatasource{data: []byte("#cloud-config\nhostname: vendor\nssh_authorized_keys:\n  - vendor\n")},
+			cc: &config.CloudConfig{Hostname: "vendor", SSHAuthorizedKeys: []string{"vendor"}},
+		},
+		{
+			ds:   vendorDatasource{data: []byte("#cloud-config\nhostname: vendor\nssh_authorized_keys:\n  - vendor\n")},
+			file: "config.yml",
+			cc:   &config.CloudConfig{Hostname: "file", SSHAuthorizedKeys: []string{"vendor", "file"}},
+		},
+		{
+			ds:      testDatasource{},
+			file:    "script.sh",
+			scripts: []config.Script{config.Script("#!/bin/sh\n")},
+		},
+		{
+			ds:   testDatasource{},
+			file: "signed.yml",
+			keys: keys,
+			cc:   &config.CloudConfig{Hostname: "signed"},
+		},
+		{
+			ds:   testDatasource{},
+			file: "config.yml",
+			keys: keys,
+			err:  true,
+		},
+		{
+			ds:  vendorDatasource{err: errors.New("unavailable")},
+			err: true,
+		},
+		{
+			ds:   testDatasource{},
+			file: "missing.yml",
+			err:  true,
+		},
+	} {
+		var file string
+		if tt.file != "" {
+			file = path.Join(dir, tt.file)
+		}
+		cc, scripts, err := loadVendordata(tt.ds, file, tt.keys, fetch, env)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if !reflect.DeepEqual(tt.cc, cc) {
+			t.Errorf("bad config (#%d): want %#v, got %#v", i, tt.cc, cc)
+		}
+		if !reflect.DeepEqual(tt.scripts, scripts) {
+			t.Errorf("bad scripts (#%d): want %#v, got %#v", i, tt.scripts, scripts)
+		}
+	}
+}
+
+func TestVerifiedFetch(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	keys := initialize.TrustedKeys{key.Public().(ed25519.PublicKey)}
+	doc := []byte("#cloud-config\nhostname: foo\n")
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, doc))
+
+	docs := map[string]string{
+		"http://detached":        string(doc),
+		"http://detached.sig":    sig,
+		"http://inline":          "#signature: ed25519 " + sig + "\n" + string(doc),
+		"http://unsigned":        string(doc),
+		"http://tampered":        "#cloud-config\nhostname: bar\n",
+		"http://tampered.sig":    sig,
+		"http://unavailable":     string(doc),
+		"http://unavailable.sig": "",
+		"http://missing.sig":     sig,
+		"data:,signed":           "#signature: ed25519 " + sig + "\n" + string(doc),
+		"data:,unsigned":         string(doc),
+	}
+	var fetched []string
+	get := func(url string) ([]byte, error) {
+		fetched = append(fetched, url)
+		if d, ok := docs[url]; ok && d != "" {
+			return []byte(d), nil
+		} else if ok {
+			return nil, pkg.ErrServer{Err: errors.New("server error")}
+		}
+		return nil, pkg.ErrNotFound{Err: errors.New("not found")}
+	}
+	fetch := verifiedFetch(get, keys)
+
+	for i, tt := range []struct {
+		url string
+
+		out string
+		err bool
+	}{
+		{url: "http://detached", out: string(doc)},
+		{url: "http://inline", out: string(doc)},
+		{url: "http://unsigned", err: true},
+		{url: "http://tampered", err: true},
+		{url: "http://unavailable", err: true},
+		{url: "http://missing", err: true},
+		{url: "data:,signed", out: string(doc)},
+		{url: "data:,unsigned", err: true},
+	} {
+		out, err := fetch(tt.url)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if string(out) != tt.out {
+			t.Errorf("bad document (#%d): want %q, got %q", i, tt.out, out)
+		}
+	}
+	for _, url := range fetched {
+		if strings.HasPrefix(url, "data:") && strings.HasSuffix(url, datasource.SignatureSuffix) {
+			t.Errorf("bad fetch: data URL signature %q", url)
+		}
+	}
+}
+
+func TestFetchOnce(t *testing.T) {
+	fetches := map[string]int{}
+	fetch := fetchOnce(func(url string) ([]byte, error) {
+		fetches[url]++
+		if url == "http://missing" {
+			return nil, errors.New("not found")
+		}
+		return []byte(url), nil
+	})
+
+	for _, url := range []string{"http://a", "http://missing", "http://a", "http://missing"} {
+		out, err := fetch(url)
+		if url == "http://missing" && err == nil {
+			t.Errorf("bad error (%s): want non-nil, got nil", url)
+		} else if url != "http://missing" && string(out) != url {
+			t.Errorf("bad document (%s): want %q, got %q (%v)", url, url, out, err)
+		}
+	}
+	if want := map[string]int{"http://a": 1, "http://missing": 1}; !reflect.DeepEqual(want, fetches) {
+		t.Errorf("bad fetches: want %v, got %v", want, fetches)
+	}
+}
+
+func TestEncryptSecret(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdh.X25519().NewPrivateKey(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	keyPath := path.Join(dir, "host.pub")
+	if err := ioutil.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())), 0644); err != nil {
+		t.Fatalf("unable to write key: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := encryptSecret(keyPath, strings.NewReader("secret"), &out); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	cc := config.CloudConfig{Hostname: strings.TrimSpace(out.String())}
+	if !cc.HasSecrets() {
+		t.Fatalf("bad output: want an encrypted value, got %q", out.String())
+	}
+	if err := cc.DecryptSecrets(initialize.SecretKeys{key}.Decrypt); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if cc.Hostname != "secret" {
+		t.Errorf("bad plaintext: want %q, got %q", "secret", cc.Hostname)
+	}
+
+	if err := encryptSecret(path.Join(dir, "missing.pub"), strings.NewReader("secret"), &out); err == nil {
+		t.Errorf("bad error: want non-nil, got nil")
+	}
+}
+
+func TestHttpConfig(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmdline := path.Join(dir, "cmdline")
+	if err := ioutil.WriteFile(cmdline, []byte("cloud-config-url=https://example.com cloud-config-proxy=http://kernel:3128 cloud-config-ca=/kernel/ca.pem\n"), 0644); err != nil {
+		t.Fatalf("failed writing cmdline: %v", err)
+	}
+
+	for i, tt := range []struct {
+		flags   pkg.HttpConfig
+		url     string
+		cmdline string
+		cfg     pkg.HttpConfig
+	}{
+		{pkg.HttpConfig{}, "", path.Join(dir, "missing"), pkg.HttpConfig{}},
+		{pkg.HttpConfig{BearerToken: "token"}, "", path.Join(dir, "missing"), pkg.HttpConfig{BearerToken: "token"}},
+		{pkg.HttpConfig{BearerToken: "token"}, "https://flag.example.com/config", path.Join(dir, "missing"), pkg.HttpConfig{BearerToken: "token", BearerTokenHost: "flag.example.com"}},
+		{pkg.HttpConfig{}, "", cmdline, pkg.HttpConfig{Proxy: "http://kernel:3128", CAFile: "/kernel/ca.pem", BearerTokenHost: "example.com"}},
+		{pkg.HttpConfig{Proxy: "http://flag:3128"}, "", cmdline, pkg.HttpConfig{Proxy: "http://flag:3128", CAFile: "/kernel/ca.pem", BearerTokenHost: "example.com"}},
+		{pkg.HttpConfig{}, "https://flag.example.com/config", cmdline, pkg.HttpConfig{Proxy: "http://kernel:3128", CAFile: "/kernel/ca.pem", BearerTokenHost: "flag.example.com"}},
+	} {
+		flags.http = tt.flags
+		flags.sources.url = tt.url
+		if cfg := httpConfig(tt.cmdline); cfg != tt.cfg {
+			t.Errorf("bad config (#%d): want %+v, got %+v", i, tt.cfg, cfg)
+		}
+	}
+	flags.sources.url = ""
+	flags.http = pkg.HttpConfig{}
+}
+
+func TestApplyOEMConfig(t *testing.T) {
+	for i, tt := range []struct {
+		explicit    map[string]bool
+		configDrive string
+		netconf     string
+		order       string
+
+		wantConfigDrive string
+		wantNetconf     string
+		wantOrder       string
+	}{
+		{nil, "", "", "", "/media/configdrive", "debian", "configdrive"},
+		{nil, "/mnt", "openstack", "", "/media/configdrive", "debian", "configdrive"},
+		{nil, "", "", "ec2-metadata", "/media/configdrive", "debian", "ec2-metadata"},
+		{map[string]bool{"from-configdrive": true}, "/mnt", "", "", "/mnt", "debian", "configdrive"},
+		{map[string]bool{"convert-netconf": true}, "", "", "", "/media/configdrive", "", "configdrive"},
+	} {
+		flags.sources.configDrive = tt.configDrive
+		flags.convertNetconf = tt.netconf
+		flags.order = tt.order
+		applyOEMConfig(oemConfig{
+			"from-configdrive": "/media/configdrive",
+			"convert-netconf":  "debian",
+			"datasource-order": "configdrive",
+		}, tt.explicit)
+		if flags.sources.configDrive != tt.wantConfigDrive {
+			t.Errorf("bad from-configdrive (#%d): want %q, got %q", i, tt.wantConfigDrive, flags.sources.configDrive)
+		}
+		if flags.convertNetconf != tt.wantNetconf {
+			t.Errorf("bad convert-netconf (#%d): want %q, got %q", i, tt.wantNetconf, flags.convertNetconf)
+		}
+		if flags.order != tt.wantOrder {
+			t.Errorf("bad datasource-order (#%d): want %q, got %q", i, tt.wantOrder, flags.order)
+		}
+	}
+	flags.sources.configDrive = ""
+	flags.convertNetconf = ""
+	flags.order = ""
+}
+
+func TestOrderDatasources(t *testing.T) {
+	dss := []namedDatasource{
+		{name: "ec2-metadata"},
+		{name: "configdrive"},
+		{name: "url"},
+	}
+	names := func(dss []namedDatasource) []string {
+		var n []string
+		for _, ds := range dss {
+			n = append(n, ds.name)
+		}
+		return n
+	}
+
+	for i, tt := range []struct {
+		order string
+
+		names []string
+		grace time.Duration
+		err   bool
+	}{
+		{order: "", names: []string{"ec2-metadata", "configdrive", "url"}, grace: 0},
+		{order: "configdrive,ec2-metadata", names: []string{"configdrive", "ec2-metadata", "url"}, grace: time.Second},
+		{order: "url, from-configdrive", names: []string{"url", "configdrive", "ec2-metadata"}, grace: time.Second},
+		{order: "nocloud,url,url", names: []string{"url", "ec2-metadata", "configdrive"}, grace: time.Second},
+		{order: "configdrive,floppy", err: true},
+	} {
+		ordered, grace, err := orderDatasources(dss, tt.order, time.Second)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if tt.err {
+			continue
+		}
+		if !reflect.DeepEqual(tt.names, names(ordered)) {
+			t.Errorf("bad order (#%d): want %q, got %q", i, tt.names, names(ordered))
+		}
+		if grace != tt.grace {
+			t.Errorf("bad grace (#%d): want %v, got %v", i, tt.grace, grace)
+		}
+	}
+}
+
+// testDatasource becomes available after the given delay, or never if the
+// delay is negative.
+type testDatasource struct {
+	datasource.Datasource
+	start   time.Time
+	delay   time.Duration
+	changes bool
+}
+
+func (ds testDatasource) IsAvailable() bool {
+	return ds.delay >= 0 && time.Since(ds.start) >= ds.delay
+}
+
+func (ds testDatasource) AvailabilityChanges() bool {
+	return ds.changes
+}
+
+func (ds testDatasource) Type() string {
+	return "test"
+}
+
+func TestSelectDatasource(t *testing.T) {
+	type source struct {
+		delay   time.Duration
+		changes bool
+	}
+	for i, tt := range []struct {
+		sources []source
+		grace   time.Duration
+
+		selected int
+		min, max time.Duration
+	}{
+		// The first datasource is available straight away
+		{[]source{{0, false}, {0, false}}, time.Second, 0, 0, 100 * time.Millisecond},
+		// Without a grace period, the first to become available wins
+		{[]source{{300 * time.Millisecond, true}, {0, false}}, 0, 1, 0, 100 * time.Millisecond},
+		// A datasource which will never be available is not waited for
+		{[]source{{-1, false}, {0, false}}, time.Second, 1, 0, 100 * time.Millisecond},
+		// A datasource which may become available is waited for...
+		{[]source{{150 * time.Millisecond, true}, {0, false}}, time.Second, 0, 150 * time.Millisecond, 500 * time.Millisecond},
+		// ...until the grace period is over
+		{[]source{{-1, true}, {0, false}}, 200 * time.Millisecond, 1, 200 * time.Millisecond, 500 * time.Millisecond},
+		// Nothing is available
+		{[]source{{-1, false}, {-1, false}}, time.Second, -1, 0, 100 * time.Millisecond},
+	} {
+		start := time.Now()
+		var dss []namedDatasource
+		for j, s := range tt.sources {
+			dss = append(dss, namedDatasource{strconv.Itoa(j), &testDatasource{start: start, delay: s.delay, changes: s.changes}})
+		}
+
+		ds := selectDatasource(dss, tt.grace, timeouts{timeout: time.Minute, defaults: retryPolicy{interval: 100 * time.Millisecond, maxInterval: time.Second}})
+		elapsed := time.Since(start)
+
+		selected := -1
+		for j := range dss {
+			if ds != nil && ds == dss[j].Datasource {
+				selected = j
+			}
+		}
+		if selected != tt.selected {
+			t.Errorf("bad datasource (#%d): want %d, got %d", i, tt.selected, selected)
+		}
+		if elapsed < tt.min || elapsed > tt.max {
+			t.Errorf("bad duration (#%d): want %v-%v, got %v", i, tt.min, tt.max, elapsed)
+		}
+	}
+}
+
+// blockingDatasource blocks in IsAvailableContext until its context is
+// cancelled.
+type blockingDatasource struct {
+	testDatasource
+	canceled chan struct{}
+}
+
+func (ds *blockingDatasource) IsAvailableContext(ctx context.Context) bool {
+	<-ctx.Done()
+	close(ds.canceled)
+	return false
+}
+
+func TestSelectDatasourcePolicy(t *testing.T) {
+	start := time.Now()
+	blocking := &blockingDatasource{testDatasource{start: start, delay: -1, changes: true}, make(chan struct{})}
+	dss := []namedDatasource{
+		{"ec2-metadata", &testDatasource{start: start, delay: -1, changes: true}},
+		{"configdrive", &testDatasource{start: start, delay: 0}},
+		{"url", blocking},
+	}
+	ds := selectDatasource(dss, time.Minute, timeouts{
+		timeout:  time.Minute,
+		defaults: retryPolicy{interval: 50 * time.Millisecond, maxInterval: 100 * time.Millisecond},
+		sources:  map[string]retryPolicy{"ec2-metadata": {timeout: 200 * time.Millisecond}},
+	})
+	elapsed := time.Since(start)
+
+	if ds != dss[1].Datasource {
+		t.Errorf("bad datasource: want %q, got %v", "configdrive", ds)
+	}
+	if elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("bad duration: want 200ms-1s, got %v", elapsed)
+	}
+	select {
+	case <-blocking.canceled:
+	case <-time.After(time.Second):
+		t.Errorf("availability check of %q was not cancelled", "url")
+	}
+}
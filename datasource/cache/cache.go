@@ -0,0 +1,252 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/datasource/metadata/packet"
+	"github.com/coreos/coreos-cloudinit/system"
+)
+
+// Filename returns the name of the cache of ds in the workspace. Datasources
+// are told apart by their type and config root or location, so that each has
+// a cache of its own.
+func Filename(ds datasource.Datasource) string {
+	key := ds.Type() + "\x00" + ds.ConfigRoot()
+	if l, ok := ds.(datasource.Located); ok {
+		key += "\x00" + l.Location()
+	}
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("cache-%s-%x.json", ds.Type(), sum[:8])
+}
+
+// Formats of the network config which can be cached, named after the
+// -convert-netconf options which consume them.
+const (
+	netconfRaw       = "raw"
+	netconfOpenStack = "openstack"
+	netconfPacket    = "packet"
+	netconfVMware    = "vmware"
+)
+
+// record is what was last fetched from a datasource. It is kept in a single
+// file so that the user-data and meta-data in the cache always belong
+// together.
+type record struct {
+	Saved             time.Time           `json:"saved"`
+	Datasource        string              `json:"datasource"`
+	ConfigRoot        string              `json:"config_root,omitempty"`
+	Userdata          []byte              `json:"userdata,omitempty"`
+	UserdataSignature []byte              `json:"userdata_signature,omitempty"`
+	Metadata          datasource.Metadata `json:"metadata"`
+	NetconfFormat     string              `json:"network_config_format,omitempty"`
+	NetworkConfig     json.RawMessage     `json:"network_config,omitempty"`
+}
+
+// Save caches the user-data, its detached signature (if any) and the meta-data
+// fetched from ds in the workspace, replacing what was cached before for ds.
+// The cache is only readable by root. Network configs other than raw ones and
+// those of OpenStack, Packet and VMware are not cached.
+func Save(workspace string, ds datasource.Datasource, userdata, signature []byte, metadata datasource.Metadata) error {
+	r := record{
+		Saved:             time.Now().UTC(),
+		Datasource:        ds.Type(),
+		ConfigRoot:        ds.ConfigRoot(),
+		Userdata:          userdata,
+		UserdataSignature: signature,
+		Metadata:          metadata,
+	}
+	r.Metadata.NetworkConfig = nil
+
+	var err error
+	switch netconf := metadata.NetworkConfig.(type) {
+	case []byte:
+		r.NetconfFormat = netconfRaw
+		r.NetworkConfig, err = json.Marshal(netconf)
+	case datasource.OpenStackNetworkData:
+		r.NetconfFormat = netconfOpenStack
+		r.NetworkConfig, err = json.Marshal(netconf)
+	case packet.NetworkData:
+		r.NetconfFormat = netconfPacket
+		r.NetworkConfig, err = json.Marshal(netconf)
+	case map[string]string:
+		r.NetconfFormat = netconfVMware
+		r.NetworkConfig, err = json.Marshal(netconf)
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = system.WriteFile(&system.File{File: config.File{
+		Path:               Filename(ds),
+		RawFilePermissions: "0600",
+		Content:            string(b) + "\n",
+	}}, workspace)
+	return err
+}
+
+type cache struct {
+	paths  []string
+	record *record
+}
+
+// NewDatasource returns a Datasource which provides what was last cached in
+// the workspace by Save for any of dss, as the datasource it was fetched
+// from. The most recent of their caches is used.
+func NewDatasource(workspace string, dss []datasource.Datasource) *cache {
+	c := &cache{}
+	for _, ds := range dss {
+		c.paths = append(c.paths, path.Join(workspace, Filename(ds)))
+	}
+	return c
+}
+
+func (c *cache) IsAvailable() bool {
+	for _, p := range c.paths {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cache) AvailabilityChanges() bool {
+	return false
+}
+
+func (c *cache) ConfigRoot() string {
+	r, err := c.load()
+	if err != nil {
+		return ""
+	}
+	return r.ConfigRoot
+}
+
+func (c *cache) FetchMetadata() (datasource.Metadata, error) {
+	r, err := c.load()
+	if err != nil {
+		return datasource.Metadata{}, err
+	}
+
+	metadata := r.Metadata
+	switch r.NetconfFormat {
+	case "":
+	case netconfRaw:
+		var netconf []byte
+		err = json.Unmarshal(r.NetworkConfig, &netconf)
+		metadata.NetworkConfig = netconf
+	case netconfOpenStack:
+		var netconf datasource.OpenStackNetworkData
+		err = json.Unmarshal(r.NetworkConfig, &netconf)
+		metadata.NetworkConfig = netconf
+	case netconfPacket:
+		var netconf packet.NetworkData
+		err = json.Unmarshal(r.NetworkConfig, &netconf)
+		metadata.NetworkConfig = netconf
+	case netconfVMware:
+		var netconf map[string]string
+		err = json.Unmarshal(r.NetworkConfig, &netconf)
+		metadata.NetworkConfig = netconf
+	default:
+		err = fmt.Errorf("unsupported network config format %q", r.NetconfFormat)
+	}
+	if err != nil {
+		return datasource.Metadata{}, fmt.Errorf("invalid cached network config: %v", err)
+	}
+	return metadata, nil
+}
+
+func (c *cache) FetchUserdata() ([]byte, error) {
+	r, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return r.Userdata, nil
+}
+
+func (c *cache) FetchUserdataSignature() ([]byte, error) {
+	r, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return r.UserdataSignature, nil
+}
+
+// Type returns the type of the datasource the cached data was fetched from,
+// so that it is applied as it was then.
+func (c *cache) Type() string {
+	r, err := c.load()
+	if err != nil {
+		return "cache"
+	}
+	return r.Datasource
+}
+
+// Saved returns when the cached data was fetched.
+func (c *cache) Saved() (time.Time, error) {
+	r, err := c.load()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return r.Saved, nil
+}
+
+// load reads the most recent of the caches, skipping those which are missing.
+// An invalid cache is only reported if there is no valid one.
+func (c *cache) load() (*record, error) {
+	if c.record != nil {
+		return c.record, nil
+	}
+	var latest *record
+	var lastErr error
+	for _, p := range c.paths {
+		b, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			lastErr = err
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(b, &r); err != nil {
+			lastErr = fmt.Errorf("invalid cache %s: %v", p, err)
+			continue
+		}
+		if latest == nil || r.Saved.After(latest.Saved) {
+			latest = &r
+		}
+	}
+	if latest == nil {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no cache for the requested datasources")
+		}
+		return nil, lastErr
+	}
+	c.record = latest
+	return c.record, nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/datasource/file"
+	"github.com/coreos/coreos-cloudinit/datasource/metadata/packet"
+	"github.com/coreos/coreos-cloudinit/datasource/url"
+)
+
+type testDatasource struct {
+	datasource.Datasource
+}
+
+func (ds testDatasource) ConfigRoot() string {
+	return "/media/configdrive"
+}
+
+func (ds testDatasource) Type() string {
+	return "test"
+}
+
+func TestSave(t *testing.T) {
+	for i, tt := range []struct {
+		userdata  []byte
+		signature []byte
+		metadata  datasource.Metadata
+
+		expect datasource.Metadata
+	}{
+		{},
+		{
+			userdata:  []byte("#cloud-config\n"),
+			signature: []byte("sig"),
+			metadata: datasource.Metadata{
+				InstanceID:    "i-1234",
+				PublicIPv4:    net.ParseIP("1.2.3.4"),
+				Hostname:      "host",
+				SSHPublicKeys: map[string]string{"key": "ssh-ed25519 AAAA"},
+				NetworkConfig: []byte(`{"links": []}`),
+			},
+			expect: datasource.Metadata{
+				InstanceID:    "i-1234",
+				PublicIPv4:    net.ParseIP("1.2.3.4"),
+				Hostname:      "host",
+				SSHPublicKeys: map[string]string{"key": "ssh-ed25519 AAAA"},
+				NetworkConfig: []byte(`{"links": []}`),
+			},
+		},
+		{
+			metadata: datasource.Metadata{NetworkConfig: datasource.OpenStackNetworkData(`{"links": []}`)},
+			expect:   datasource.Metadata{NetworkConfig: datasource.OpenStackNetworkData(`{"links": []}`)},
+		},
+		{
+			metadata: datasource.Metadata{NetworkConfig: packet.NetworkData{
+				Interfaces: []packet.Nic{{Name: "eth0", Mac: "00:00:00:00:00:00"}},
+				DNS:        []net.IP{net.ParseIP("8.8.8.8")},
+			}},
+			expect: datasource.Metadata{NetworkConfig: packet.NetworkData{
+				Interfaces: []packet.Nic{{Name: "eth0", Mac: "00:00:00:00:00:00"}},
+				DNS:        []net.IP{net.ParseIP("8.8.8.8")},
+			}},
+		},
+		{
+			metadata: datasource.Metadata{NetworkConfig: map[string]string{"interface.0.name": "eth0"}},
+			expect:   datasource.Metadata{NetworkConfig: map[string]string{"interface.0.name": "eth0"}},
+		},
+		{
+			metadata: datasource.Metadata{Hostname: "host", NetworkConfig: struct{}{}},
+			expect:   datasource.Metadata{Hostname: "host"},
+		},
+	} {
+		dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+		if err != nil {
+			t.Fatalf("unable to create tempdir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		if err := Save(dir, testDatasource{}, tt.userdata, tt.signature, tt.metadata); err != nil {
+			t.Errorf("bad error (#%d): want %v, got %v", i, nil, err)
+			continue
+		}
+		if info, err := os.Stat(path.Join(dir, Filename(testDatasource{}))); err != nil {
+			t.Errorf("bad error (#%d): want %v, got %v", i, nil, err)
+		} else if info.Mode().Perm() != 0600 {
+			t.Errorf("bad mode (#%d): want %v, got %v", i, os.FileMode(0600), info.Mode().Perm())
+		}
+
+		c := NewDatasource(dir, []datasource.Datasource{testDatasource{}})
+		if !c.IsAvailable() {
+			t.Errorf("bad availability (#%d): want %t, got %t", i, true, false)
+		}
+		if kind := c.Type(); kind != "test" {
+			t.Errorf("bad type (#%d): want %q, got %q", i, "test", kind)
+		}
+		if root := c.ConfigRoot(); root != "/media/configdrive" {
+			t.Errorf("bad config root (#%d): want %q, got %q", i, "/media/configdrive", root)
+		}
+		userdata, err := c.FetchUserdata()
+		if err != nil || string(userdata) != string(tt.userdata) {
+			t.Errorf("bad userdata (#%d): want %q, got %q (%v)", i, tt.userdata, userdata, err)
+		}
+		signature, err := c.FetchUserdataSignature()
+		if err != nil || string(signature) != string(tt.signature) {
+			t.Errorf("bad signature (#%d): want %q, got %q (%v)", i, tt.signature, signature, err)
+		}
+		metadata, err := c.FetchMetadata()
+		if err != nil {
+			t.Errorf("bad error (#%d): want %v, got %v", i, nil, err)
+		}
+		if !reflect.DeepEqual(tt.expect, metadata) {
+			t.Errorf("bad metadata (#%d): want %#v, got %#v", i, tt.expect, metadata)
+		}
+	}
+}
+
+func TestSaveOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := Save(dir, testDatasource{}, []byte("old"), []byte("sig"), datasource.Metadata{Hostname: "old"}); err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	if err := Save(dir, testDatasource{}, []byte("new"), nil, datasource.Metadata{Hostname: "new"}); err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+
+	c := NewDatasource(dir, []datasource.Datasource{testDatasource{}})
+	if userdata, _ := c.FetchUserdata(); string(userdata) != "new" {
+		t.Errorf("bad userdata: want %q, got %q", "new", userdata)
+	}
+	if signature, _ := c.FetchUserdataSignature(); signature != nil {
+		t.Errorf("bad signature: want %v, got %q", nil, signature)
+	}
+	if metadata, _ := c.FetchMetadata(); metadata.Hostname != "new" {
+		t.Errorf("bad hostname: want %q, got %q", "new", metadata.Hostname)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Errorf("bad workspace: want only %s, got %v (%v)", Filename(testDatasource{}), files, err)
+	}
+}
+
+func TestNewDatasource(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewDatasource(dir, []datasource.Datasource{testDatasource{}})
+	if c.IsAvailable() {
+		t.Errorf("bad availability: want %t, got %t", false, true)
+	}
+	if _, err := c.FetchMetadata(); err == nil {
+		t.Errorf("bad error: want non-nil, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, Filename(testDatasource{})), []byte("{"), 0600); err != nil {
+		t.Fatalf("unable to write cache: %v", err)
+	}
+	c = NewDatasource(dir, []datasource.Datasource{testDatasource{}})
+	if !c.IsAvailable() {
+		t.Errorf("bad availability: want %t, got %t", true, false)
+	}
+	if _, err := c.FetchUserdata(); err == nil {
+		t.Errorf("bad error: want non-nil, got %v", err)
+	}
+}
+
+func TestNewDatasourceRequested(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileA := file.NewDatasource("/a")
+	fileB := file.NewDatasource("/b")
+	urlA := url.NewDatasource("http://a")
+	for _, ds := range []datasource.Datasource{fileA, fileB, urlA} {
+		if err := Save(dir, ds, []byte(ds.(datasource.Located).Location()), nil, datasource.Metadata{}); err != nil {
+			t.Fatalf("bad error: want %v, got %v", nil, err)
+		}
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 3 {
+		t.Errorf("bad workspace: want 3 caches, got %v (%v)", files, err)
+	}
+
+	for i, tt := range []struct {
+		dss []datasource.Datasource
+
+		available bool
+		kind      string
+		userdata  string
+	}{
+		{dss: nil},
+		{dss: []datasource.Datasource{file.NewDatasource("/c"), url.NewDatasource("http://b")}},
+		{dss: []datasource.Datasource{fileA}, available: true, kind: "local-file", userdata: "/a"},
+		{dss: []datasource.Datasource{file.NewDatasource("/c"), fileB}, available: true, kind: "local-file", userdata: "/b"},
+		{dss: []datasource.Datasource{fileA, urlA}, available: true, kind: "url", userdata: "http://a"},
+	} {
+		c := NewDatasource(dir, tt.dss)
+		if available := c.IsAvailable(); available != tt.available {
+			t.Errorf("bad availability (#%d): want %t, got %t", i, tt.available, available)
+		}
+		if !tt.available {
+			if _, err := c.FetchUserdata(); err == nil {
+				t.Errorf("bad error (#%d): want non-nil, got %v", i, err)
+			}
+			continue
+		}
+		if kind := c.Type(); kind != tt.kind {
+			t.Errorf("bad type (#%d): want %q, got %q", i, tt.kind, kind)
+		}
+		if userdata, err := c.FetchUserdata(); err != nil || string(userdata) != tt.userdata {
+			t.Errorf("bad userdata (#%d): want %q, got %q (%v)", i, tt.userdata, userdata, err)
+		}
+	}
+}
@@ -53,6 +53,7 @@ func (cd *configDrive) ConfigRoot() string {
 func (cd *configDrive) FetchMetadata() (metadata datasource.Metadata, err error) {
 	var data []byte
 	var m struct {
+		UUID                string            `json:"uuid"`
 		SSHAuthorizedKeyMap map[string]string `json:"public_keys"`
 		Hostname            string            `json:"hostname"`
 		NetworkConfig       struct {
@@ -67,10 +68,16 @@ func (cd *configDrive) FetchMetadata() (metadata datasource.Metadata, err error)
 		return
 	}
 
+	metadata.InstanceID = m.UUID
 	metadata.SSHPublicKeys = m.SSHAuthorizedKeyMap
 	metadata.Hostname = m.Hostname
 	if m.NetworkConfig.ContentPath != "" {
 		metadata.NetworkConfig, err = cd.tryReadFile(path.Join(cd.openstackRoot(), m.NetworkConfig.ContentPath))
+	} else {
+		var netdata []byte
+		if netdata, err = cd.tryReadFile(path.Join(cd.openstackVersionRoot(), "network_data.json")); len(netdata) > 0 {
+			metadata.NetworkConfig = datasource.OpenStackNetworkData(netdata)
+		}
 	}
 
 	return
@@ -80,6 +87,20 @@ func (cd *configDrive) FetchUserdata() ([]byte, error) {
 	return cd.tryReadFile(path.Join(cd.openstackVersionRoot(), "user_data"))
 }
 
+func (cd *configDrive) FetchUserdataSignature() ([]byte, error) {
+	return cd.tryReadFile(path.Join(cd.openstackVersionRoot(), "user_data"+datasource.SignatureSuffix))
+}
+
+// FetchVendordata returns the vendor-data for cloud-init from
+// vendor_data.json, or nil if there is none.
+func (cd *configDrive) FetchVendordata() ([]byte, error) {
+	data, err := cd.tryReadFile(path.Join(cd.openstackVersionRoot(), "vendor_data.json"))
+	if err != nil {
+		return nil, err
+	}
+	return datasource.ParseOpenStackVendordata(data)
+}
+
 func (cd *configDrive) Type() string {
 	return "cloud-drive"
 }
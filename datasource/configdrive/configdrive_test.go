@@ -44,10 +44,11 @@ func TestFetchMetadata(t *testing.T) {
 		},
 		{
 			root: "/media/configdrive",
-			files: test.NewMockFilesystem(test.File{Path: "/media/configdrive/openstack/latest/meta_data.json", Contents: `{"hostname": "host", "network_config": {"content_path": "config_file.json"}, "public_keys":{"1": "key1", "2": "key2"}}`},
+			files: test.NewMockFilesystem(test.File{Path: "/media/configdrive/openstack/latest/meta_data.json", Contents: `{"uuid": "83679162-1378-4288-a2d4-70e13ec132aa", "hostname": "host", "network_config": {"content_path": "config_file.json"}, "public_keys":{"1": "key1", "2": "key2"}}`},
 				test.File{Path: "/media/configdrive/openstack/config_file.json", Contents: "make it work"},
 			),
 			metadata: datasource.Metadata{
+				InstanceID:    "83679162-1378-4288-a2d4-70e13ec132aa",
 				Hostname:      "host",
 				NetworkConfig: []byte("make it work"),
 				SSHPublicKeys: map[string]string{
@@ -56,6 +57,16 @@ func TestFetchMetadata(t *testing.T) {
 				},
 			},
 		},
+		{
+			root: "/media/configdrive",
+			files: test.NewMockFilesystem(test.File{Path: "/media/configdrive/openstack/latest/meta_data.json", Contents: `{"hostname": "host"}`},
+				test.File{Path: "/media/configdrive/openstack/latest/network_data.json", Contents: `{"links": []}`},
+			),
+			metadata: datasource.Metadata{
+				Hostname:      "host",
+				NetworkConfig: datasource.OpenStackNetworkData(`{"links": []}`),
+			},
+		},
 	} {
 		cd := configDrive{tt.root, tt.files.ReadFile}
 		metadata, err := cd.FetchMetadata()
@@ -102,6 +113,74 @@ func TestFetchUserdata(t *testing.T) {
 	}
 }
 
+func TestFetchUserdataSignature(t *testing.T) {
+	for _, tt := range []struct {
+		root  string
+		files test.MockFilesystem
+
+		sig string
+	}{
+		{
+			"/",
+			test.NewMockFilesystem(test.File{Path: "/openstack/latest/user_data", Contents: "userdata"}),
+			"",
+		},
+		{
+			"/media/configdrive",
+			test.NewMockFilesystem(test.File{Path: "/media/configdrive/openstack/latest/user_data.sig", Contents: "sig"}),
+			"sig",
+		},
+	} {
+		cd := configDrive{tt.root, tt.files.ReadFile}
+		sig, err := cd.FetchUserdataSignature()
+		if err != nil {
+			t.Fatalf("bad error for %+v: want %v, got %q", tt, nil, err)
+		}
+		if string(sig) != tt.sig {
+			t.Fatalf("bad signature for %+v: want %q, got %q", tt, tt.sig, sig)
+		}
+	}
+}
+
+func TestFetchVendordata(t *testing.T) {
+	for _, tt := range []struct {
+		root  string
+		files test.MockFilesystem
+
+		vendordata string
+	}{
+		{
+			"/",
+			test.NewMockFilesystem(),
+			"",
+		},
+		{
+			"/",
+			test.NewMockFilesystem(test.File{Path: "/openstack/latest/vendor_data.json", Contents: `"#cloud-config"`}),
+			"#cloud-config",
+		},
+		{
+			"/media/configdrive",
+			test.NewMockFilesystem(test.File{Path: "/media/configdrive/openstack/latest/vendor_data.json", Contents: `{"cloud-init": "#cloud-config"}`}),
+			"#cloud-config",
+		},
+		{
+			"/media/configdrive",
+			test.NewMockFilesystem(test.File{Path: "/media/configdrive/openstack/latest/vendor_data.json", Contents: `{"other-agent": "data"}`}),
+			"",
+		},
+	} {
+		cd := configDrive{tt.root, tt.files.ReadFile}
+		vendordata, err := cd.FetchVendordata()
+		if err != nil {
+			t.Fatalf("bad error for %+v: want %v, got %q", tt, nil, err)
+		}
+		if string(vendordata) != tt.vendordata {
+			t.Fatalf("bad vendordata for %+v: want %q, got %q", tt, tt.vendordata, vendordata)
+		}
+	}
+}
+
 func TestConfigRoot(t *testing.T) {
 	for _, tt := range []struct {
 		root       string
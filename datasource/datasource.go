@@ -15,7 +15,12 @@
 package datasource
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net"
+	"strings"
 )
 
 type Datasource interface {
@@ -27,12 +32,85 @@ type Datasource interface {
 	Type() string
 }
 
+// AvailabilityContext is implemented by datasources whose availability check
+// can be aborted by cancelling the given context.
+type AvailabilityContext interface {
+	IsAvailableContext(ctx context.Context) bool
+}
+
+// Vendordata is implemented by datasources which can provide vendor-data:
+// defaults of the provider, in any of the formats of user-data, which are
+// applied before the user-data.
+type Vendordata interface {
+	// FetchVendordata returns the vendor-data, or nil if there is none.
+	FetchVendordata() ([]byte, error)
+}
+
+// ParseOpenStackVendordata extracts the vendor-data for cloud-init from the
+// contents of OpenStack's vendor_data.json: either a JSON string, or an
+// object whose "cloud-init" member holds it. Anything else is meant for other
+// agents and yields no vendor-data.
+func ParseOpenStackVendordata(data []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid vendor_data.json: %v", err)
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		v = m["cloud-init"]
+	}
+	switch vd := v.(type) {
+	case string:
+		return []byte(vd), nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported vendor-data in vendor_data.json: %T", vd)
+	}
+}
+
+// Signed is implemented by datasources which can provide a detached
+// signature of the user-data (e.g. a user-data.sig file next to it). A nil
+// signature is returned if there is none.
+// Located is implemented by datasources which read the user-data from a
+// single file or URL rather than from their config root.
+type Located interface {
+	// Location returns the path or URL of the user-data.
+	Location() string
+}
+
+type Signed interface {
+	FetchUserdataSignature() ([]byte, error)
+}
+
+// SignatureSuffix is appended to the path or URL of the user-data to find its
+// detached signature.
+const SignatureSuffix = ".sig"
+
+// IsDataURL returns whether the user-data URL holds the user-data itself, in
+// which case there is no detached signature to fetch.
+func IsDataURL(url string) bool {
+	return strings.HasPrefix(url, "data:")
+}
+
+// OpenStackNetworkData is the content of an OpenStack network_data.json
+// document. It is stored in Metadata.NetworkConfig as its own type to tell it
+// apart from the legacy network_config content, which is a Debian interfaces
+// file and stored as []byte.
+type OpenStackNetworkData []byte
+
 type Metadata struct {
+	InstanceID    string
 	PublicIPv4    net.IP
 	PublicIPv6    net.IP
 	PrivateIPv4   net.IP
 	PrivateIPv6   net.IP
 	Hostname      string
+	Region        string
+	Zone          string
 	SSHPublicKeys map[string]string
 	NetworkConfig interface{}
 }
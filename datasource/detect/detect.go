@@ -0,0 +1,147 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package detect works out which datasources are likely to be present on a
+// machine from its DMI (SMBIOS) data, the labels of its block devices and its
+// kernel command line.
+package detect
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dmiPath        = "sys/class/dmi/id"
+	labelPath      = "dev/disk/by-label"
+	virtfsTagGlob  = "sys/bus/virtio/drivers/9pnet_virtio/*/mount_tag"
+	cmdlinePath    = "proc/cmdline"
+	cmdlineURLFlag = "cloud-config-url"
+)
+
+// platforms maps the DMI fields identifying a platform to the OEM whose
+// settings apply to it. The first match wins. Values are matched as
+// case-insensitive substrings.
+var platforms = []struct {
+	field string
+	value string
+	oem   string
+}{
+	{"sys_vendor", "Amazon EC2", "ec2-compat"},
+	{"chassis_asset_tag", "Amazon EC2", "ec2-compat"},
+	{"board_vendor", "Amazon EC2", "ec2-compat"},
+	{"product_version", "amazon", "ec2-compat"},
+	{"product_name", "Google Compute Engine", "gce"},
+	{"sys_vendor", "Google", "gce"},
+	{"board_vendor", "Google", "gce"},
+	{"sys_vendor", "DigitalOcean", "digitalocean"},
+	{"product_name", "CloudSigma", "cloudsigma"},
+	{"chassis_asset_tag", "7783-7084-3265-9085-8269-3286-77", "azure"},
+	{"product_name", "OpenStack Nova", "openstack"},
+	{"product_name", "OpenStack Compute", "openstack"},
+	{"sys_vendor", "OpenStack Foundation", "openstack"},
+	{"chassis_asset_tag", "OpenTelekomCloud", "openstack"},
+	{"sys_vendor", "VMware, Inc.", "vmware"},
+	{"product_name", "VMware", "vmware"},
+}
+
+// labels maps filesystem labels to the flag and mount point of the
+// datasource they hold. Labels are matched case-insensitively.
+var labels = []struct {
+	label string
+	flag  string
+	value string
+}{
+	{"config-2", "from-configdrive", "/media/configdrive"},
+	{"cidata", "from-nocloud", "/media/cidata"},
+}
+
+// Result holds the settings detected for a machine.
+type Result struct {
+	// OEM is the OEM whose settings apply, or "" if the platform is unknown.
+	OEM string
+
+	// Flags are the command-line flags of the datasources which were found,
+	// in addition to those of the OEM.
+	Flags map[string]string
+
+	// Reasons explain what was detected, for logging.
+	Reasons []string
+}
+
+// Detect inspects the sysfs, /dev and /proc trees under root.
+func Detect(root string) Result {
+	r := Result{Flags: map[string]string{}}
+
+	for _, p := range platforms {
+		value, err := ReadDMI(root, p.field)
+		if err != nil || !strings.Contains(strings.ToLower(value), strings.ToLower(p.value)) {
+			continue
+		}
+		r.OEM = p.oem
+		r.Reasons = append(r.Reasons, fmt.Sprintf("DMI %s %q matches OEM %q", p.field, value, p.oem))
+		break
+	}
+
+	devices, _ := ioutil.ReadDir(path.Join(root, labelPath))
+	for _, l := range labels {
+		for _, d := range devices {
+			if !strings.EqualFold(d.Name(), l.label) {
+				continue
+			}
+			if _, ok := r.Flags[l.flag]; !ok {
+				r.Flags[l.flag] = l.value
+				r.Reasons = append(r.Reasons, fmt.Sprintf("filesystem label %q found, using -%s=%s", d.Name(), l.flag, l.value))
+			}
+		}
+	}
+
+	tags, _ := filepath.Glob(path.Join(root, virtfsTagGlob))
+	for _, tag := range tags {
+		t, err := ioutil.ReadFile(tag)
+		if err != nil || strings.TrimRight(string(t), "\x00\n") != "config-2" {
+			continue
+		}
+		if _, ok := r.Flags["from-configdrive"]; !ok {
+			r.Flags["from-configdrive"] = "/media/configvirtfs"
+			r.Reasons = append(r.Reasons, "virtfs tag \"config-2\" found, using -from-configdrive=/media/configvirtfs")
+		}
+	}
+
+	if cmdline, err := ioutil.ReadFile(path.Join(root, cmdlinePath)); err == nil {
+		for _, opt := range strings.Fields(string(cmdline)) {
+			key := strings.SplitN(opt, "=", 2)[0]
+			if strings.Replace(key, "_", "-", -1) == cmdlineURLFlag {
+				r.Flags["from-proc-cmdline"] = "true"
+				r.Reasons = append(r.Reasons, fmt.Sprintf("%s found on the kernel command line, using -from-proc-cmdline", cmdlineURLFlag))
+				break
+			}
+		}
+	}
+
+	return r
+}
+
+// ReadDMI returns the value of the DMI field (e.g. "product_name") from the
+// sysfs tree under root.
+func ReadDMI(root, field string) (string, error) {
+	value, err := ioutil.ReadFile(path.Join(root, dmiPath, field))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
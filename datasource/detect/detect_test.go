@@ -0,0 +1,174 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package detect
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	for i, tt := range []struct {
+		files map[string]string
+
+		oem   string
+		flags map[string]string
+	}{
+		{
+			files: map[string]string{},
+			flags: map[string]string{},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":        "Amazon EC2\n",
+				"sys/class/dmi/id/chassis_asset_tag": "Amazon EC2\n",
+			},
+			oem:   "ec2-compat",
+			flags: map[string]string{},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":      "Xen\n",
+				"sys/class/dmi/id/product_version": "4.11.amazon\n",
+			},
+			oem:   "ec2-compat",
+			flags: map[string]string{},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/product_name": "Google Compute Engine\n",
+			},
+			oem:   "gce",
+			flags: map[string]string{},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":   "DigitalOcean\n",
+				"sys/class/dmi/id/product_name": "Droplet\n",
+			},
+			oem:   "digitalocean",
+			flags: map[string]string{},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/product_name": "CloudSigma\n",
+			},
+			oem:   "cloudsigma",
+			flags: map[string]string{},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":        "Microsoft Corporation\n",
+				"sys/class/dmi/id/chassis_asset_tag": "7783-7084-3265-9085-8269-3286-77\n",
+			},
+			oem:   "azure",
+			flags: map[string]string{},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":   "OpenStack Foundation\n",
+				"sys/class/dmi/id/product_name": "OpenStack Nova\n",
+				"dev/disk/by-label/config-2":    "",
+			},
+			oem:   "openstack",
+			flags: map[string]string{"from-configdrive": "/media/configdrive"},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":   "VMware, Inc.\n",
+				"sys/class/dmi/id/product_name": "VMware Virtual Platform\n",
+			},
+			oem:   "vmware",
+			flags: map[string]string{},
+		},
+		{
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":   "QEMU\n",
+				"sys/class/dmi/id/product_name": "Standard PC (Q35 + ICH9, 2009)\n",
+				"dev/disk/by-label/CIDATA":      "",
+			},
+			flags: map[string]string{"from-nocloud": "/media/cidata"},
+		},
+		{
+			files: map[string]string{
+				"sys/bus/virtio/drivers/9pnet_virtio/virtio1/mount_tag": "config-2\x00",
+				"sys/bus/virtio/drivers/9pnet_virtio/virtio2/mount_tag": "shared\x00",
+			},
+			flags: map[string]string{"from-configdrive": "/media/configvirtfs"},
+		},
+		{
+			files: map[string]string{
+				"dev/disk/by-label/config-2":                            "",
+				"sys/bus/virtio/drivers/9pnet_virtio/virtio1/mount_tag": "config-2\x00",
+			},
+			flags: map[string]string{"from-configdrive": "/media/configdrive"},
+		},
+		{
+			files: map[string]string{
+				"proc/cmdline": "BOOT_IMAGE=/vmlinuz cloud_config_url=tftp://10.0.0.1/user_data\n",
+			},
+			flags: map[string]string{"from-proc-cmdline": "true"},
+		},
+	} {
+		root, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+		if err != nil {
+			t.Fatalf("failed creating tempdir: %v", err)
+		}
+		defer os.RemoveAll(root)
+		for name, contents := range tt.files {
+			if err := os.MkdirAll(path.Dir(path.Join(root, name)), 0755); err != nil {
+				t.Fatalf("failed creating directory: %v", err)
+			}
+			if err := ioutil.WriteFile(path.Join(root, name), []byte(contents), 0644); err != nil {
+				t.Fatalf("failed writing file: %v", err)
+			}
+		}
+
+		r := Detect(root)
+		if r.OEM != tt.oem {
+			t.Errorf("bad OEM (#%d): want %q, got %q", i, tt.oem, r.OEM)
+		}
+		if !reflect.DeepEqual(tt.flags, r.Flags) {
+			t.Errorf("bad flags (#%d): want %v, got %v", i, tt.flags, r.Flags)
+		}
+		if len(tt.files) > 0 && len(r.Reasons) == 0 {
+			t.Errorf("bad reasons (#%d): want some, got none", i)
+		}
+	}
+}
+
+func TestReadDMI(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(path.Join(root, dmiPath), 0755); err != nil {
+		t.Fatalf("failed creating directory: %v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(root, dmiPath, "product_name"), []byte("CloudSigma\n"), 0444); err != nil {
+		t.Fatalf("failed writing file: %v", err)
+	}
+
+	if name, err := ReadDMI(root, "product_name"); err != nil || name != "CloudSigma" {
+		t.Errorf("bad product_name: want %q, got %q (%v)", "CloudSigma", name, err)
+	}
+	if _, err := ReadDMI(root, "sys_vendor"); err == nil {
+		t.Errorf("bad error: want non-nil, got nil")
+	}
+}
@@ -42,6 +42,10 @@ func (f *localFile) ConfigRoot() string {
 	return ""
 }
 
+func (f *localFile) Location() string {
+	return f.path
+}
+
 func (f *localFile) FetchMetadata() (datasource.Metadata, error) {
 	return datasource.Metadata{}, nil
 }
@@ -50,6 +54,14 @@ func (f *localFile) FetchUserdata() ([]byte, error) {
 	return ioutil.ReadFile(f.path)
 }
 
+func (f *localFile) FetchUserdataSignature() ([]byte, error) {
+	sig, err := ioutil.ReadFile(f.path + datasource.SignatureSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return sig, err
+}
+
 func (f *localFile) Type() string {
 	return "local-file"
 }
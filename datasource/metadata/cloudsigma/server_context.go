@@ -21,10 +21,10 @@ import (
 	"errors"
 	"io/ioutil"
 	"net"
-	"os"
 	"strings"
 
 	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/datasource/detect"
 
 	"github.com/cloudsigma/cepgo"
 )
@@ -49,14 +49,8 @@ func NewServerContextService() *serverContextService {
 }
 
 func (_ *serverContextService) IsAvailable() bool {
-	productNameFile, err := os.Open("/sys/class/dmi/id/product_name")
-	if err != nil {
-		return false
-	}
-	productName := make([]byte, 10)
-	_, err = productNameFile.Read(productName)
-
-	return err == nil && string(productName) == "CloudSigma" && hasDHCPLeases()
+	productName, err := detect.ReadDMI("/", "product_name")
+	return err == nil && strings.HasPrefix(productName, "CloudSigma") && hasDHCPLeases()
 }
 
 func (_ *serverContextService) AvailabilityChanges() bool {
@@ -101,6 +95,7 @@ func (scs *serverContextService) FetchMetadata() (metadata datasource.Metadata,
 		return
 	}
 
+	metadata.InstanceID = inputMetadata.UUID
 	if inputMetadata.Name != "" {
 		metadata.Hostname = inputMetadata.Name
 	} else {
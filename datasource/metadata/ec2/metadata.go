@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/coreos/coreos-cloudinit/datasource"
@@ -29,17 +30,36 @@ import (
 
 const (
 	DefaultAddress = "http://169.254.169.254/"
-	apiVersion     = "2009-04-04/"
+	apiVersion     = "latest/"
 	userdataPath   = apiVersion + "user-data"
 	metadataPath   = apiVersion + "meta-data"
 )
 
+// Interface describes a network interface attached to the instance, as
+// published under network/interfaces/macs/<mac>/.
+type Interface struct {
+	MAC             string
+	DeviceNumber    int
+	LocalIPv4s      []net.IP
+	PublicIPv4s     []net.IP
+	IPv6s           []net.IP
+	SubnetIPv4CIDR  string
+	SubnetIPv6CIDRs []string
+}
+
+// NetworkData is stored in datasource.Metadata.NetworkConfig.
+type NetworkData struct {
+	Interfaces []Interface
+}
+
 type metadataService struct {
 	metadata.MetadataService
 }
 
 func NewDatasource(root string) *metadataService {
-	return &metadataService{metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, nil)}
+	ms := metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, nil)
+	ms.Client = newSessionClient(ms.Root)
+	return &metadataService{ms}
 }
 
 func (ms metadataService) FetchMetadata() (datasource.Metadata, error) {
@@ -86,9 +106,97 @@ func (ms metadataService) FetchMetadata() (datasource.Metadata, error) {
 		return metadata, err
 	}
 
+	if instanceID, err := ms.fetchAttribute(fmt.Sprintf("%s/instance-id", ms.MetadataUrl())); err == nil {
+		metadata.InstanceID = instanceID
+	} else if _, ok := err.(pkg.ErrNotFound); !ok {
+		return metadata, err
+	}
+
+	if zone, err := ms.fetchAttribute(fmt.Sprintf("%s/placement/availability-zone", ms.MetadataUrl())); err == nil {
+		metadata.Zone = zone
+	} else if _, ok := err.(pkg.ErrNotFound); !ok {
+		return metadata, err
+	}
+
+	if region, err := ms.fetchAttribute(fmt.Sprintf("%s/placement/region", ms.MetadataUrl())); err == nil {
+		metadata.Region = region
+	} else if _, ok := err.(pkg.ErrNotFound); !ok {
+		return metadata, err
+	}
+
+	primaryMAC, err := ms.fetchAttribute(fmt.Sprintf("%s/mac", ms.MetadataUrl()))
+	if _, ok := err.(pkg.ErrNotFound); err != nil && !ok {
+		return metadata, err
+	}
+
+	netdata, err := ms.fetchNetworkData()
+	if err != nil {
+		return metadata, err
+	}
+	for _, iface := range netdata.Interfaces {
+		if iface.MAC == primaryMAC && len(iface.IPv6s) > 0 {
+			metadata.PublicIPv6 = iface.IPv6s[0]
+		}
+	}
+	if len(netdata.Interfaces) > 0 {
+		metadata.NetworkConfig = netdata
+	}
+
 	return metadata, nil
 }
 
+// fetchNetworkData walks network/interfaces/macs/ and collects the addresses
+// of every attached interface.
+func (ms metadataService) fetchNetworkData() (netdata NetworkData, err error) {
+	macsURL := fmt.Sprintf("%s/network/interfaces/macs", ms.MetadataUrl())
+	macs, err := ms.fetchAttributes(macsURL)
+	if _, ok := err.(pkg.ErrNotFound); ok {
+		return netdata, nil
+	} else if err != nil {
+		return
+	}
+
+	for _, mac := range macs {
+		mac = strings.TrimSuffix(mac, "/")
+		if mac == "" {
+			continue
+		}
+		prefix := fmt.Sprintf("%s/%s", macsURL, mac)
+		iface := Interface{MAC: mac}
+
+		if number, err := ms.fetchAttribute(prefix + "/device-number"); err == nil && number != "" {
+			if iface.DeviceNumber, err = strconv.Atoi(number); err != nil {
+				return netdata, fmt.Errorf("malformed device-number for %q: %q", mac, number)
+			}
+		} else if _, ok := err.(pkg.ErrNotFound); err != nil && !ok {
+			return netdata, err
+		}
+		if iface.LocalIPv4s, err = ms.fetchIPs(prefix + "/local-ipv4s"); err != nil {
+			return
+		}
+		if iface.PublicIPv4s, err = ms.fetchIPs(prefix + "/public-ipv4s"); err != nil {
+			return
+		}
+		if iface.IPv6s, err = ms.fetchIPs(prefix + "/ipv6s"); err != nil {
+			return
+		}
+		if cidr, err := ms.fetchAttribute(prefix + "/subnet-ipv4-cidr-block"); err == nil {
+			iface.SubnetIPv4CIDR = cidr
+		} else if _, ok := err.(pkg.ErrNotFound); !ok {
+			return netdata, err
+		}
+		if cidrs, err := ms.fetchAttributes(prefix + "/subnet-ipv6-cidr-blocks"); err == nil && len(cidrs) > 0 {
+			iface.SubnetIPv6CIDRs = cidrs
+		} else if _, ok := err.(pkg.ErrNotFound); err != nil && !ok {
+			return netdata, err
+		}
+
+		netdata.Interfaces = append(netdata.Interfaces, iface)
+	}
+
+	return netdata, nil
+}
+
 func (ms metadataService) Type() string {
 	return "ec2-metadata-service"
 }
@@ -106,6 +214,25 @@ func (ms metadataService) fetchAttributes(url string) ([]string, error) {
 	return data, scanner.Err()
 }
 
+func (ms metadataService) fetchIPs(url string) ([]net.IP, error) {
+	attrs, err := ms.fetchAttributes(url)
+	if _, ok := err.(pkg.ErrNotFound); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, attr := range attrs {
+		ip := net.ParseIP(attr)
+		if ip == nil {
+			return nil, fmt.Errorf("couldn't parse %q as IP address", attr)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
 func (ms metadataService) fetchAttribute(url string) (string, error) {
 	if attrs, err := ms.fetchAttributes(url); err == nil && len(attrs) > 0 {
 		return attrs[0], nil
@@ -194,6 +194,47 @@ func TestFetchMetadata(t *testing.T) {
 				SSHPublicKeys: map[string]string{"test1": "key"},
 			},
 		},
+		{
+			root:         "/",
+			metadataPath: "latest/meta-data",
+			resources: map[string]string{
+				"/latest/meta-data/hostname":                                                         "host",
+				"/latest/meta-data/instance-id":                                                      "i-1234567890abcdef0",
+				"/latest/meta-data/placement/availability-zone":                                      "us-west-2a",
+				"/latest/meta-data/placement/region":                                                 "us-west-2",
+				"/latest/meta-data/local-ipv4":                                                       "10.0.0.5",
+				"/latest/meta-data/mac":                                                              "0e:49:61:0f:c3:11",
+				"/latest/meta-data/network/interfaces/macs":                                          "0e:49:61:0f:c3:11/\n0e:03:6c:3c:b6:7d/",
+				"/latest/meta-data/network/interfaces/macs/0e:49:61:0f:c3:11/device-number":          "0",
+				"/latest/meta-data/network/interfaces/macs/0e:49:61:0f:c3:11/local-ipv4s":            "10.0.0.5\n10.0.0.6",
+				"/latest/meta-data/network/interfaces/macs/0e:49:61:0f:c3:11/ipv6s":                  "2001:db8::1",
+				"/latest/meta-data/network/interfaces/macs/0e:49:61:0f:c3:11/subnet-ipv4-cidr-block": "10.0.0.0/24",
+				"/latest/meta-data/network/interfaces/macs/0e:03:6c:3c:b6:7d/device-number":          "1",
+				"/latest/meta-data/network/interfaces/macs/0e:03:6c:3c:b6:7d/local-ipv4s":            "10.0.1.5",
+			},
+			expect: datasource.Metadata{
+				InstanceID:    "i-1234567890abcdef0",
+				Hostname:      "host",
+				Region:        "us-west-2",
+				Zone:          "us-west-2a",
+				PrivateIPv4:   net.ParseIP("10.0.0.5"),
+				PublicIPv6:    net.ParseIP("2001:db8::1"),
+				SSHPublicKeys: map[string]string{},
+				NetworkConfig: NetworkData{Interfaces: []Interface{
+					{
+						MAC:            "0e:49:61:0f:c3:11",
+						LocalIPv4s:     []net.IP{net.ParseIP("10.0.0.5"), net.ParseIP("10.0.0.6")},
+						IPv6s:          []net.IP{net.ParseIP("2001:db8::1")},
+						SubnetIPv4CIDR: "10.0.0.0/24",
+					},
+					{
+						MAC:          "0e:03:6c:3c:b6:7d",
+						DeviceNumber: 1,
+						LocalIPv4s:   []net.IP{net.ParseIP("10.0.1.5")},
+					},
+				}},
+			},
+		},
 		{
 			clientErr: pkg.ErrTimeout{Err: fmt.Errorf("test error")},
 			expectErr: pkg.ErrTimeout{Err: fmt.Errorf("test error")},
@@ -0,0 +1,133 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/coreos-cloudinit/pkg"
+)
+
+const (
+	tokenPath           = "latest/api/token"
+	tokenHeader         = "X-aws-ec2-metadata-token"
+	tokenTTLHeader      = "X-aws-ec2-metadata-token-ttl-seconds"
+	tokenTTL            = 6 * time.Hour
+	tokenRefreshMargin  = time.Minute
+	imdsv1RetryInterval = time.Minute
+)
+
+// sessionClient is a pkg.Getter which performs the IMDSv2 session handshake
+// and sends the resulting token with every request. If the token endpoint
+// does not exist, it falls back to plain IMDSv1 requests until it tries to
+// get a token again. The token is fetched like the request it is needed for:
+// with retries for GetRetry, and with the caller's context for GetContext.
+type sessionClient struct {
+	tokenURL string
+	client   *pkg.HttpClient
+	tokens   *pkg.HttpClient
+	now      func() time.Time
+
+	token   string
+	expires time.Time
+	imdsv1  bool
+}
+
+func newSessionClient(root string) *sessionClient {
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	ttl := strconv.Itoa(int(tokenTTL / time.Second))
+	return &sessionClient{
+		tokenURL: root + tokenPath,
+		client:   pkg.NewHttpClientHeader(http.Header{}),
+		tokens:   pkg.NewHttpClientHeader(http.Header{tokenTTLHeader: {ttl}}),
+		now:      time.Now,
+	}
+}
+
+func (c *sessionClient) Get(url string) ([]byte, error) {
+	return c.do(c.client.Context, url, c.client.GetContext, c.tokens.PutContext)
+}
+
+func (c *sessionClient) GetRetry(url string) ([]byte, error) {
+	return c.do(c.client.Context, url, c.client.GetRetryContext, c.tokens.PutRetryContext)
+}
+
+func (c *sessionClient) GetContext(ctx context.Context, url string) ([]byte, error) {
+	return c.do(ctx, url, c.client.GetContext, c.tokens.PutContext)
+}
+
+type fetchFunc func(context.Context, string) ([]byte, error)
+
+func (c *sessionClient) do(ctx context.Context, url string, get, put fetchFunc) ([]byte, error) {
+	if err := c.refreshToken(ctx, put, false); err != nil {
+		return nil, err
+	}
+	data, err := get(ctx, url)
+	if _, ok := err.(pkg.ErrUnauthorized); ok && !c.imdsv1 {
+		// The token was rejected before it was due to expire; get a new
+		// one and try once more.
+		if err := c.refreshToken(ctx, put, true); err != nil {
+			return nil, err
+		}
+		return get(ctx, url)
+	}
+	return data, err
+}
+
+// refreshToken fetches a new session token with put if there is none, if the
+// current one is about to expire or if force is set. Without a token endpoint,
+// it falls back to IMDSv1 and only tries again after imdsv1RetryInterval.
+func (c *sessionClient) refreshToken(ctx context.Context, put fetchFunc, force bool) error {
+	if c.imdsv1 && c.now().Before(c.expires) {
+		return nil
+	}
+	if !force && c.token != "" && c.now().Add(tokenRefreshMargin).Before(c.expires) {
+		return nil
+	}
+
+	now := c.now()
+	token, err := put(ctx, c.tokenURL)
+	switch pkg.StatusCode(err) {
+	case 0:
+		if err != nil {
+			return err
+		}
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		log.Printf("No session token endpoint at %q, falling back to IMDSv1\n", c.tokenURL)
+		c.imdsv1 = true
+		c.token = ""
+		c.expires = now.Add(imdsv1RetryInterval)
+		c.client.Header.Del(tokenHeader)
+		return nil
+	default:
+		// Surfaced as a plain error so that it is not mistaken for a
+		// missing attribute
+		return fmt.Errorf("failed fetching session token from %q: %v", c.tokenURL, err)
+	}
+
+	c.imdsv1 = false
+	c.token = strings.TrimSpace(string(token))
+	c.expires = now.Add(tokenTTL)
+	c.client.Header.Set(tokenHeader, c.token)
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-cloudinit/pkg"
+)
+
+func TestSessionClient(t *testing.T) {
+	tokens := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.Header.Get(tokenTTLHeader) != "21600" {
+			http.Error(w, "", 400)
+			return
+		}
+		tokens++
+		fmt.Fprintf(w, "token-%d", tokens)
+	})
+	mux.HandleFunc("/latest/meta-data/hostname", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(tokenHeader) != fmt.Sprintf("token-%d", tokens) {
+			http.Error(w, "", 401)
+			return
+		}
+		fmt.Fprint(w, "host")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	now := time.Unix(0, 0)
+	client := newSessionClient(ts.URL)
+	client.now = func() time.Time { return now }
+
+	for i, tt := range []struct {
+		elapsed time.Duration
+		tokens  int
+	}{
+		{0, 1},
+		{time.Hour, 1},
+		{tokenTTL, 2},
+	} {
+		now = now.Add(tt.elapsed)
+		data, err := client.GetRetry(ts.URL + "/latest/meta-data/hostname")
+		if err != nil {
+			t.Fatalf("bad error (%d): want nil, got %v", i, err)
+		}
+		if string(data) != "host" {
+			t.Fatalf("bad data (%d): want %q, got %q", i, "host", data)
+		}
+		if tokens != tt.tokens {
+			t.Fatalf("bad token count (%d): want %d, got %d", i, tt.tokens, tokens)
+		}
+	}
+
+	// A revoked token is refreshed and the request retried once
+	tokens++
+	if data, err := client.Get(ts.URL + "/latest/meta-data/hostname"); err != nil || string(data) != "host" {
+		t.Fatalf("bad fetch after revocation: want (%q, nil), got (%q, %v)", "host", data, err)
+	}
+	if tokens != 4 {
+		t.Fatalf("bad token count after revocation: want %d, got %d", 4, tokens)
+	}
+}
+
+func TestSessionClientFallback(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusMethodNotAllowed} {
+		tokens := false
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "PUT" {
+				if !tokens {
+					http.Error(w, "", status)
+					return
+				}
+				fmt.Fprint(w, "token")
+				return
+			}
+			if tokens != (r.Header.Get(tokenHeader) == "token") {
+				http.Error(w, "", 400)
+				return
+			}
+			fmt.Fprint(w, "host")
+		}))
+		defer ts.Close()
+
+		now := time.Unix(0, 0)
+		client := newSessionClient(ts.URL)
+		client.now = func() time.Time { return now }
+		data, err := client.Get(ts.URL + "/latest/meta-data/hostname")
+		if err != nil {
+			t.Fatalf("bad error (%d): want nil, got %v", status, err)
+		}
+		if string(data) != "host" {
+			t.Fatalf("bad data (%d): want %q, got %q", status, "host", data)
+		}
+		if !client.imdsv1 {
+			t.Fatalf("bad fallback (%d): want IMDSv1, got IMDSv2", status)
+		}
+
+		// The token endpoint is tried again once the retry interval has passed
+		tokens = true
+		now = now.Add(imdsv1RetryInterval)
+		if data, err := client.Get(ts.URL + "/latest/meta-data/hostname"); err != nil || string(data) != "host" {
+			t.Fatalf("bad fetch after retry (%d): want (%q, nil), got (%q, %v)", status, "host", data, err)
+		}
+		if client.imdsv1 {
+			t.Fatalf("bad retry (%d): want IMDSv2, got IMDSv1", status)
+		}
+	}
+}
+
+func TestSessionClientTokenError(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusForbidden} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "PUT" {
+				http.Error(w, "", status)
+				return
+			}
+			fmt.Fprint(w, "host")
+		}))
+		defer ts.Close()
+
+		client := newSessionClient(ts.URL)
+		_, err := client.Get(ts.URL + "/latest/meta-data/hostname")
+		if err == nil {
+			t.Fatalf("bad error (%d): want non-nil, got nil", status)
+		}
+		if _, ok := err.(pkg.ErrNotFound); ok {
+			t.Fatalf("bad error (%d): must not be mistaken for a missing attribute, got %#v", status, err)
+		}
+		if client.imdsv1 {
+			t.Fatalf("bad fallback (%d): only a missing token endpoint falls back to IMDSv1", status)
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+
+	client := newSessionClient(ts.URL)
+	if _, err := client.Get(ts.URL + "/latest/meta-data/hostname"); err == nil {
+		t.Fatalf("bad error: want non-nil, got nil")
+	}
+	if client.imdsv1 {
+		t.Fatalf("bad fallback: network errors must not fall back to IMDSv1")
+	}
+}
+
+func TestSessionClientTokenRetry(t *testing.T) {
+	puts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			puts++
+			if puts == 1 {
+				http.Error(w, "", 503)
+				return
+			}
+			fmt.Fprint(w, "token")
+			return
+		}
+		if r.Header.Get(tokenHeader) != "token" {
+			http.Error(w, "", 401)
+			return
+		}
+		fmt.Fprint(w, "host")
+	}))
+	defer ts.Close()
+
+	client := newSessionClient(ts.URL)
+	client.tokens.InitialBackoff = time.Millisecond
+	client.tokens.MaxBackoff = time.Millisecond
+	if data, err := client.GetRetry(ts.URL + "/latest/meta-data/hostname"); err != nil || string(data) != "host" {
+		t.Fatalf("bad fetch: want (%q, nil), got (%q, %v)", "host", data, err)
+	}
+	if puts != 2 {
+		t.Fatalf("bad token attempts: want %d, got %d", 2, puts)
+	}
+
+	// The token is fetched with the caller's context
+	client = newSessionClient(ts.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.GetContext(ctx, ts.URL+"/latest/meta-data/hostname"); err == nil {
+		t.Fatalf("bad error: want non-nil, got nil")
+	}
+	if puts != 2 {
+		t.Fatalf("bad token attempts with a canceled context: want %d, got %d", 2, puts)
+	}
+}
@@ -27,6 +27,10 @@ const (
 	apiVersion   = "computeMetadata/v1/"
 	metadataPath = apiVersion + "instance/"
 	userdataPath = apiVersion + "instance/attributes/user-data"
+
+	// Vendor-data may be set for an instance or for the whole project.
+	instanceVendordataPath = apiVersion + "instance/attributes/vendor-data"
+	projectVendordataPath  = apiVersion + "project/attributes/vendor-data"
 )
 
 type metadataService struct {
@@ -50,14 +54,31 @@ func (ms metadataService) FetchMetadata() (datasource.Metadata, error) {
 	if err != nil {
 		return datasource.Metadata{}, err
 	}
+	id, err := ms.fetchString("id")
+	if err != nil {
+		return datasource.Metadata{}, err
+	}
 
 	return datasource.Metadata{
+		InstanceID:  id,
 		PublicIPv4:  public,
 		PrivateIPv4: local,
 		Hostname:    hostname,
 	}, nil
 }
 
+// FetchVendordata returns the instance's vendor-data attribute, falling back
+// to the project's, or nil if neither is set.
+func (ms metadataService) FetchVendordata() ([]byte, error) {
+	for _, p := range []string{instanceVendordataPath, projectVendordataPath} {
+		data, err := ms.FetchData(ms.Root + p)
+		if err != nil || len(data) > 0 {
+			return data, err
+		}
+	}
+	return nil, nil
+}
+
 func (ms metadataService) Type() string {
 	return "gce-metadata-service"
 }
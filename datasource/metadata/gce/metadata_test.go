@@ -62,10 +62,12 @@ func TestFetchMetadata(t *testing.T) {
 			metadataPath: "computeMetadata/v1/instance/",
 			resources: map[string]string{
 				"/computeMetadata/v1/instance/hostname":                                          "host",
+				"/computeMetadata/v1/instance/id":                                                "1234567890",
 				"/computeMetadata/v1/instance/network-interfaces/0/ip":                           "1.2.3.4",
 				"/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip": "5.6.7.8",
 			},
 			expect: datasource.Metadata{
+				InstanceID:  "1234567890",
 				Hostname:    "host",
 				PrivateIPv4: net.ParseIP("1.2.3.4"),
 				PublicIPv4:  net.ParseIP("5.6.7.8"),
@@ -91,6 +93,42 @@ func TestFetchMetadata(t *testing.T) {
 	}
 }
 
+func TestFetchVendordata(t *testing.T) {
+	for i, tt := range []struct {
+		resources map[string]string
+		expect    string
+	}{
+		{
+			resources: map[string]string{},
+		},
+		{
+			resources: map[string]string{
+				"/computeMetadata/v1/project/attributes/vendor-data": "project",
+			},
+			expect: "project",
+		},
+		{
+			resources: map[string]string{
+				"/computeMetadata/v1/instance/attributes/vendor-data": "instance",
+				"/computeMetadata/v1/project/attributes/vendor-data":  "project",
+			},
+			expect: "instance",
+		},
+	} {
+		service := &metadataService{metadata.MetadataService{
+			Root:   "/",
+			Client: &test.HttpClient{Resources: tt.resources},
+		}}
+		data, err := service.FetchVendordata()
+		if err != nil {
+			t.Errorf("bad error (#%d): want %v, got %v", i, nil, err)
+		}
+		if string(data) != tt.expect {
+			t.Errorf("bad vendor-data (#%d): want %q, got %q", i, tt.expect, data)
+		}
+	}
+}
+
 func Error(err error) string {
 	if err != nil {
 		return err.Error()
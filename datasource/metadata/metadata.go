@@ -15,6 +15,7 @@
 package metadata
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
@@ -37,7 +38,11 @@ func NewDatasource(root, apiVersion, userdataPath, metadataPath string, header h
 }
 
 func (ms MetadataService) IsAvailable() bool {
-	_, err := ms.Client.Get(ms.Root + ms.ApiVersion)
+	return ms.IsAvailableContext(context.Background())
+}
+
+func (ms MetadataService) IsAvailableContext(ctx context.Context) bool {
+	_, err := pkg.GetContext(ctx, ms.Client, ms.Root+ms.ApiVersion)
 	return (err == nil)
 }
 
@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openstack
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/datasource/metadata"
+)
+
+const (
+	DefaultAddress    = "http://169.254.169.254/"
+	apiVersion        = "openstack/latest"
+	userdataPath      = apiVersion + "/user_data"
+	metadataPath      = apiVersion + "/meta_data.json"
+	vendordataPath    = apiVersion + "/vendor_data.json"
+	networkConfigPath = apiVersion + "/network_data.json"
+)
+
+type metadataService struct {
+	metadata.MetadataService
+}
+
+func NewDatasource(root string) *metadataService {
+	return &metadataService{MetadataService: metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, nil)}
+}
+
+// FetchMetadata reads meta_data.json from the metadata service and fills in
+// the same fields as the config-drive datasource. The network config is taken
+// from the legacy "network_config" content if present, otherwise from
+// network_data.json, in which case it is a datasource.OpenStackNetworkData.
+func (ms *metadataService) FetchMetadata() (metadata datasource.Metadata, err error) {
+	var data []byte
+	var m struct {
+		UUID                string            `json:"uuid"`
+		SSHAuthorizedKeyMap map[string]string `json:"public_keys"`
+		Hostname            string            `json:"hostname"`
+		NetworkConfig       struct {
+			ContentPath string `json:"content_path"`
+		} `json:"network_config"`
+	}
+
+	if data, err = ms.FetchData(ms.MetadataUrl()); err != nil || len(data) == 0 {
+		return
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return
+	}
+
+	metadata.InstanceID = m.UUID
+	metadata.SSHPublicKeys = m.SSHAuthorizedKeyMap
+	metadata.Hostname = m.Hostname
+
+	var netconf []byte
+	if m.NetworkConfig.ContentPath != "" {
+		if netconf, err = ms.FetchData(ms.Root + "openstack/" + strings.TrimPrefix(m.NetworkConfig.ContentPath, "/")); err == nil && len(netconf) > 0 {
+			metadata.NetworkConfig = netconf
+		}
+	} else if netconf, err = ms.FetchData(ms.Root + networkConfigPath); err == nil && len(netconf) > 0 {
+		metadata.NetworkConfig = datasource.OpenStackNetworkData(netconf)
+	}
+
+	return
+}
+
+// FetchVendordata returns the vendor-data for cloud-init from
+// vendor_data.json, or nil if the provider does not publish any.
+func (ms *metadataService) FetchVendordata() ([]byte, error) {
+	data, err := ms.FetchData(ms.Root + vendordataPath)
+	if err != nil {
+		return nil, err
+	}
+	return datasource.ParseOpenStackVendordata(data)
+}
+
+func (ms metadataService) Type() string {
+	return "openstack-metadata-service"
+}
@@ -0,0 +1,153 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openstack
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/datasource/metadata"
+	"github.com/coreos/coreos-cloudinit/datasource/metadata/test"
+	"github.com/coreos/coreos-cloudinit/pkg"
+)
+
+func TestType(t *testing.T) {
+	want := "openstack-metadata-service"
+	if kind := (metadataService{}).Type(); kind != want {
+		t.Fatalf("bad type: want %q, got %q", want, kind)
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		root      string
+		resources map[string]string
+		expect    datasource.Metadata
+		clientErr error
+		expectErr error
+	}{
+		{
+			root:      "/",
+			resources: map[string]string{},
+		},
+		{
+			root: "/",
+			resources: map[string]string{
+				"/openstack/latest/meta_data.json": "bad",
+			},
+			expectErr: fmt.Errorf("invalid character 'b' looking for beginning of value"),
+		},
+		{
+			root: "/",
+			resources: map[string]string{
+				"/openstack/latest/meta_data.json": `{"uuid": "83679162-1378-4288-a2d4-70e13ec132aa", "hostname": "host", "public_keys": {"mykey": "key1"}}`,
+			},
+			expect: datasource.Metadata{
+				InstanceID:    "83679162-1378-4288-a2d4-70e13ec132aa",
+				Hostname:      "host",
+				SSHPublicKeys: map[string]string{"mykey": "key1"},
+			},
+		},
+		{
+			root: "/",
+			resources: map[string]string{
+				"/openstack/latest/meta_data.json":    `{"hostname": "host"}`,
+				"/openstack/latest/network_data.json": `{"links": []}`,
+			},
+			expect: datasource.Metadata{
+				Hostname:      "host",
+				NetworkConfig: datasource.OpenStackNetworkData(`{"links": []}`),
+			},
+		},
+		{
+			root: "/",
+			resources: map[string]string{
+				"/openstack/latest/meta_data.json":    `{"hostname": "host", "network_config": {"content_path": "/content/0000"}}`,
+				"/openstack/content/0000":             "auto eth0",
+				"/openstack/latest/network_data.json": `{"links": []}`,
+			},
+			expect: datasource.Metadata{
+				Hostname:      "host",
+				NetworkConfig: []byte("auto eth0"),
+			},
+		},
+		{
+			clientErr: pkg.ErrTimeout{Err: fmt.Errorf("test error")},
+			expectErr: pkg.ErrTimeout{Err: fmt.Errorf("test error")},
+		},
+	} {
+		service := &metadataService{
+			MetadataService: metadata.MetadataService{
+				Root:         tt.root,
+				Client:       &test.HttpClient{Resources: tt.resources, Err: tt.clientErr},
+				MetadataPath: metadataPath,
+			},
+		}
+		metadata, err := service.FetchMetadata()
+		if Error(err) != Error(tt.expectErr) {
+			t.Fatalf("bad error (%q): want %q, got %q", tt.resources, tt.expectErr, err)
+		}
+		if !reflect.DeepEqual(tt.expect, metadata) {
+			t.Fatalf("bad fetch (%q): want %#v, got %#v", tt.resources, tt.expect, metadata)
+		}
+	}
+}
+
+func TestFetchVendordata(t *testing.T) {
+	for _, tt := range []struct {
+		resources map[string]string
+		expect    string
+	}{
+		{
+			resources: map[string]string{},
+			expect:    "",
+		},
+		{
+			resources: map[string]string{
+				"/openstack/latest/vendor_data.json": `{"cloud-init": "#cloud-config"}`,
+			},
+			expect: "#cloud-config",
+		},
+		{
+			resources: map[string]string{
+				"/openstack/latest/vendor_data.json": `{"other-agent": {"enabled": true}}`,
+			},
+			expect: "",
+		},
+	} {
+		service := &metadataService{
+			MetadataService: metadata.MetadataService{
+				Root:   "/",
+				Client: &test.HttpClient{Resources: tt.resources},
+			},
+		}
+		data, err := service.FetchVendordata()
+		if err != nil {
+			t.Fatalf("bad error (%q): want nil, got %q", tt.resources, err)
+		}
+		if string(data) != tt.expect {
+			t.Fatalf("bad vendordata (%q): want %q, got %q", tt.resources, tt.expect, data)
+		}
+	}
+}
+
+func Error(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -52,6 +52,7 @@ type NetworkData struct {
 
 // Metadata that will be pulled from the https://metadata.packet.net/metadata only. We have the opportunity to add more later.
 type Metadata struct {
+	ID          string      `json:"id"`
 	Hostname    string      `json:"hostname"`
 	SSHKeys     []string    `json:"ssh_keys"`
 	NetworkData NetworkData `json:"network"`
@@ -90,6 +91,7 @@ func (ms *metadataService) FetchMetadata() (metadata datasource.Metadata, err er
 			}
 		}
 	}
+	metadata.InstanceID = m.ID
 	metadata.Hostname = m.Hostname
 	metadata.SSHPublicKeys = map[string]string{}
 	for i, key := range m.SSHKeys {
@@ -0,0 +1,153 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nocloud
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/coreos-cloudinit/datasource"
+
+	"github.com/coreos/yaml"
+)
+
+const (
+	metadataFile      = "meta-data"
+	userdataFile      = "user-data"
+	networkConfigFile = "network-config"
+)
+
+type noCloud struct {
+	root     string
+	readFile func(filename string) ([]byte, error)
+}
+
+func NewDatasource(root string) *noCloud {
+	return &noCloud{root, ioutil.ReadFile}
+}
+
+func (nc *noCloud) IsAvailable() bool {
+	_, err := os.Stat(path.Join(nc.root, metadataFile))
+	return !os.IsNotExist(err)
+}
+
+func (nc *noCloud) AvailabilityChanges() bool {
+	return true
+}
+
+func (nc *noCloud) ConfigRoot() string {
+	return nc.root
+}
+
+func (nc *noCloud) FetchMetadata() (metadata datasource.Metadata, err error) {
+	var data []byte
+	var m struct {
+		InstanceID    string      `yaml:"instance_id"`
+		LocalHostname string      `yaml:"local_hostname"`
+		Hostname      string      `yaml:"hostname"`
+		PublicKeys    interface{} `yaml:"public_keys"`
+	}
+
+	if data, err = nc.tryReadFile(path.Join(nc.root, metadataFile)); err != nil || len(data) == 0 {
+		return
+	}
+
+	// Keys in meta-data are hyphenated (e.g. "local-hostname"); normalize them
+	// the same way cloud-config keys are.
+	yaml.UnmarshalMappingKeyTransform = func(nameIn string) (nameOut string) {
+		return strings.Replace(nameIn, "-", "_", -1)
+	}
+	if err = yaml.Unmarshal(data, &m); err != nil {
+		return
+	}
+
+	metadata.InstanceID = m.InstanceID
+	metadata.Hostname = m.LocalHostname
+	if metadata.Hostname == "" {
+		metadata.Hostname = m.Hostname
+	}
+	if metadata.SSHPublicKeys, err = parsePublicKeys(m.PublicKeys); err != nil {
+		return
+	}
+
+	var netconf []byte
+	if netconf, err = nc.tryReadFile(path.Join(nc.root, networkConfigFile)); err != nil {
+		return
+	}
+	if len(netconf) > 0 {
+		metadata.NetworkConfig = netconf
+	}
+
+	return
+}
+
+func (nc *noCloud) FetchUserdata() ([]byte, error) {
+	return nc.tryReadFile(path.Join(nc.root, userdataFile))
+}
+
+func (nc *noCloud) FetchUserdataSignature() ([]byte, error) {
+	return nc.tryReadFile(path.Join(nc.root, userdataFile+datasource.SignatureSuffix))
+}
+
+func (nc *noCloud) Type() string {
+	return "nocloud"
+}
+
+func (nc *noCloud) tryReadFile(filename string) ([]byte, error) {
+	log.Printf("Attempting to read from %q\n", filename)
+	data, err := nc.readFile(filename)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return data, err
+}
+
+// parsePublicKeys accepts the forms of "public-keys" found in the wild: a
+// single key, a list of keys or a map of key names to keys.
+func parsePublicKeys(raw interface{}) (map[string]string, error) {
+	switch keys := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return map[string]string{"0": keys}, nil
+	case []interface{}:
+		out := map[string]string{}
+		for i, key := range keys {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("malformed public key: %v", key)
+			}
+			out[strconv.Itoa(i)] = k
+		}
+		return out, nil
+	case map[interface{}]interface{}:
+		out := map[string]string{}
+		for name, key := range keys {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("malformed public key %v: %v", name, key)
+			}
+			out[fmt.Sprint(name)] = k
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("malformed public-keys: %v", raw)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nocloud
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/datasource/test"
+)
+
+func TestFetchMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		root  string
+		files test.MockFilesystem
+
+		metadata datasource.Metadata
+	}{
+		{
+			root:  "/",
+			files: test.NewMockFilesystem(),
+		},
+		{
+			root:  "/",
+			files: test.NewMockFilesystem(test.File{Path: "/meta-data", Contents: ""}),
+		},
+		{
+			root:     "/",
+			files:    test.NewMockFilesystem(test.File{Path: "/meta-data", Contents: "instance-id: iid-local01\nlocal-hostname: host\n"}),
+			metadata: datasource.Metadata{InstanceID: "iid-local01", Hostname: "host"},
+		},
+		{
+			root:     "/",
+			files:    test.NewMockFilesystem(test.File{Path: "/meta-data", Contents: "hostname: host\npublic-keys: key1\n"}),
+			metadata: datasource.Metadata{Hostname: "host", SSHPublicKeys: map[string]string{"0": "key1"}},
+		},
+		{
+			root: "/media/cidata",
+			files: test.NewMockFilesystem(
+				test.File{Path: "/media/cidata/meta-data", Contents: "instance-id: iid-local01\nlocal-hostname: host\npublic-keys:\n  - key1\n  - key2\n"},
+				test.File{Path: "/media/cidata/network-config", Contents: "version: 1\n"},
+			),
+			metadata: datasource.Metadata{
+				InstanceID:    "iid-local01",
+				Hostname:      "host",
+				NetworkConfig: []byte("version: 1\n"),
+				SSHPublicKeys: map[string]string{
+					"0": "key1",
+					"1": "key2",
+				},
+			},
+		},
+		{
+			root:  "/",
+			files: test.NewMockFilesystem(test.File{Path: "/meta-data", Contents: "public-keys:\n  alice: key1\n  bob: key2\n"}),
+			metadata: datasource.Metadata{SSHPublicKeys: map[string]string{
+				"alice": "key1",
+				"bob":   "key2",
+			}},
+		},
+	} {
+		nc := noCloud{tt.root, tt.files.ReadFile}
+		metadata, err := nc.FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error for %+v: want %v, got %q", tt, nil, err)
+		}
+		if !reflect.DeepEqual(tt.metadata, metadata) {
+			t.Fatalf("bad metadata for %+v: want %#v, got %#v", tt, tt.metadata, metadata)
+		}
+	}
+}
+
+func TestFetchMetadataMalformed(t *testing.T) {
+	nc := noCloud{"/", test.NewMockFilesystem(test.File{Path: "/meta-data", Contents: "public-keys:\n  - [nested]\n"}).ReadFile}
+	if _, err := nc.FetchMetadata(); err == nil {
+		t.Fatalf("bad error: want non-nil, got nil")
+	}
+}
+
+func TestFetchUserdata(t *testing.T) {
+	for _, tt := range []struct {
+		root  string
+		files test.MockFilesystem
+
+		userdata string
+	}{
+		{
+			"/",
+			test.NewMockFilesystem(),
+			"",
+		},
+		{
+			"/",
+			test.NewMockFilesystem(test.File{Path: "/user-data", Contents: "userdata"}),
+			"userdata",
+		},
+		{
+			"/media/cidata",
+			test.NewMockFilesystem(test.File{Path: "/media/cidata/user-data", Contents: "userdata"}),
+			"userdata",
+		},
+	} {
+		nc := noCloud{tt.root, tt.files.ReadFile}
+		userdata, err := nc.FetchUserdata()
+		if err != nil {
+			t.Fatalf("bad error for %+v: want %v, got %q", tt, nil, err)
+		}
+		if string(userdata) != tt.userdata {
+			t.Fatalf("bad userdata for %+v: want %q, got %q", tt, tt.userdata, userdata)
+		}
+	}
+}
+
+func TestFetchUserdataSignature(t *testing.T) {
+	for _, tt := range []struct {
+		root  string
+		files test.MockFilesystem
+
+		sig string
+	}{
+		{
+			"/",
+			test.NewMockFilesystem(test.File{Path: "/user-data", Contents: "userdata"}),
+			"",
+		},
+		{
+			"/media/cidata",
+			test.NewMockFilesystem(test.File{Path: "/media/cidata/user-data.sig", Contents: "sig"}),
+			"sig",
+		},
+	} {
+		nc := noCloud{tt.root, tt.files.ReadFile}
+		sig, err := nc.FetchUserdataSignature()
+		if err != nil {
+			t.Fatalf("bad error for %+v: want %v, got %q", tt, nil, err)
+		}
+		if string(sig) != tt.sig {
+			t.Fatalf("bad signature for %+v: want %q, got %q", tt, tt.sig, sig)
+		}
+	}
+}
+
+func TestConfigRoot(t *testing.T) {
+	for _, tt := range []struct {
+		root       string
+		configRoot string
+	}{
+		{
+			"/",
+			"/",
+		},
+		{
+			"/media/cidata",
+			"/media/cidata",
+		},
+	} {
+		nc := noCloud{tt.root, nil}
+		if configRoot := nc.ConfigRoot(); configRoot != tt.configRoot {
+			t.Fatalf("bad config root for %q: want %q, got %q", tt.root, tt.configRoot, configRoot)
+		}
+	}
+}
@@ -15,9 +15,10 @@
 package proc_cmdline
 
 import (
-	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"strings"
 
 	"github.com/coreos/coreos-cloudinit/datasource"
@@ -27,6 +28,13 @@ import (
 const (
 	ProcCmdlineLocation        = "/proc/cmdline"
 	ProcCmdlineCloudConfigFlag = "cloud-config-url"
+
+	ProcCmdlineProxyFlag   = "cloud-config-proxy"
+	ProcCmdlineNoProxyFlag = "cloud-config-no-proxy"
+	ProcCmdlineCAFlag      = "cloud-config-ca"
+	ProcCmdlineCertFlag    = "cloud-config-cert"
+	ProcCmdlineKeyFlag     = "cloud-config-key"
+	ProcCmdlineTokenFlag   = "cloud-config-token"
 )
 
 type procCmdline struct {
@@ -61,48 +69,123 @@ func (c *procCmdline) FetchMetadata() (datasource.Metadata, error) {
 }
 
 func (c *procCmdline) FetchUserdata() ([]byte, error) {
-	contents, err := ioutil.ReadFile(c.Location)
+	url, err := c.cloudConfigURL()
 	if err != nil {
 		return nil, err
 	}
 
-	cmdline := strings.TrimSpace(string(contents))
-	url, err := findCloudConfigURL(cmdline)
+	client := pkg.NewFetcher()
+	cfg, err := client.GetRetry(url)
 	if err != nil {
 		return nil, err
 	}
 
-	client := pkg.NewHttpClient()
-	cfg, err := client.GetRetry(url)
+	return cfg, nil
+}
+
+func (c *procCmdline) FetchUserdataSignature() ([]byte, error) {
+	url, err := c.cloudConfigURL()
 	if err != nil {
 		return nil, err
 	}
+	if datasource.IsDataURL(url) {
+		return nil, nil
+	}
 
-	return cfg, nil
+	client := pkg.NewFetcher()
+	sig, err := client.GetRetry(url + datasource.SignatureSuffix)
+	if _, ok := err.(pkg.ErrNotFound); ok {
+		return nil, nil
+	}
+	return sig, err
+}
+
+func (c *procCmdline) cloudConfigURL() (string, error) {
+	contents, err := ioutil.ReadFile(c.Location)
+	if err != nil {
+		return "", err
+	}
+
+	cmdline := strings.TrimSpace(string(contents))
+	return findCloudConfigURL(cmdline)
 }
 
 func (c *procCmdline) Type() string {
 	return "proc-cmdline"
 }
 
-func findCloudConfigURL(input string) (url string, err error) {
-	err = errors.New("cloud-config-url not found")
+// HttpConfig reads the HTTP client settings (proxy, CA bundle, client
+// certificate and bearer token, which is only sent to the host of the
+// cloud-config-url) from the kernel command line at location.
+// Options which are not given are left empty.
+func HttpConfig(location string) (pkg.HttpConfig, error) {
+	contents, err := ioutil.ReadFile(location)
+	if err != nil {
+		return pkg.HttpConfig{}, err
+	}
+
+	cmdline := strings.TrimSpace(string(contents))
+	cfg := pkg.HttpConfig{}
+	cfg.Proxy, _ = findOption(cmdline, ProcCmdlineProxyFlag)
+	cfg.NoProxy, _ = findOption(cmdline, ProcCmdlineNoProxyFlag)
+	cfg.CAFile, _ = findOption(cmdline, ProcCmdlineCAFlag)
+	cfg.CertFile, _ = findOption(cmdline, ProcCmdlineCertFlag)
+	cfg.KeyFile, _ = findOption(cmdline, ProcCmdlineKeyFlag)
+	cfg.BearerToken, _ = findOption(cmdline, ProcCmdlineTokenFlag)
+	if rawurl, err := findCloudConfigURL(cmdline); err == nil {
+		if u, err := url.Parse(rawurl); err == nil {
+			cfg.BearerTokenHost = u.Host
+		}
+	}
+	return cfg, nil
+}
+
+// Options returns the options in the kernel command line at location whose
+// names start with prefix, keyed by the rest of their name. Underscores in
+// option names are treated as dashes.
+func Options(location, prefix string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := map[string]string{}
+	for _, token := range strings.Fields(string(contents)) {
+		parts := strings.SplitN(token, "=", 2)
+		key := strings.Replace(parts[0], "_", "-", -1)
+		if len(parts) != 2 || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		opts[strings.TrimPrefix(key, prefix)] = parts[1]
+	}
+	return opts, nil
+}
+
+func findCloudConfigURL(input string) (string, error) {
+	return findOption(input, ProcCmdlineCloudConfigFlag)
+}
+
+// findOption returns the value of the last occurrence of the option name in
+// the kernel command line input. Underscores in option names are treated as
+// dashes.
+func findOption(input, name string) (value string, err error) {
+	err = fmt.Errorf("%s not found", name)
 	for _, token := range strings.Split(input, " ") {
 		parts := strings.SplitN(token, "=", 2)
 
 		key := parts[0]
 		key = strings.Replace(key, "_", "-", -1)
 
-		if key != "cloud-config-url" {
+		if key != name {
 			continue
 		}
 
 		if len(parts) != 2 {
-			log.Printf("Found cloud-config-url in /proc/cmdline with no value, ignoring.")
+			log.Printf("Found %s in /proc/cmdline with no value, ignoring.", name)
 			continue
 		}
 
-		url = parts[1]
+		value = parts[1]
 		err = nil
 	}
 
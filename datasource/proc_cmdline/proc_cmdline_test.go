@@ -20,7 +20,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
+
+	"github.com/coreos/coreos-cloudinit/pkg"
 )
 
 func TestParseCmdlineCloudConfigFound(t *testing.T) {
@@ -100,3 +103,79 @@ func TestProcCmdlineAndFetchConfig(t *testing.T) {
 		t.Errorf("Test failed, response body: %s != %s", cfg, CloudConfigContent)
 	}
 }
+
+func TestHttpConfig(t *testing.T) {
+	tests := []struct {
+		cmdline string
+		expect  pkg.HttpConfig
+	}{
+		{
+			"cloud-config-url=https://example.com/config",
+			pkg.HttpConfig{BearerTokenHost: "example.com"},
+		},
+		{
+			"cloud-config-proxy=http://proxy:3128 cloud_config_no_proxy=.internal,10.0.0.0/8 cloud-config-ca=/ca.pem cloud-config-cert=/cert.pem cloud-config-key=/key.pem cloud-config-token=abc=",
+			pkg.HttpConfig{
+				Proxy:       "http://proxy:3128",
+				NoProxy:     ".internal,10.0.0.0/8",
+				CAFile:      "/ca.pem",
+				CertFile:    "/cert.pem",
+				KeyFile:     "/key.pem",
+				BearerToken: "abc=",
+			},
+		},
+		{
+			"cloud-config-url=https://example.com:8443/config cloud-config-token=abc",
+			pkg.HttpConfig{BearerToken: "abc", BearerTokenHost: "example.com:8443"},
+		},
+	}
+
+	for i, tt := range tests {
+		file, err := ioutil.TempFile(os.TempDir(), "test_proc_cmdline")
+		if err != nil {
+			t.Fatalf("Test produced error: %v", err)
+		}
+		defer os.Remove(file.Name())
+		if _, err := file.WriteString(tt.cmdline + "\n"); err != nil {
+			t.Fatalf("Test produced error: %v", err)
+		}
+		file.Close()
+
+		cfg, err := HttpConfig(file.Name())
+		if err != nil {
+			t.Errorf("Test case %d produced error: %v", i, err)
+		}
+		if cfg != tt.expect {
+			t.Errorf("Test case %d failed: %+v != %+v", i, cfg, tt.expect)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	file, err := ioutil.TempFile(os.TempDir(), "test_proc_cmdline")
+	if err != nil {
+		t.Fatalf("Test produced error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString("ro cloud-config-url=http://example.com cloud_config_http_retries=5 cloud-config-flag cloud-config-ec2-metadata.timeout=1m=x\n"); err != nil {
+		t.Fatalf("Test produced error: %v", err)
+	}
+	file.Close()
+
+	opts, err := Options(file.Name(), "cloud-config-")
+	if err != nil {
+		t.Errorf("Test produced error: %v", err)
+	}
+	expect := map[string]string{
+		"url":                  "http://example.com",
+		"http-retries":         "5",
+		"ec2-metadata.timeout": "1m=x",
+	}
+	if !reflect.DeepEqual(opts, expect) {
+		t.Errorf("Test failed: %v != %v", opts, expect)
+	}
+
+	if _, err := Options(file.Name()+".missing", "cloud-config-"); err == nil {
+		t.Errorf("Test failed: expected an error for a missing file")
+	}
+}
@@ -15,6 +15,8 @@
 package url
 
 import (
+	"context"
+
 	"github.com/coreos/coreos-cloudinit/datasource"
 	"github.com/coreos/coreos-cloudinit/pkg"
 )
@@ -28,8 +30,12 @@ func NewDatasource(url string) *remoteFile {
 }
 
 func (f *remoteFile) IsAvailable() bool {
-	client := pkg.NewHttpClient()
-	_, err := client.Get(f.url)
+	return f.IsAvailableContext(context.Background())
+}
+
+func (f *remoteFile) IsAvailableContext(ctx context.Context) bool {
+	client := pkg.NewFetcher()
+	_, err := client.GetContext(ctx, f.url)
 	return (err == nil)
 }
 
@@ -41,15 +47,31 @@ func (f *remoteFile) ConfigRoot() string {
 	return ""
 }
 
+func (f *remoteFile) Location() string {
+	return f.url
+}
+
 func (f *remoteFile) FetchMetadata() (datasource.Metadata, error) {
 	return datasource.Metadata{}, nil
 }
 
 func (f *remoteFile) FetchUserdata() ([]byte, error) {
-	client := pkg.NewHttpClient()
+	client := pkg.NewFetcher()
 	return client.GetRetry(f.url)
 }
 
+func (f *remoteFile) FetchUserdataSignature() ([]byte, error) {
+	if datasource.IsDataURL(f.url) {
+		return nil, nil
+	}
+	client := pkg.NewFetcher()
+	sig, err := client.GetRetry(f.url + datasource.SignatureSuffix)
+	if _, ok := err.(pkg.ErrNotFound); ok {
+		return nil, nil
+	}
+	return sig, err
+}
+
 func (f *remoteFile) Type() string {
 	return "url"
 }
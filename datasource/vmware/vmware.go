@@ -16,12 +16,17 @@ package vmware
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
+	"strings"
 
 	"github.com/coreos/coreos-cloudinit/config"
 	"github.com/coreos/coreos-cloudinit/datasource"
 )
 
+// productUUIDPath holds the UUID which VMware assigns to every virtual machine.
+var productUUIDPath = "/sys/class/dmi/id/product_uuid"
+
 type readConfigFunction func(key string) (string, error)
 type urlDownloadFunction func(url string) ([]byte, error)
 
@@ -41,6 +46,9 @@ func (v vmware) ConfigRoot() string {
 
 func (v vmware) FetchMetadata() (metadata datasource.Metadata, err error) {
 	metadata.Hostname, _ = v.readConfig("hostname")
+	if uuid, err := ioutil.ReadFile(productUUIDPath); err == nil {
+		metadata.InstanceID = strings.ToLower(strings.TrimSpace(string(uuid)))
+	}
 
 	netconf := map[string]string{}
 	saveConfig := func(key string, args ...interface{}) string {
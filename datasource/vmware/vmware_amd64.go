@@ -96,6 +96,6 @@ func getOvfReadConfig(ovfEnv []byte) readConfigFunction {
 }
 
 func urlDownload(url string) ([]byte, error) {
-	client := pkg.NewHttpClient()
+	client := pkg.NewFetcher()
 	return client.GetRetry(url)
 }
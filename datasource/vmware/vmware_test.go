@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path"
 	"reflect"
 	"testing"
 
@@ -44,12 +45,27 @@ var fakeDownloader urlDownloadFunction = func(url string) ([]byte, error) {
 }
 
 func TestFetchMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p string) { productUUIDPath = p }(productUUIDPath)
+
 	tests := []struct {
 		variables MockHypervisor
+		uuid      string
 
 		metadata datasource.Metadata
 		err      error
 	}{
+		{
+			uuid: "564D1A2B-0000-0000-0000-000000000001\n",
+			metadata: datasource.Metadata{
+				InstanceID:    "564d1a2b-0000-0000-0000-000000000001",
+				NetworkConfig: map[string]string{},
+			},
+		},
 		{
 			variables: map[string]string{
 				"interface.0.mac":  "test mac",
@@ -129,6 +145,13 @@ func TestFetchMetadata(t *testing.T) {
 	}
 
 	for i, tt := range tests {
+		productUUIDPath = path.Join(dir, "missing")
+		if tt.uuid != "" {
+			productUUIDPath = path.Join(dir, "product_uuid")
+			if err := ioutil.WriteFile(productUUIDPath, []byte(tt.uuid), 0644); err != nil {
+				t.Fatalf("unable to write file: %v", err)
+			}
+		}
 		v := vmware{readConfig: tt.variables.ReadConfig}
 		metadata, err := v.FetchMetadata()
 		if !reflect.DeepEqual(tt.err, err) {
@@ -218,6 +241,9 @@ func TestFetchUserdataError(t *testing.T) {
 }
 
 func TestOvfTransport(t *testing.T) {
+	defer func(p string) { productUUIDPath = p }(productUUIDPath)
+	productUUIDPath = "/nonexistent/product_uuid"
+
 	tests := []struct {
 		document string
 
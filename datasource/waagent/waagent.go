@@ -88,6 +88,7 @@ func (a *waagent) FetchMetadata() (metadata datasource.Metadata, err error) {
 		}
 	}
 
+	metadata.InstanceID = instance.Id
 	metadata.PrivateIPv4 = net.ParseIP(instance.Address)
 	for _, e := range instance.InputEndpoints.Endpoints {
 		host, _, err := net.SplitHostPort(e.LoadBalancedPublicAddress)
@@ -81,6 +81,7 @@ func TestFetchMetadata(t *testing.T) {
   </Instances>
 </SharedConfig>`}),
 			metadata: datasource.Metadata{
+				InstanceID:  "core-test-1",
 				PrivateIPv4: net.ParseIP("100.73.202.64"),
 				PublicIPv4:  net.ParseIP("191.239.39.77"),
 			},
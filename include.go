@@ -0,0 +1,142 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/initialize"
+)
+
+// include is a single document fetched from an "#include" URL.
+type include struct {
+	url  string
+	data []byte
+}
+
+// resolveIncludes replaces "#include" user-data with the documents it refers
+// to. A single document is returned as it is; several are combined, in
+// order, into a MIME multipart message, whose cloud-configs are merged like
+// those of any other multipart user-data. Any other user-data is returned
+// unmodified.
+func resolveIncludes(userdataBytes []byte, fetch func(string) ([]byte, error)) ([]byte, error) {
+	if !config.IsInclude(string(userdataBytes)) {
+		return userdataBytes, nil
+	}
+
+	docs, err := fetchIncludes(userdataBytes, fetch, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 1 {
+		return docs[0].data, nil
+	}
+
+	var parts []include
+	for _, d := range docs {
+		switch s := string(d.data); {
+		case len(d.data) == 0:
+		case config.IsCloudConfig(s), config.IsScript(s), config.IsMultipart(s):
+			parts = append(parts, d)
+		case initialize.IsTemplate(s):
+			return nil, fmt.Errorf("cannot include %s: templates can only be included on their own", d.url)
+		default:
+			return nil, fmt.Errorf("cannot include %s: must be a cloud-config, script or MIME multipart", d.url)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	return composeMultipart(parts)
+}
+
+// fetchIncludes fetches each of the URLs listed in userdataBytes, following
+// nested includes. stack holds the URLs of the includes currently being
+// followed so that loops can be detected.
+func fetchIncludes(userdataBytes []byte, fetch func(string) ([]byte, error), stack config.IncludeStack) ([]include, error) {
+	var docs []include
+	for _, url := range config.IncludeURLs(string(userdataBytes)) {
+		nested, err := stack.Push(url)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Including user-data from %s\n", url)
+		data, err := fetch(url)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching %s: %v", url, err)
+		}
+		if data, err = decompressIfGzip(data); err != nil {
+			return nil, fmt.Errorf("failed decompressing %s: %v", url, err)
+		}
+
+		if !config.IsInclude(string(data)) {
+			docs = append(docs, include{url: url, data: data})
+			continue
+		}
+		included, err := fetchIncludes(data, fetch, nested)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, included...)
+	}
+	return docs, nil
+}
+
+// composeMultipart returns a MIME multipart message made up of the included
+// documents, in order.
+func composeMultipart(docs []include) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, d := range docs {
+		contentType, data := "text/cloud-config", d.data
+		switch s := string(d.data); {
+		case config.IsScript(s):
+			contentType = "text/x-shellscript"
+		case config.IsMultipart(s):
+			// Nested as a part of its own, with its header moved to
+			// the part
+			msg, err := mail.ReadMessage(bytes.NewReader(d.data))
+			if err != nil {
+				return nil, fmt.Errorf("cannot include %s: %v", d.url, err)
+			}
+			if data, err = ioutil.ReadAll(msg.Body); err != nil {
+				return nil, err
+			}
+			contentType = msg.Header.Get("Content-Type")
+		}
+
+		p, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n", w.Boundary())
+	return append([]byte(header), body.Bytes()...), nil
+}
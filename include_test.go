@@ -0,0 +1,158 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/initialize"
+)
+
+func TestResolveIncludes(t *testing.T) {
+	docs := map[string]string{
+		"http://a": "#cloud-config\nhostname: a\nssh_authorized_keys:\n  - a\n",
+		"http://b": "#cloud-config\nhostname: b\nssh-authorized-keys:\n  - b\n",
+		"http://c": "#include\nhttp://a\n",
+		"http://d": "#include\nhttp://d\n",
+		"http://e": "#!/bin/sh\necho e\n",
+		"http://f": "#include\nhttp://f1\n",
+		"http://g": "{\"ignition\": {\"version\": \"2.0.0\"}}",
+		"http://h": "#cloud-config\ncoreos:\n  units:\n    - name: x.service\n      command: start\nusers:\n  - name: core\n    groups: [a]\n",
+		"http://i": "#cloud-config\ncoreos:\n  units:\n    - name: x.service\n      enable: true\nusers:\n  - name: core\n    passwd: x\n",
+		"http://j": "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/x-shellscript\n\n#!/bin/sh\necho j\n--x\nContent-Type: text/cloud-config\n\n#cloud-config\nhostname: j\n--x--\n",
+		"http://m": "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/x-include-url\n\nhttp://m\n--x--\n",
+		"http://t": "## template: go\n#cloud-config\nhostname: {{ .Hostname }}\n",
+		"http://z": string(mustDecode("H4sIAJWV/VUAA1NOzskvTdFNzs9Ly0wHABt6mQENAAAA")),
+	}
+	for i := 1; i <= config.MaxIncludeDepth; i++ {
+		docs["http://f"+strconv.Itoa(i)] = "#include\nhttp://f" + strconv.Itoa(i+1) + "\n"
+	}
+	fetch := func(url string) ([]byte, error) {
+		if d, ok := docs[url]; ok {
+			return []byte(d), nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	for i, tt := range []struct {
+		userdata string
+
+		ud  interface{}
+		err bool
+	}{
+		{
+			userdata: "#cloud-config\nhostname: x\n",
+			ud:       &config.CloudConfig{Hostname: "x"},
+		},
+		{
+			userdata: "#include\nhttp://z\n",
+			ud:       &config.CloudConfig{},
+		},
+		{
+			userdata: "#include\n# comment\nhttp://a\n\nhttp://b\n",
+			ud: &initialize.UserData{
+				CloudConfig: &config.CloudConfig{Hostname: "b", SSHAuthorizedKeys: []string{"a", "b"}},
+			},
+		},
+		{
+			userdata: "#include\nhttp://c\nhttp://a\n",
+			ud: &initialize.UserData{
+				CloudConfig: &config.CloudConfig{Hostname: "a", SSHAuthorizedKeys: []string{"a", "a"}},
+			},
+		},
+		{
+			userdata: "#include\nhttp://h\nhttp://i\n",
+			ud: &initialize.UserData{
+				CloudConfig: &config.CloudConfig{
+					CoreOS: config.CoreOS{Units: []config.Unit{{Name: "x.service", Command: "start", Enable: true}}},
+					Users:  []config.User{{Name: "core", Groups: []string{"a"}, PasswordHash: "x"}},
+				},
+			},
+		},
+		{
+			userdata: "#include\nhttp://a\nhttp://j\n",
+			ud: &initialize.UserData{
+				CloudConfig: &config.CloudConfig{Hostname: "j", SSHAuthorizedKeys: []string{"a"}},
+				Scripts:     []config.Script{config.Script("#!/bin/sh\necho j")},
+			},
+		},
+		{
+			userdata: "#include\nhttp://a\nhttp://t\n",
+			err:      true,
+		},
+		{
+			userdata: "#include\nhttp://b\nhttp://e\n",
+			ud: &initialize.UserData{
+				CloudConfig: &config.CloudConfig{Hostname: "b", SSHAuthorizedKeys: []string{"b"}},
+				Scripts:     []config.Script{config.Script("#!/bin/sh\necho e\n")},
+			},
+		},
+		{
+			userdata: "#include\nhttp://d\n",
+			err:      true,
+		},
+		{
+			userdata: "#include\nhttp://f\n",
+			err:      true,
+		},
+		{
+			userdata: "#include\nhttp://a\nhttp://g\n",
+			err:      true,
+		},
+		{
+			userdata: "#include\nhttp://missing\n",
+			err:      true,
+		},
+	} {
+		resolved, err := resolveIncludes([]byte(tt.userdata), fetch)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		ud, err := initialize.ParseUserData(string(resolved))
+		if err != nil {
+			t.Errorf("bad user-data (#%d): %v\n%s", i, err, resolved)
+			continue
+		}
+		if !reflect.DeepEqual(tt.ud, ud) {
+			t.Errorf("bad user-data (#%d): want %#v, got %#v", i, tt.ud, ud)
+		}
+	}
+
+	// Includes in included multipart documents are guarded like
+	// "#include" documents
+	defer func(f func(string) ([]byte, error)) { initialize.FetchIncludeURL = f }(initialize.FetchIncludeURL)
+	initialize.FetchIncludeURL = fetch
+	resolved, err := resolveIncludes([]byte("#include\nhttp://a\nhttp://m\n"), fetch)
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if _, err := initialize.ParseUserData(string(resolved)); err == nil || !strings.Contains(err.Error(), "include loop at http://m") {
+		t.Errorf("bad error: want include loop, got %v", err)
+	}
+
+	// A template included on its own is left for main to render
+	if resolved, err := resolveIncludes([]byte("#include\nhttp://t\n"), fetch); err != nil || string(resolved) != docs["http://t"] {
+		t.Errorf("bad template: want %q, got %q (%v)", docs["http://t"], resolved, err)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"log"
 	"path"
+	"strings"
 
 	"github.com/coreos/coreos-cloudinit/config"
 	"github.com/coreos/coreos-cloudinit/network"
@@ -41,69 +42,50 @@ type CloudConfigUnit interface {
 
 // Apply renders a CloudConfig to an Environment. This can involve things like
 // configuring the hostname, adding new users, writing various configuration
-// files to disk, and manipulating systemd services.
-func Apply(cfg config.CloudConfig, ifaces []network.InterfaceGenerator, env *Environment) error {
-	if cfg.Hostname != "" {
-		if err := system.SetHostname(cfg.Hostname); err != nil {
+// files to disk, and manipulating systemd services. All changes are made
+// through host. Modules whose inputs have not changed since they were recorded
+// in state are skipped; a nil state applies everything. Items which should
+// only be applied once per instance are skipped unless state is for a new
+// instance.
+func Apply(cfg config.CloudConfig, ifaces []network.InterfaceGenerator, env *Environment, host system.Host, state *State) error {
+	if state != nil && state.replacedInstance {
+		log.Printf("Instance ID changed to %q, regenerating SSH host keys and machine ID", state.InstanceID)
+		if err := host.RegenerateSSHHostKeys(env.Root()); err != nil {
 			return err
 		}
-		log.Printf("Set hostname to %s", cfg.Hostname)
-	}
-
-	for _, user := range cfg.Users {
-		if user.Name == "" {
-			log.Printf("User object has no 'name' field, skipping")
-			continue
+		if err := host.RegenerateMachineID(env.Root()); err != nil {
+			return err
 		}
+	}
 
-		if system.UserExists(&user) {
-			log.Printf("User '%s' exists, ignoring creation-time fields", user.Name)
-			if user.PasswordHash != "" {
-				log.Printf("Setting '%s' user's password", user.Name)
-				if err := system.SetUserPassword(user.Name, user.PasswordHash); err != nil {
-					log.Printf("Failed setting '%s' user's password: %v", user.Name, err)
-					return err
-				}
-			}
-		} else {
-			log.Printf("Creating user '%s'", user.Name)
-			if err := system.CreateUser(&user); err != nil {
-				log.Printf("Failed creating user '%s': %v", user.Name, err)
+	if cfg.Hostname != "" {
+		if err := state.apply("hostname", false, cfg.Hostname, func() error {
+			if err := host.SetHostname(cfg.Hostname); err != nil {
 				return err
 			}
+			log.Printf("Set hostname to %s", cfg.Hostname)
+			return nil
+		}); err != nil {
+			return err
 		}
+	}
 
-		if len(user.SSHAuthorizedKeys) > 0 {
-			log.Printf("Authorizing %d SSH keys for user '%s'", len(user.SSHAuthorizedKeys), user.Name)
-			if err := system.AuthorizeSSHKeys(user.Name, env.SSHKeyName(), user.SSHAuthorizedKeys); err != nil {
-				return err
-			}
-		}
-		if user.SSHImportGithubUser != "" {
-			log.Printf("Authorizing github user %s SSH keys for CoreOS user '%s'", user.SSHImportGithubUser, user.Name)
-			if err := SSHImportGithubUser(user.Name, user.SSHImportGithubUser); err != nil {
-				return err
-			}
-		}
-		for _, u := range user.SSHImportGithubUsers {
-			log.Printf("Authorizing github user %s SSH keys for CoreOS user '%s'", u, user.Name)
-			if err := SSHImportGithubUser(user.Name, u); err != nil {
-				return err
-			}
-		}
-		if user.SSHImportURL != "" {
-			log.Printf("Authorizing SSH keys for CoreOS user '%s' from '%s'", user.Name, user.SSHImportURL)
-			if err := SSHImportKeysFromURL(user.Name, user.SSHImportURL); err != nil {
-				return err
-			}
+	if len(cfg.Users) > 0 {
+		if err := state.apply("users", false, []interface{}{cfg.Users, env.SSHKeyName()}, func() error {
+			return applyUsers(cfg.Users, env, host)
+		}); err != nil {
+			return err
 		}
 	}
 
 	if len(cfg.SSHAuthorizedKeys) > 0 {
-		err := system.AuthorizeSSHKeys("core", env.SSHKeyName(), cfg.SSHAuthorizedKeys)
-		if err == nil {
+		if err := state.apply("ssh_authorized_keys", false, []interface{}{cfg.SSHAuthorizedKeys, env.SSHKeyName()}, func() error {
+			if err := host.AuthorizeSSHKeys("core", env.SSHKeyName(), cfg.SSHAuthorizedKeys); err != nil {
+				return err
+			}
 			log.Printf("Authorized SSH keys for core user")
-		} else {
+			return nil
+		}); err != nil {
 			return err
 		}
 	}
@@ -144,37 +126,171 @@ func Apply(cfg config.CloudConfig, ifaces []network.InterfaceGenerator, env *Env
 	}
 
 	wroteEnvironment := false
+	volatileFiles := false
 	for _, file := range writeFiles {
-		fullPath, err := system.WriteFile(&file, env.Root())
-		if err != nil {
-			return err
-		}
 		if path.Clean(file.Path) == "/etc/environment" {
 			wroteEnvironment = true
 		}
-		log.Printf("Wrote file %s to filesystem", fullPath)
+		if strings.HasPrefix(path.Clean(file.Path), "/run/") {
+			volatileFiles = true
+		}
+	}
+
+	if len(writeFiles) > 0 {
+		if err := state.apply("write_files", volatileFiles, []interface{}{writeFiles, env.Root()}, func() error {
+			for _, file := range writeFiles {
+				if !shouldApply(file.Frequency, state) {
+					log.Printf("Skipping file %s, it is only written once per instance", file.Path)
+					continue
+				}
+				fullPath, err := host.WriteFile(&file, env.Root())
+				if err != nil {
+					return err
+				}
+				log.Printf("Wrote file %s to filesystem", fullPath)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
 	}
 
 	if !wroteEnvironment {
 		ef := env.DefaultEnvironmentFile()
 		if ef != nil {
-			err := system.WriteEnvFile(ef, env.Root())
-			if err != nil {
+			if err := state.apply("environment", false, []interface{}{ef, env.Root()}, func() error {
+				if err := host.WriteEnvFile(ef, env.Root()); err != nil {
+					return err
+				}
+				log.Printf("Updated /etc/environment")
+				return nil
+			}); err != nil {
 				return err
 			}
-			log.Printf("Updated /etc/environment")
+		}
+	}
+
+	// Only meta-data which came from a datasource is published
+	if env.datasourceType != "" {
+		md, err := env.Metadata()
+		if err != nil {
+			return err
+		}
+		if err := state.apply("metadata", true, []interface{}{md, env.Root()}, func() error {
+			if err := writeMetadata(md, host, env.Root()); err != nil {
+				return err
+			}
+			log.Printf("Wrote meta-data to %s", path.Dir(metadataEnvPath))
+			return nil
+		}); err != nil {
+			return err
 		}
 	}
 
 	if len(ifaces) > 0 {
-		units = append(units, createNetworkingUnits(ifaces)...)
-		if err := system.RestartNetwork(ifaces); err != nil {
+		networkingUnits := createNetworkingUnits(ifaces)
+		units = append(units, networkingUnits...)
+		if err := state.apply("network", true, networkingUnits, func() error {
+			return host.RestartNetwork(ifaces)
+		}); err != nil {
 			return err
 		}
 	}
 
-	um := system.NewUnitManager(env.Root())
-	return processUnits(units, env.Root(), um)
+	if len(units) == 0 {
+		return nil
+	}
+	// Each unit is tracked on its own, so that runtime units (which are
+	// gone after a reboot) don't make the persistent ones be applied again
+	var apply []system.Unit
+	digests := map[string]string{}
+	for _, u := range units {
+		if !shouldApply(u.Frequency, state) {
+			log.Printf("Skipping unit %s, it is only processed once per instance", u.Name)
+			continue
+		}
+		module := "unit " + u.Destination(env.Root())
+		d, unchanged := state.unchanged(module, u.Runtime, []interface{}{u, env.Root()})
+		if unchanged {
+			log.Printf("Skipping unit %s, unchanged since the last run", u.Name)
+			continue
+		}
+		digests[module] = d
+		apply = append(apply, u)
+	}
+	if len(apply) == 0 {
+		return nil
+	}
+	if err := processUnits(apply, env.Root(), host); err != nil {
+		for module := range digests {
+			state.forget(module)
+		}
+		return err
+	}
+	for module, d := range digests {
+		state.record(module, d)
+	}
+	return nil
+}
+
+// shouldApply reports whether an item with the given frequency should be
+// applied.
+func shouldApply(frequency string, state *State) bool {
+	return frequency != config.FrequencyOncePerInstance || state.NewInstance()
+}
+
+// applyUsers creates or updates each of the users and authorizes their SSH
+// keys.
+func applyUsers(users []config.User, env *Environment, host system.Host) error {
+	for _, user := range users {
+		if user.Name == "" {
+			log.Printf("User object has no 'name' field, skipping")
+			continue
+		}
+
+		if host.UserExists(&user) {
+			log.Printf("User '%s' exists, ignoring creation-time fields", user.Name)
+			if user.PasswordHash != "" {
+				log.Printf("Setting '%s' user's password", user.Name)
+				if err := host.SetUserPassword(user.Name, user.PasswordHash); err != nil {
+					log.Printf("Failed setting '%s' user's password: %v", user.Name, err)
+					return err
+				}
+			}
+		} else {
+			log.Printf("Creating user '%s'", user.Name)
+			if err := host.CreateUser(&user); err != nil {
+				log.Printf("Failed creating user '%s': %v", user.Name, err)
+				return err
+			}
+		}
+
+		if len(user.SSHAuthorizedKeys) > 0 {
+			log.Printf("Authorizing %d SSH keys for user '%s'", len(user.SSHAuthorizedKeys), user.Name)
+			if err := host.AuthorizeSSHKeys(user.Name, env.SSHKeyName(), user.SSHAuthorizedKeys); err != nil {
+				return err
+			}
+		}
+		if user.SSHImportGithubUser != "" {
+			log.Printf("Authorizing github user %s SSH keys for CoreOS user '%s'", user.SSHImportGithubUser, user.Name)
+			if err := SSHImportGithubUser(host, user.Name, user.SSHImportGithubUser); err != nil {
+				return err
+			}
+		}
+		for _, u := range user.SSHImportGithubUsers {
+			log.Printf("Authorizing github user %s SSH keys for CoreOS user '%s'", u, user.Name)
+			if err := SSHImportGithubUser(host, user.Name, u); err != nil {
+				return err
+			}
+		}
+		if user.SSHImportURL != "" {
+			log.Printf("Authorizing SSH keys for CoreOS user '%s' from '%s'", user.Name, user.SSHImportURL)
+			if err := SSHImportKeysFromURL(host, user.Name, user.SSHImportURL); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func createNetworkingUnits(interfaces []network.InterfaceGenerator) (units []system.Unit) {
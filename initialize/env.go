@@ -29,11 +29,13 @@ import (
 const DefaultSSHKeyName = "coreos-cloudinit"
 
 type Environment struct {
-	root          string
-	configRoot    string
-	workspace     string
-	sshKeyName    string
-	substitutions map[string]string
+	root           string
+	configRoot     string
+	workspace      string
+	sshKeyName     string
+	substitutions  map[string]string
+	metadata       datasource.Metadata
+	datasourceType string
 }
 
 // TODO(jonboulle): this is getting unwieldy, should be able to simplify the interface somehow
@@ -50,7 +52,7 @@ func NewEnvironment(root, configRoot, workspace, sshKeyName string, metadata dat
 		"$public_ipv6":  firstNonNull(metadata.PublicIPv6, os.Getenv("COREOS_PUBLIC_IPV6")),
 		"$private_ipv6": firstNonNull(metadata.PrivateIPv6, os.Getenv("COREOS_PRIVATE_IPV6")),
 	}
-	return &Environment{root, configRoot, workspace, sshKeyName, substitutions}
+	return &Environment{root, configRoot, workspace, sshKeyName, substitutions, metadata, ""}
 }
 
 func (e *Environment) Workspace() string {
@@ -73,6 +75,11 @@ func (e *Environment) SetSSHKeyName(name string) {
 	e.sshKeyName = name
 }
 
+// SetDatasourceType sets the datasource type exposed to templates.
+func (e *Environment) SetDatasourceType(t string) {
+	e.datasourceType = t
+}
+
 // Apply goes through the map of substitutions and replaces all instances of
 // the keys with their respective values. It supports escaping substitutions
 // with a leading '\'.
@@ -0,0 +1,168 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/system"
+)
+
+const (
+	metadataEnvPath  = "/run/metadata/coreos"
+	metadataJSONPath = "/run/metadata/coreos.json"
+)
+
+// Metadata is the normalized form of the meta-data fetched from the
+// datasource, as published under /run/metadata and given to templates.
+type Metadata struct {
+	Datasource    string            `json:"datasource,omitempty"`
+	InstanceID    string            `json:"instance_id,omitempty"`
+	Hostname      string            `json:"hostname,omitempty"`
+	Region        string            `json:"region,omitempty"`
+	Zone          string            `json:"zone,omitempty"`
+	PublicIPv4    string            `json:"public_ipv4,omitempty"`
+	PrivateIPv4   string            `json:"private_ipv4,omitempty"`
+	PublicIPv6    string            `json:"public_ipv6,omitempty"`
+	PrivateIPv6   string            `json:"private_ipv6,omitempty"`
+	SSHPublicKeys map[string]string `json:"ssh_public_keys,omitempty"`
+	// Interfaces maps the name of each network interface on the machine to
+	// its addresses, in CIDR notation.
+	Interfaces map[string][]string `json:"interfaces,omitempty"`
+}
+
+// interfaceAddrs returns the addresses of the non-loopback network interfaces
+// on the machine. It is a variable so that it can be replaced in tests.
+var interfaceAddrs = func() (map[string][]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	addrs := make(map[string][]string, len(ifaces))
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range ifaddrs {
+			addrs[iface.Name] = append(addrs[iface.Name], a.String())
+		}
+	}
+	return addrs, nil
+}
+
+// Metadata returns the normalized meta-data of the environment.
+func (e *Environment) Metadata() (Metadata, error) {
+	ifaces, err := interfaceAddrs()
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed listing network interfaces: %v", err)
+	}
+	return Metadata{
+		Datasource:    e.datasourceType,
+		InstanceID:    e.metadata.InstanceID,
+		Hostname:      e.metadata.Hostname,
+		Region:        e.metadata.Region,
+		Zone:          e.metadata.Zone,
+		PublicIPv4:    e.substitutions["$public_ipv4"],
+		PrivateIPv4:   e.substitutions["$private_ipv4"],
+		PublicIPv6:    e.substitutions["$public_ipv6"],
+		PrivateIPv6:   e.substitutions["$private_ipv6"],
+		SSHPublicKeys: e.metadata.SSHPublicKeys,
+		Interfaces:    ifaces,
+	}, nil
+}
+
+// JSON returns the meta-data as an indented JSON document.
+func (md Metadata) JSON() ([]byte, error) {
+	out, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+var invalidEnvChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// EnvVars returns the meta-data as environment variables. For each network
+// interface, the first IPv4 and IPv6 addresses are given as
+// COREOS_<INTERFACE>_IPV4 and COREOS_<INTERFACE>_IPV6. SSH keys are only
+// published in the JSON document.
+func (md Metadata) EnvVars() map[string]string {
+	vars := map[string]string{}
+	set := func(key, value string) {
+		if value != "" {
+			vars[key] = value
+		}
+	}
+	set("COREOS_DATASOURCE", md.Datasource)
+	set("COREOS_INSTANCE_ID", md.InstanceID)
+	set("COREOS_HOSTNAME", md.Hostname)
+	set("COREOS_REGION", md.Region)
+	set("COREOS_ZONE", md.Zone)
+	set("COREOS_PUBLIC_IPV4", md.PublicIPv4)
+	set("COREOS_PRIVATE_IPV4", md.PrivateIPv4)
+	set("COREOS_PUBLIC_IPV6", md.PublicIPv6)
+	set("COREOS_PRIVATE_IPV6", md.PrivateIPv6)
+	for name, addrs := range md.Interfaces {
+		prefix := "COREOS_" + invalidEnvChars.ReplaceAllString(strings.ToUpper(name), "_")
+		for _, a := range addrs {
+			ip, _, err := net.ParseCIDR(a)
+			if err != nil {
+				continue
+			}
+			key := prefix + "_IPV6"
+			if ip.To4() != nil {
+				key = prefix + "_IPV4"
+			}
+			if _, ok := vars[key]; !ok {
+				vars[key] = ip.String()
+			}
+		}
+	}
+	return vars
+}
+
+// writeMetadata publishes the meta-data under /run/metadata, both as an
+// environment file (for use with EnvironmentFile=) and as JSON.
+func writeMetadata(md Metadata, host system.Host, root string) error {
+	ef := &system.EnvFile{
+		File: &system.File{File: config.File{
+			Path: metadataEnvPath,
+		}},
+		Vars: md.EnvVars(),
+	}
+	if err := host.WriteEnvFile(ef, root); err != nil {
+		return err
+	}
+
+	js, err := md.JSON()
+	if err != nil {
+		return err
+	}
+	_, err = host.WriteFile(&system.File{File: config.File{
+		Path:               metadataJSONPath,
+		Content:            string(js),
+		RawFilePermissions: "0644",
+	}}, root)
+	return err
+}
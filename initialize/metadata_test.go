@@ -0,0 +1,156 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/system"
+)
+
+func TestMetadataEnvVars(t *testing.T) {
+	for i, tt := range []struct {
+		md Metadata
+
+		vars map[string]string
+	}{
+		{
+			md:   Metadata{},
+			vars: map[string]string{},
+		},
+		{
+			md: Metadata{
+				Datasource:    "gce-metadata-service",
+				InstanceID:    "1234",
+				Hostname:      "host",
+				Region:        "us-central1",
+				Zone:          "us-central1-a",
+				PublicIPv4:    "192.0.2.3",
+				PrivateIPv4:   "10.0.0.5",
+				SSHPublicKeys: map[string]string{"core": "ssh-rsa AAAA"},
+				Interfaces: map[string][]string{
+					"eth0":    {"10.0.0.5/24", "10.0.0.6/24", "fe80::1/64"},
+					"br-data": {"fd00::2/64"},
+					"bad":     {"garbage"},
+				},
+			},
+			vars: map[string]string{
+				"COREOS_DATASOURCE":   "gce-metadata-service",
+				"COREOS_INSTANCE_ID":  "1234",
+				"COREOS_HOSTNAME":     "host",
+				"COREOS_REGION":       "us-central1",
+				"COREOS_ZONE":         "us-central1-a",
+				"COREOS_PUBLIC_IPV4":  "192.0.2.3",
+				"COREOS_PRIVATE_IPV4": "10.0.0.5",
+				"COREOS_ETH0_IPV4":    "10.0.0.5",
+				"COREOS_ETH0_IPV6":    "fe80::1",
+				"COREOS_BR_DATA_IPV6": "fd00::2",
+			},
+		},
+	} {
+		if vars := tt.md.EnvVars(); !reflect.DeepEqual(tt.vars, vars) {
+			t.Errorf("bad vars (#%d): want %v, got %v", i, tt.vars, vars)
+		}
+	}
+}
+
+func TestEnvironmentMetadata(t *testing.T) {
+	defer func(f func() (map[string][]string, error)) { interfaceAddrs = f }(interfaceAddrs)
+	interfaceAddrs = func() (map[string][]string, error) {
+		return map[string][]string{"eth0": {"10.0.0.5/24"}}, nil
+	}
+
+	env := NewEnvironment("/", "", "", "", datasource.Metadata{
+		InstanceID:  "i-1",
+		Hostname:    "host",
+		PrivateIPv4: net.ParseIP("10.0.0.5"),
+	})
+	env.SetDatasourceType("ec2-metadata-service")
+
+	md, err := env.Metadata()
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	js, err := md.JSON()
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	want := `{
+  "datasource": "ec2-metadata-service",
+  "instance_id": "i-1",
+  "hostname": "host",
+  "private_ipv4": "10.0.0.5",
+  "interfaces": {
+    "eth0": [
+      "10.0.0.5/24"
+    ]
+  }
+}
+`
+	if string(js) != want {
+		t.Errorf("bad json: want %q, got %q", want, js)
+	}
+}
+
+func TestApplyWritesMetadata(t *testing.T) {
+	defer func(f func() (map[string][]string, error)) { interfaceAddrs = f }(interfaceAddrs)
+	interfaceAddrs = func() (map[string][]string, error) { return nil, nil }
+
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := NewEnvironment(dir, "", "", "", datasource.Metadata{InstanceID: "i-1"})
+	if err := Apply(config.CloudConfig{}, nil, env, system.NewHost(dir), nil); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, metadataJSONPath)); !os.IsNotExist(err) {
+		t.Errorf("bad metadata: want nothing published without a datasource, got %v", err)
+	}
+
+	env.SetDatasourceType("configdrive")
+	state := NewState()
+	for i := 0; i < 2; i++ {
+		if err := Apply(config.CloudConfig{}, nil, env, system.NewHost(dir), state); err != nil {
+			t.Fatalf("bad error (#%d): want nil, got %v", i, err)
+		}
+		contents, err := ioutil.ReadFile(path.Join(dir, metadataEnvPath))
+		if err != nil {
+			t.Fatalf("bad error (#%d): want nil, got %v", i, err)
+		}
+		if want := "COREOS_DATASOURCE=configdrive\nCOREOS_INSTANCE_ID=i-1\n"; string(contents) != want {
+			t.Errorf("bad env file (#%d): want %q, got %q", i, want, contents)
+		}
+		contents, err = ioutil.ReadFile(path.Join(dir, metadataJSONPath))
+		if err != nil {
+			t.Fatalf("bad error (#%d): want nil, got %v", i, err)
+		}
+		if want := "{\n  \"datasource\": \"configdrive\",\n  \"instance_id\": \"i-1\"\n}\n"; string(contents) != want {
+			t.Errorf("bad json (#%d): want %q, got %q", i, want, contents)
+		}
+	}
+	if _, ok := state.Modules["metadata"]; !ok {
+		t.Errorf("bad state: want metadata recorded, got %v", state.Modules)
+	}
+}
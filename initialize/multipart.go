@@ -0,0 +1,177 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/pkg"
+)
+
+const gzipMagicBytes = "\x1f\x8b"
+
+// FetchIncludeURL fetches the user-data listed in text/x-include-url parts. It
+// may be replaced, e.g. to verify the signatures of what is fetched.
+var FetchIncludeURL = pkg.NewFetcher().GetRetry
+
+// UserData is user-data made up of several parts: the cloud-config parts
+// merged into one, in order, and the scripts in the order in which they
+// appeared.
+type UserData struct {
+	CloudConfig *config.CloudConfig
+	Scripts     []config.Script
+}
+
+// add merges the result of ParseUserData into the UserData.
+func (ud *UserData) add(part interface{}) {
+	switch p := part.(type) {
+	case *config.CloudConfig:
+		ud.addCloudConfig(p)
+	case *config.Script:
+		ud.Scripts = append(ud.Scripts, *p)
+	case *UserData:
+		if p.CloudConfig != nil {
+			ud.addCloudConfig(p.CloudConfig)
+		}
+		ud.Scripts = append(ud.Scripts, p.Scripts...)
+	}
+}
+
+func (ud *UserData) addCloudConfig(cc *config.CloudConfig) {
+	if ud.CloudConfig == nil {
+		ud.CloudConfig = cc
+		return
+	}
+	ud.CloudConfig = config.Merge(ud.CloudConfig, cc, config.MergeAppend)
+}
+
+// parseMultipart parses a MIME multipart message into UserData.
+func parseMultipart(contents string) (*UserData, error) {
+	ud := &UserData{}
+	if err := ud.addMessage(contents, nil); err != nil {
+		return nil, err
+	}
+	return ud, nil
+}
+
+// addMessage adds the parts of a MIME multipart message to the UserData.
+// stack holds the includes being followed.
+func (ud *UserData) addMessage(contents string, stack config.IncludeStack) error {
+	msg, err := mail.ReadMessage(strings.NewReader(contents))
+	if err != nil {
+		return err
+	}
+	return ud.addPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, stack)
+}
+
+// addPart adds a single (possibly multipart) part with the given headers to
+// the UserData. stack holds the includes being followed, so that loops are
+// detected and their depth is limited as for "#include" user-data.
+func (ud *UserData) addPart(contentType, encoding string, body io.Reader, stack config.IncludeStack) error {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(strings.TrimSpace(encoding), "base64") {
+		if data, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), "")); err != nil {
+			return fmt.Errorf("invalid base64 part: %v", err)
+		}
+	}
+	if bytes.HasPrefix(data, []byte(gzipMagicBytes)) {
+		gzr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		data, err = ioutil.ReadAll(gzr)
+		gzr.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	mediaType := "text/plain"
+	params := map[string]string{}
+	if contentType != "" {
+		if mediaType, params, err = mime.ParseMediaType(contentType); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		mr := multipart.NewReader(bytes.NewReader(data), params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := ud.addPart(p.Header.Get("Content-Type"), p.Header.Get("Content-Transfer-Encoding"), p, stack); err != nil {
+				return err
+			}
+		}
+	case mediaType == "text/cloud-config":
+		log.Printf("Parsing user-data part as cloud-config")
+		cc, err := parseCloudConfig(string(data))
+		if err != nil {
+			return err
+		}
+		ud.addCloudConfig(cc)
+	case mediaType == "text/x-shellscript":
+		log.Printf("Parsing user-data part as script")
+		ud.Scripts = append(ud.Scripts, config.Script(data))
+	case mediaType == "text/x-include-url":
+		for _, url := range config.IncludeURLs(string(data)) {
+			nested, err := stack.Push(url)
+			if err != nil {
+				return err
+			}
+			log.Printf("Including user-data from %s", url)
+			included, err := FetchIncludeURL(url)
+			if err != nil {
+				return fmt.Errorf("failed to include %s: %v", url, err)
+			}
+			if err := ud.addPart("", "", bytes.NewReader(included), nested); err != nil {
+				return err
+			}
+		}
+	case config.IsMultipart(string(data)):
+		// Parsed here rather than by ParseUserData, which would lose
+		// track of the includes being followed
+		return ud.addMessage(string(data), stack)
+	default:
+		part, err := ParseUserData(string(data))
+		if err == ErrIgnitionConfig {
+			log.Printf("Ignoring Ignition config in user-data part")
+			return nil
+		} else if err != nil {
+			return err
+		}
+		ud.add(part)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/config"
+)
+
+func gzipBase64(s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestParseMultipart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config":
+			fmt.Fprint(w, "#cloud-config\nssh_authorized_keys:\n  - included\n")
+		case "/script":
+			fmt.Fprint(w, "#!/bin/sh\necho included\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for i, tt := range []struct {
+		contents string
+
+		ud *UserData
+	}{
+		{
+			contents: `Content-Type: multipart/mixed; boundary="BOUNDARY"
+MIME-Version: 1.0
+
+--BOUNDARY
+Content-Type: text/cloud-config
+
+hostname: first
+ssh_authorized_keys:
+  - a
+--BOUNDARY
+Content-Type: text/x-shellscript
+
+#!/bin/sh
+echo one
+--BOUNDARY
+Content-Type: text/cloud-config
+
+hostname: second
+ssh_authorized_keys:
+  - b
+--BOUNDARY
+Content-Type: text/x-shellscript
+
+#!/bin/sh
+echo two
+--BOUNDARY--
+`,
+			ud: &UserData{
+				CloudConfig: &config.CloudConfig{Hostname: "second", SSHAuthorizedKeys: []string{"a", "b"}},
+				Scripts:     []config.Script{config.Script("#!/bin/sh\necho one"), config.Script("#!/bin/sh\necho two")},
+			},
+		},
+		{
+			contents: `Content-Type: multipart/mixed; boundary="BOUNDARY"
+MIME-Version: 1.0
+
+--BOUNDARY
+Content-Type: text/cloud-config
+Content-Transfer-Encoding: base64
+
+` + gzipBase64("#cloud-config\nhostname: compressed\n") + `
+--BOUNDARY
+Content-Type: text/plain
+
+#!/bin/sh
+echo detected
+--BOUNDARY--
+`,
+			ud: &UserData{
+				CloudConfig: &config.CloudConfig{Hostname: "compressed"},
+				Scripts:     []config.Script{config.Script("#!/bin/sh\necho detected")},
+			},
+		},
+		{
+			contents: `Content-Type: multipart/mixed; boundary="BOUNDARY"
+MIME-Version: 1.0
+
+--BOUNDARY
+Content-Type: text/x-include-url
+
+# included parts
+` + server.URL + `/config
+` + server.URL + `/script
+--BOUNDARY--
+`,
+			ud: &UserData{
+				CloudConfig: &config.CloudConfig{SSHAuthorizedKeys: []string{"included"}},
+				Scripts:     []config.Script{config.Script("#!/bin/sh\necho included\n")},
+			},
+		},
+	} {
+		ud, err := ParseUserData(tt.contents)
+		if err != nil {
+			t.Errorf("bad error (#%d): want nil, got %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.ud, ud) {
+			t.Errorf("bad user-data (#%d): want %#v, got %#v", i, tt.ud, ud)
+		}
+	}
+}
+
+func TestParseMultipartIncludeLoop(t *testing.T) {
+	docs := map[string]string{
+		"http://loop": "Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/x-include-url\n\nhttp://loop\n--x--\n",
+	}
+	for i := 1; i <= config.MaxIncludeDepth+1; i++ {
+		docs[fmt.Sprintf("http://deep%d", i)] = fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"x\"\n\n--x\nContent-Type: text/x-include-url\n\nhttp://deep%d\n--x--\n", i+1)
+	}
+	docs[fmt.Sprintf("http://deep%d", config.MaxIncludeDepth+2)] = "#cloud-config\n"
+
+	fetches := 0
+	defer func(f func(string) ([]byte, error)) { FetchIncludeURL = f }(FetchIncludeURL)
+	FetchIncludeURL = func(url string) ([]byte, error) {
+		fetches++
+		return []byte(docs[url]), nil
+	}
+
+	for i, tt := range []struct {
+		contents string
+
+		fetches int
+	}{
+		{docs["http://loop"], 1},
+		{docs["http://deep1"], config.MaxIncludeDepth},
+	} {
+		fetches = 0
+		if _, err := ParseUserData(tt.contents); err == nil {
+			t.Errorf("bad error (#%d): want non-nil, got nil", i)
+		}
+		if fetches != tt.fetches {
+			t.Errorf("bad fetches (#%d): want %d, got %d", i, tt.fetches, fetches)
+		}
+	}
+}
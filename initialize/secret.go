@@ -0,0 +1,225 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Secrets are encrypted for a host's X25519 key: an ephemeral X25519 key is
+// generated, a key derived from the shared secret with HKDF-SHA256 and the
+// secret sealed with AES-256-GCM. The ciphertext is the version byte, the
+// ephemeral public key, the nonce and the sealed secret.
+const (
+	secretVersion = 1
+	secretInfo    = "coreos-cloudinit secret"
+	secretKeySize = 32
+)
+
+var ErrNoSecretKey = errors.New("secret is not encrypted for any of the host's keys")
+
+// SecretKeys are the host's private keys with which secrets are decrypted.
+type SecretKeys []*ecdh.PrivateKey
+
+// LoadSecretKeys reads the X25519 private keys in the file, or each file in
+// the directory, at p. Keys are either PEM encoded ("BEGIN PRIVATE KEY", as
+// written by `openssl genpkey -algorithm X25519`) or the base64 encoded raw
+// key.
+func LoadSecretKeys(p string) (SecretKeys, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{p}
+	if fi.IsDir() {
+		infos, err := ioutil.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		files = nil
+		for _, i := range infos {
+			if !i.IsDir() && !strings.HasPrefix(i.Name(), ".") {
+				files = append(files, path.Join(p, i.Name()))
+			}
+		}
+	}
+
+	var keys SecretKeys
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseSecretKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret key %s: %v", f, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no secret keys found in %s", p)
+	}
+	return keys, nil
+}
+
+func parseSecretKey(data []byte) (*ecdh.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := priv.(*ecdh.PrivateKey)
+		if !ok || key.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("unsupported key type %T", priv)
+		}
+		return key, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(raw)
+}
+
+// ParseSecretPublicKey parses an X25519 public key, either PEM encoded
+// ("BEGIN PUBLIC KEY") or the base64 encoded raw key.
+func ParseSecretPublicKey(data []byte) (*ecdh.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := pub.(*ecdh.PublicKey)
+		if !ok || key.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("unsupported key type %T", pub)
+		}
+		return key, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+// EncryptSecret encrypts plaintext for the host with the given public key.
+func EncryptSecret(pub *ecdh.PublicKey, plaintext []byte) ([]byte, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := secretAEAD(eph, pub)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{secretVersion}, eph.PublicKey().Bytes()...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, nil), nil
+}
+
+// DecryptRedacted checks that a secret can be decrypted, like Decrypt, but
+// returns a placeholder of the form "<redacted N bytes>" instead of the
+// plaintext. It is used for dry runs, which print what would be written.
+func (keys SecretKeys) DecryptRedacted(ciphertext []byte) ([]byte, error) {
+	plaintext, err := keys.Decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("<redacted %d bytes>", len(plaintext))), nil
+}
+
+// Decrypt decrypts a secret encrypted for any of the keys.
+func (keys SecretKeys) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 1+secretKeySize || ciphertext[0] != secretVersion {
+		return nil, errors.New("malformed secret")
+	}
+	eph, err := ecdh.X25519().NewPublicKey(ciphertext[1 : 1+secretKeySize])
+	if err != nil {
+		return nil, err
+	}
+	ciphertext = ciphertext[1+secretKeySize:]
+
+	for _, key := range keys {
+		aead, err := secretAEAD(key, eph)
+		if err != nil {
+			return nil, err
+		}
+		if len(ciphertext) < aead.NonceSize() {
+			return nil, errors.New("malformed secret")
+		}
+		nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrNoSecretKey
+}
+
+// secretAEAD returns the cipher for the secret shared by priv and pub. The
+// key derivation is bound to both the ephemeral and the host's public key.
+func secretAEAD(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	a, b := priv.PublicKey().Bytes(), pub.Bytes()
+	if string(a) > string(b) {
+		a, b = b, a
+	}
+	key := hkdfSHA256(shared, append(append([]byte{}, a...), b...), secretInfo, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdfSHA256 derives a key of the given length (at most 255*32 bytes) from
+// secret with HKDF-SHA256 (RFC 5869).
+func hkdfSHA256(secret, salt []byte, info string, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+
+	var key, block []byte
+	for i := byte(1); len(key) < length; i++ {
+		expand.Reset()
+		expand.Write(block)
+		expand.Write([]byte(info))
+		expand.Write([]byte{i})
+		block = expand.Sum(nil)
+		key = append(key, block...)
+	}
+	return key[:length]
+}
@@ -0,0 +1,190 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+)
+
+// signatureHeader starts the first line of user-data which carries its own
+// signature: "#signature: ed25519 <base64 signature>". The signature covers
+// the rest of the user-data, after that line.
+const signatureHeader = "#signature:"
+
+var (
+	ErrUnsigned     = errors.New("user-data is not signed")
+	ErrBadSignature = errors.New("user-data signature does not match any trusted key")
+)
+
+// TrustedKeys are the public keys with which user-data must be signed.
+type TrustedKeys []ed25519.PublicKey
+
+// LoadTrustedKeys reads the Ed25519 public keys in dir. Each file holds one
+// key, either PEM encoded ("BEGIN PUBLIC KEY"), in the OpenSSH authorized_keys
+// format ("ssh-ed25519 AAAA...") or as the base64 encoded raw key.
+func LoadTrustedKeys(dir string) (TrustedKeys, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys TrustedKeys
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(data, []byte("BEGIN PGP PUBLIC KEY BLOCK")) {
+			log.Printf("Ignoring trusted key %s: OpenPGP keys are not supported", f.Name())
+			continue
+		}
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key %s: %v", f.Name(), err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no trusted keys found in %s", dir)
+	}
+	return keys, nil
+}
+
+func parsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", pub)
+		}
+		return key, nil
+	}
+
+	fields := strings.Fields(string(data))
+	switch {
+	case len(fields) >= 2 && fields[0] == "ssh-ed25519":
+		return parseSSHPublicKey(fields[1])
+	case len(fields) == 1:
+		raw, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad key length %d", len(raw))
+		}
+		return ed25519.PublicKey(raw), nil
+	default:
+		return nil, errors.New("unrecognized key format")
+	}
+}
+
+// parseSSHPublicKey parses the base64 encoded wire format of an OpenSSH
+// Ed25519 public key: the string "ssh-ed25519" followed by the key, each
+// prefixed with its length.
+func parseSSHPublicKey(encoded string) (ed25519.PublicKey, error) {
+	wire, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var fields [][]byte
+	for len(wire) > 0 {
+		if len(wire) < 4 {
+			return nil, errors.New("truncated ssh key")
+		}
+		n := binary.BigEndian.Uint32(wire)
+		if uint64(len(wire)-4) < uint64(n) {
+			return nil, errors.New("truncated ssh key")
+		}
+		fields = append(fields, wire[4:4+n])
+		wire = wire[4+n:]
+	}
+	if len(fields) != 2 || string(fields[0]) != "ssh-ed25519" || len(fields[1]) != ed25519.PublicKeySize {
+		return nil, errors.New("not an ssh-ed25519 key")
+	}
+	return ed25519.PublicKey(fields[1]), nil
+}
+
+// IsSigned returns whether the user-data carries its own signature.
+func IsSigned(userdata []byte) bool {
+	return bytes.HasPrefix(userdata, []byte(signatureHeader))
+}
+
+// Verify checks that userdata was signed by one of the keys, using the
+// detached signature if one is given and otherwise the signature carried by
+// the user-data itself. It returns the user-data without its signature.
+// Empty user-data needs no signature.
+func (keys TrustedKeys) Verify(userdata, detached []byte) ([]byte, error) {
+	if len(userdata) == 0 {
+		return userdata, nil
+	}
+	if len(detached) > 0 {
+		return userdata, keys.verify(userdata, detached)
+	}
+	if !IsSigned(userdata) {
+		return nil, ErrUnsigned
+	}
+
+	parts := bytes.SplitN(userdata, []byte("\n"), 2)
+	fields := strings.Fields(strings.TrimPrefix(string(parts[0]), signatureHeader))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("malformed signature line %q", parts[0])
+	}
+	if fields[0] != "ed25519" {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", fields[0])
+	}
+	var payload []byte
+	if len(parts) == 2 {
+		payload = parts[1]
+	}
+	return payload, keys.verify(payload, []byte(fields[1]))
+}
+
+// verify checks payload against a signature which is either raw or base64
+// encoded.
+func (keys TrustedKeys) verify(payload, sig []byte) error {
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return fmt.Errorf("malformed signature: %v", err)
+		}
+		sig = decoded
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature: bad length %d", len(sig))
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, payload, sig) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
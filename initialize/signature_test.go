@@ -0,0 +1,179 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func testKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func sshPublicKey(key ed25519.PublicKey) string {
+	var wire []byte
+	for _, f := range [][]byte{[]byte("ssh-ed25519"), key} {
+		n := make([]byte, 4)
+		binary.BigEndian.PutUint32(n, uint32(len(f)))
+		wire = append(append(wire, n...), f...)
+	}
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(wire) + " user@host\n"
+}
+
+func TestLoadTrustedKeys(t *testing.T) {
+	pub1 := testKey(1).Public().(ed25519.PublicKey)
+	pub2 := testKey(2).Public().(ed25519.PublicKey)
+	pub3 := testKey(3).Public().(ed25519.PublicKey)
+	der, err := x509.MarshalPKIXPublicKey(pub1)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %v", err)
+	}
+
+	for i, tt := range []struct {
+		files map[string]string
+
+		keys TrustedKeys
+		err  bool
+	}{
+		{
+			files: map[string]string{
+				"a.pem":   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+				"b.pub":   sshPublicKey(pub2),
+				"c.key":   base64.StdEncoding.EncodeToString(pub3) + "\n",
+				"d.asc":   "-----BEGIN PGP PUBLIC KEY BLOCK-----\n",
+				".hidden": "garbage",
+			},
+			keys: TrustedKeys{pub1, pub2, pub3},
+		},
+		{
+			files: map[string]string{"a.key": "garbage"},
+			err:   true,
+		},
+		{
+			files: map[string]string{"a.key": base64.StdEncoding.EncodeToString([]byte("short"))},
+			err:   true,
+		},
+		{
+			files: map[string]string{"a.pub": "ssh-rsa AAAAB3NzaC1yc2E= user@host"},
+			err:   true,
+		},
+		{
+			files: map[string]string{},
+			err:   true,
+		},
+	} {
+		dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+		if err != nil {
+			t.Fatalf("unable to create tempdir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		for name, contents := range tt.files {
+			if err := ioutil.WriteFile(path.Join(dir, name), []byte(contents), 0644); err != nil {
+				t.Fatalf("unable to write key: %v", err)
+			}
+		}
+
+		keys, err := LoadTrustedKeys(dir)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if !reflect.DeepEqual(tt.keys, keys) {
+			t.Errorf("bad keys (#%d): want %v, got %v", i, tt.keys, keys)
+		}
+	}
+}
+
+func TestTrustedKeysVerify(t *testing.T) {
+	trusted, untrusted := testKey(1), testKey(2)
+	keys := TrustedKeys{testKey(3).Public().(ed25519.PublicKey), trusted.Public().(ed25519.PublicKey)}
+
+	userdata := []byte("#cloud-config\nhostname: foo\n")
+	sign := func(key ed25519.PrivateKey) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, userdata))
+	}
+
+	for i, tt := range []struct {
+		userdata string
+		detached string
+
+		out string
+		err error
+	}{
+		{
+			userdata: "",
+			out:      "",
+		},
+		{
+			userdata: string(userdata),
+			detached: sign(trusted) + "\n",
+			out:      string(userdata),
+		},
+		{
+			userdata: string(userdata),
+			detached: string(ed25519.Sign(trusted, userdata)),
+			out:      string(userdata),
+		},
+		{
+			userdata: "#signature: ed25519 " + sign(trusted) + "\n" + string(userdata),
+			out:      string(userdata),
+		},
+		{
+			userdata: string(userdata),
+			detached: sign(untrusted),
+			err:      ErrBadSignature,
+		},
+		{
+			userdata: "#signature: ed25519 " + sign(untrusted) + "\n" + string(userdata),
+			err:      ErrBadSignature,
+		},
+		{
+			userdata: "#signature: ed25519 " + sign(trusted) + "\n" + string(userdata) + "extra: true\n",
+			err:      ErrBadSignature,
+		},
+		{
+			userdata: string(userdata),
+			err:      ErrUnsigned,
+		},
+	} {
+		out, err := keys.Verify([]byte(tt.userdata), []byte(tt.detached))
+		if !reflect.DeepEqual(tt.err, err) {
+			t.Errorf("bad error (#%d): want %v, got %v", i, tt.err, err)
+		}
+		if tt.err == nil && string(out) != tt.out {
+			t.Errorf("bad user-data (#%d): want %q, got %q", i, tt.out, out)
+		}
+	}
+
+	for i, userdata := range []string{
+		"#signature: ed25519\n#cloud-config\n",
+		"#signature: rsa " + sign(trusted) + "\n#cloud-config\n",
+		"#signature: ed25519 !!!\n#cloud-config\n",
+		"#signature: ed25519 " + base64.StdEncoding.EncodeToString([]byte("short")) + "\n#cloud-config\n",
+	} {
+		if _, err := keys.Verify([]byte(userdata), nil); err == nil {
+			t.Errorf("bad error (#%d): want non-nil, got nil", i)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/system"
+)
+
+const stateFilename = "applied.json"
+
+var bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// State records what has been applied to the system: the instance-id of the
+// datasource, digests of the user-data and meta-data that were fetched and of
+// the inputs of every module of Apply. Modules whose inputs are unchanged
+// since the last run are skipped.
+type State struct {
+	InstanceID string            `json:"instance_id"`
+	Userdata   string            `json:"userdata"`
+	Metadata   string            `json:"metadata"`
+	Modules    map[string]string `json:"modules"`
+
+	bootID           string
+	newInstance      bool
+	replacedInstance bool
+}
+
+// NewState returns an empty State, with which every module is applied.
+func NewState() *State {
+	return &State{Modules: map[string]string{}, bootID: readBootID(), newInstance: true}
+}
+
+// LoadState reads the State persisted in the workspace. If there is none, an
+// empty State is returned.
+func LoadState(workspace string) (*State, error) {
+	s := NewState()
+	b, err := ioutil.ReadFile(path.Join(workspace, stateFilename))
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	s.newInstance = false
+	if s.Modules == nil {
+		s.Modules = map[string]string{}
+	}
+	return s, nil
+}
+
+// Save persists the State in the workspace.
+func (s *State) Save(workspace string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = system.WriteFile(&system.File{File: config.File{
+		Path:               stateFilename,
+		RawFilePermissions: "0600",
+		Content:            string(b) + "\n",
+	}}, workspace)
+	return err
+}
+
+// SetInstanceID records the instance-id reported by the datasource. If it
+// differs from the recorded one, this is the first run on a new instance (for
+// example a machine booted from a copy of another one's disk) and nothing
+// recorded for the previous instance applies any more. Datasources without
+// an instance-id (e.g. a file or URL) report an empty one, which leaves the
+// recorded instance as it is.
+func (s *State) SetInstanceID(id string) {
+	if id == "" {
+		return
+	}
+	if s.InstanceID != id {
+		s.newInstance = true
+		s.replacedInstance = s.InstanceID != ""
+		s.Modules = map[string]string{}
+	}
+	s.InstanceID = id
+}
+
+// NewInstance reports whether this is the first run on the instance, in which
+// case items which should only be applied once per instance are applied. A
+// nil State is always a new instance.
+func (s *State) NewInstance() bool {
+	return s == nil || s.newInstance
+}
+
+// SetInputs records the user-data and meta-data fetched from the datasource
+// and reports whether they differ from those of the last run.
+func (s *State) SetInputs(userdata []byte, metadata datasource.Metadata) bool {
+	ud, md := digest(userdata), digest(metadata)
+	changed := ud != s.Userdata || md != s.Metadata
+	s.Userdata, s.Metadata = ud, md
+	return changed
+}
+
+// apply runs fn unless inputs are the same as when the module was last
+// applied. The effects of volatile modules (e.g. files in /run) do not
+// survive a reboot, so they are only skipped within the same boot. A nil
+// State applies every module.
+func (s *State) apply(module string, volatile bool, inputs interface{}, fn func() error) error {
+	d, unchanged := s.unchanged(module, volatile, inputs)
+	if unchanged {
+		log.Printf("Skipping %s, unchanged since the last run", module)
+		return nil
+	}
+
+	if err := fn(); err != nil {
+		s.forget(module)
+		return err
+	}
+	s.record(module, d)
+	return nil
+}
+
+// unchanged reports whether inputs are the same as when the module was last
+// applied, and returns the digest to record once it has been applied again.
+func (s *State) unchanged(module string, volatile bool, inputs interface{}) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	d := digest(inputs)
+	if d == "" {
+		return "", false
+	}
+	if volatile {
+		d = digest([]string{d, s.bootID})
+	}
+	return d, s.Modules[module] == d
+}
+
+// record records the digest of the inputs with which the module was applied.
+func (s *State) record(module, d string) {
+	if s == nil || d == "" {
+		return
+	}
+	s.Modules[module] = d
+}
+
+// forget makes the module be applied on the next run.
+func (s *State) forget(module string) {
+	if s == nil {
+		return
+	}
+	delete(s.Modules, module)
+}
+
+func digest(v interface{}) string {
+	var b []byte
+	switch v := v.(type) {
+	case []byte:
+		b = v
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return ""
+		}
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func readBootID() string {
+	b, _ := ioutil.ReadFile(bootIDPath)
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,282 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/datasource"
+	"github.com/coreos/coreos-cloudinit/system"
+)
+
+func TestStateApply(t *testing.T) {
+	s := &State{Modules: map[string]string{}, bootID: "boot1"}
+
+	runs := 0
+	run := func() error { runs++; return nil }
+	fail := func() error { runs++; return errors.New("failed") }
+
+	for i, tt := range []struct {
+		module   string
+		volatile bool
+		inputs   interface{}
+		bootID   string
+		fn       func() error
+
+		runs int
+		err  error
+	}{
+		{module: "a", inputs: "x", bootID: "boot1", fn: run, runs: 1},
+		{module: "a", inputs: "x", bootID: "boot1", fn: run, runs: 0},
+		{module: "a", inputs: "y", bootID: "boot1", fn: run, runs: 1},
+		{module: "a", inputs: "y", bootID: "boot2", fn: run, runs: 0},
+		{module: "b", volatile: true, inputs: "x", bootID: "boot1", fn: run, runs: 1},
+		{module: "b", volatile: true, inputs: "x", bootID: "boot1", fn: run, runs: 0},
+		{module: "b", volatile: true, inputs: "x", bootID: "boot2", fn: run, runs: 1},
+		{module: "c", inputs: "x", bootID: "boot2", fn: fail, runs: 1, err: errors.New("failed")},
+		{module: "c", inputs: "x", bootID: "boot2", fn: run, runs: 1},
+	} {
+		runs = 0
+		s.bootID = tt.bootID
+		err := s.apply(tt.module, tt.volatile, tt.inputs, tt.fn)
+		if !reflect.DeepEqual(tt.err, err) {
+			t.Errorf("bad error (#%d): want %v, got %v", i, tt.err, err)
+		}
+		if runs != tt.runs {
+			t.Errorf("bad runs (#%d): want %d, got %d", i, tt.runs, runs)
+		}
+	}
+
+	runs = 0
+	if err := (*State)(nil).apply("a", false, "x", run); err != nil || runs != 1 {
+		t.Errorf("bad nil state: want 1 run, got %d (%v)", runs, err)
+	}
+}
+
+func TestStateSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := LoadState(dir)
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if !s.SetInputs([]byte("#cloud-config\n"), datasource.Metadata{Hostname: "host"}) {
+		t.Errorf("bad changed: want true, got false")
+	}
+	s.Modules["hostname"] = "digest"
+	if err := s.Save(dir); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+
+	l, err := LoadState(dir)
+	if err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if !reflect.DeepEqual(s.Modules, l.Modules) || s.Userdata != l.Userdata || s.Metadata != l.Metadata {
+		t.Errorf("bad state: want %#v, got %#v", s, l)
+	}
+	if l.SetInputs([]byte("#cloud-config\n"), datasource.Metadata{Hostname: "host"}) {
+		t.Errorf("bad changed: want false, got true")
+	}
+	if !l.SetInputs([]byte("#cloud-config\n"), datasource.Metadata{Hostname: "other"}) {
+		t.Errorf("bad changed: want true, got false")
+	}
+}
+
+func TestApplySkipsUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := NewEnvironment(dir, "", "", "", datasource.Metadata{})
+	state := NewState()
+	for i, tt := range []struct {
+		content string
+
+		changes int
+	}{
+		{"a\n", 1},
+		{"a\n", 0},
+		{"b\n", 1},
+	} {
+		cfg := config.CloudConfig{WriteFiles: []config.File{{Path: "/etc/foo", Content: tt.content}}}
+		plan := system.NewPlan(dir)
+		if err := Apply(cfg, nil, env, plan, state); err != nil {
+			t.Fatalf("bad error (#%d): want nil, got %v", i, err)
+		}
+		if len(plan.Changes) != tt.changes {
+			t.Errorf("bad changes (#%d): want %d, got %d", i, tt.changes, len(plan.Changes))
+		}
+	}
+}
+
+func TestStateSetInstanceID(t *testing.T) {
+	for i, tt := range []struct {
+		state *State
+		id    string
+
+		newInstance      bool
+		replacedInstance bool
+		modules          map[string]string
+	}{
+		{
+			state:       NewState(),
+			id:          "i-1",
+			newInstance: true,
+			modules:     map[string]string{},
+		},
+		{
+			state:   &State{InstanceID: "i-1", Modules: map[string]string{"a": "x"}},
+			id:      "i-1",
+			modules: map[string]string{"a": "x"},
+		},
+		{
+			state:   &State{Modules: map[string]string{"a": "x"}},
+			id:      "",
+			modules: map[string]string{"a": "x"},
+		},
+		{
+			state:   &State{InstanceID: "i-1", Modules: map[string]string{"a": "x"}},
+			id:      "",
+			modules: map[string]string{"a": "x"},
+		},
+		{
+			state:            &State{InstanceID: "i-1", Modules: map[string]string{"a": "x"}},
+			id:               "i-2",
+			newInstance:      true,
+			replacedInstance: true,
+			modules:          map[string]string{},
+		},
+		{
+			state:       &State{Modules: map[string]string{"a": "x"}},
+			id:          "i-2",
+			newInstance: true,
+			modules:     map[string]string{},
+		},
+	} {
+		tt.state.SetInstanceID(tt.id)
+		if tt.state.NewInstance() != tt.newInstance {
+			t.Errorf("bad new instance (#%d): want %t, got %t", i, tt.newInstance, tt.state.NewInstance())
+		}
+		if tt.state.replacedInstance != tt.replacedInstance {
+			t.Errorf("bad replaced instance (#%d): want %t, got %t", i, tt.replacedInstance, tt.state.replacedInstance)
+		}
+		if !reflect.DeepEqual(tt.modules, tt.state.Modules) {
+			t.Errorf("bad modules (#%d): want %v, got %v", i, tt.modules, tt.state.Modules)
+		}
+	}
+}
+
+func TestApplyOncePerInstance(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := NewEnvironment(dir, "", "", "", datasource.Metadata{})
+	state := NewState()
+	for i, tt := range []struct {
+		id      string
+		content string
+
+		changes []string
+	}{
+		{"i-1", "a\n", []string{"write " + dir + "/etc/always", "write " + dir + "/etc/once"}},
+		{"i-1", "b\n", []string{"write " + dir + "/etc/always"}},
+		{"i-2", "b\n", []string{"regenerate " + dir + "/etc/ssh/ssh_host_*key*", "regenerate " + dir + "/etc/machine-id", "write " + dir + "/etc/always", "write " + dir + "/etc/once"}},
+		// Runs without an instance-id (e.g. -from-file) sharing the
+		// workspace don't make the next run a new instance
+		{"", "b\n", nil},
+		{"i-2", "b\n", nil},
+		{"", "c\n", []string{"write " + dir + "/etc/always"}},
+		{"i-2", "c\n", nil},
+	} {
+		cfg := config.CloudConfig{WriteFiles: []config.File{
+			{Path: "/etc/always", Content: tt.content},
+			{Path: "/etc/once", Content: tt.content, Frequency: config.FrequencyOncePerInstance},
+		}}
+		plan := system.NewPlan(dir)
+		state.SetInstanceID(tt.id)
+		if err := Apply(cfg, nil, env, plan, state); err != nil {
+			t.Fatalf("bad error (#%d): want nil, got %v", i, err)
+		}
+		var changes []string
+		for _, c := range plan.Changes {
+			changes = append(changes, c.Action+" "+c.Target)
+		}
+		if !reflect.DeepEqual(tt.changes, changes) {
+			t.Errorf("bad changes (#%d): want %q, got %q", i, tt.changes, changes)
+		}
+		state.newInstance = false
+		state.replacedInstance = false
+	}
+}
+
+func TestApplyRuntimeUnitsAfterReboot(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := NewEnvironment(dir, "", "", "", datasource.Metadata{})
+	cfg := config.CloudConfig{CoreOS: config.CoreOS{
+		Etcd2: config.Etcd2{Name: "node1"},
+		Units: []config.Unit{{Name: "foo.service", Command: "start", Content: "[Service]\nExecStart=/bin/true\n"}},
+	}}
+	state := NewState()
+	for i, tt := range []struct {
+		bootID string
+
+		foo  bool
+		etcd bool
+	}{
+		{bootID: "boot-1", foo: true, etcd: true},
+		{bootID: "boot-1"},
+		// The etcd2 drop-in in /run is gone after a reboot, foo.service
+		// is not
+		{bootID: "boot-2", etcd: true},
+	} {
+		state.bootID = tt.bootID
+		plan := system.NewPlan(dir)
+		if err := Apply(cfg, nil, env, plan, state); err != nil {
+			t.Fatalf("bad error (#%d): want nil, got %v", i, err)
+		}
+		var foo, etcd bool
+		for _, c := range plan.Changes {
+			foo = foo || c.Target == "foo.service" || strings.HasSuffix(c.Target, "/foo.service")
+			etcd = etcd || strings.Contains(c.Target, "etcd2.service")
+		}
+		if foo != tt.foo {
+			t.Errorf("bad foo.service changes (#%d): want %t, got %t (%q)", i, tt.foo, foo, plan.Changes)
+		}
+		if etcd != tt.etcd {
+			t.Errorf("bad etcd2.service changes (#%d): want %t, got %t (%q)", i, tt.etcd, etcd, plan.Changes)
+		}
+	}
+}
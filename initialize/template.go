@@ -0,0 +1,170 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// templateHeader is the first line of user-data which is to be rendered as a
+// Go text/template before being processed.
+const templateHeader = "## template: go"
+
+// IsTemplate returns whether the user-data is a Go template.
+func IsTemplate(userdata string) bool {
+	header := strings.SplitN(userdata, "\n", 2)[0]
+	return strings.TrimRightFunc(header, unicode.IsSpace) == templateHeader
+}
+
+// TemplateData is the data against which templated user-data is rendered.
+type TemplateData struct {
+	Metadata
+	NetworkConfig interface{}
+}
+
+var templateFuncs = template.FuncMap{
+	"default":     templateDefault,
+	"join":        templateJoin,
+	"base64":      func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"cidrhost":    cidrHost,
+	"cidrnetmask": cidrNetmask,
+}
+
+// Render renders templated user-data (see IsTemplate) against the metadata
+// and then applies the substitutions supported by Apply. Any other user-data
+// only has the substitutions applied.
+func (e *Environment) Render(data string) (string, error) {
+	if !IsTemplate(data) {
+		return e.Apply(data), nil
+	}
+
+	tmpl, err := template.New("user-data").Option("missingkey=zero").Funcs(templateFuncs).Parse(strings.SplitN(data, "\n", 2)[1])
+	if err != nil {
+		return "", err
+	}
+	td, err := e.templateData()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, td); err != nil {
+		return "", err
+	}
+	return e.Apply(buf.String()), nil
+}
+
+func (e *Environment) templateData() (TemplateData, error) {
+	md, err := e.Metadata()
+	if err != nil {
+		return TemplateData{}, err
+	}
+	return TemplateData{Metadata: md, NetworkConfig: e.metadata.NetworkConfig}, nil
+}
+
+// templateDefault returns value, unless it is empty, in which case it returns
+// def. It is meant to be used in a pipeline: {{.Region | default "local"}}.
+func templateDefault(def, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	}
+	return value
+}
+
+// templateJoin joins the elements of list, which may be a slice or a map (in
+// which case its values, ordered by key, are joined), with sep.
+func templateJoin(sep string, list interface{}) (string, error) {
+	v := reflect.ValueOf(list)
+	var elems []string
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			elems = append(elems, fmt.Sprint(v.Index(i).Interface()))
+		}
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		byKey := make(map[string]string, v.Len())
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			byKey[key] = fmt.Sprint(v.MapIndex(k).Interface())
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			elems = append(elems, byKey[k])
+		}
+	default:
+		return "", fmt.Errorf("cannot join %T", list)
+	}
+	return strings.Join(elems, sep), nil
+}
+
+// cidrHost returns the address of the host with the given number within the
+// network prefix, e.g. cidrhost "10.0.0.0/24" 5 is "10.0.0.5". Negative
+// numbers count back from the end of the network.
+func cidrHost(prefix string, num int) (string, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", err
+	}
+	ones, bits := network.Mask.Size()
+	size := uint(bits - ones)
+	n := uint64(num)
+	if num < 0 {
+		if size >= 63 || int64(1)<<size+int64(num) < 0 {
+			return "", fmt.Errorf("prefix %s has no host %d", prefix, num)
+		}
+		n = uint64(int64(1)<<size + int64(num))
+	}
+	if size < 64 && n >= uint64(1)<<size {
+		return "", fmt.Errorf("prefix %s has no host %d", prefix, num)
+	}
+
+	ip := make(net.IP, len(network.IP))
+	copy(ip, network.IP)
+	for i := len(ip) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(ip[i]) + n&0xff
+		ip[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	return ip.String(), nil
+}
+
+// cidrNetmask returns the netmask of an IPv4 network prefix in dotted
+// decimal notation, e.g. cidrnetmask "10.0.0.0/24" is "255.255.255.0".
+func cidrNetmask(prefix string) (string, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", err
+	}
+	if len(network.Mask) != net.IPv4len {
+		return "", fmt.Errorf("prefix %s is not an IPv4 network", prefix)
+	}
+	return net.IP(network.Mask).String(), nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package initialize
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/datasource"
+)
+
+func TestIsTemplate(t *testing.T) {
+	for i, tt := range []struct {
+		userdata string
+
+		template bool
+	}{
+		{"## template: go\n#cloud-config\n", true},
+		{"## template: go \r\n#cloud-config\r\n", true},
+		{"## template: jinja\n#cloud-config\n", false},
+		{"#cloud-config\n## template: go\n", false},
+		{"", false},
+	} {
+		if template := IsTemplate(tt.userdata); template != tt.template {
+			t.Errorf("bad template (#%d): want %t, got %t", i, tt.template, template)
+		}
+	}
+}
+
+func TestEnvironmentRender(t *testing.T) {
+	defer func(f func() (map[string][]string, error)) { interfaceAddrs = f }(interfaceAddrs)
+	interfaceAddrs = func() (map[string][]string, error) {
+		return map[string][]string{"eth0": {"10.0.0.5/24", "fe80::1/64"}}, nil
+	}
+
+	env := NewEnvironment("/", "", "", "", datasource.Metadata{
+		InstanceID:    "i-1",
+		Hostname:      "host",
+		Region:        "region",
+		PublicIPv4:    net.ParseIP("192.0.2.3"),
+		SSHPublicKeys: map[string]string{"b": "key-b", "a": "key-a"},
+	})
+	env.SetDatasourceType("ec2-metadata-service")
+
+	for i, tt := range []struct {
+		input string
+
+		out string
+		err bool
+	}{
+		{
+			input: "#cloud-config\nhostname: {{.Hostname}}-$public_ipv4\n",
+			out:   "#cloud-config\nhostname: {{.Hostname}}-192.0.2.3\n",
+		},
+		{
+			input: "## template: go\n#cloud-config\nhostname: {{.Hostname}}-{{.InstanceID}}\n",
+			out:   "#cloud-config\nhostname: host-i-1\n",
+		},
+		{
+			input: "## template: go\n{{.Datasource}} {{.Region}} {{.Zone | default \"zone\"}} {{.Region | default \"other\"}}",
+			out:   "ec2-metadata-service region zone region",
+		},
+		{
+			input: "## template: go\n{{.PublicIPv4}} $public_ipv4 {{.PrivateIPv4 | default \"none\"}}",
+			out:   "192.0.2.3 192.0.2.3 none",
+		},
+		{
+			input: "## template: go\n{{join \",\" .SSHPublicKeys}} {{join \" \" (index .Interfaces \"eth0\")}}",
+			out:   "key-a,key-b 10.0.0.5/24 fe80::1/64",
+		},
+		{
+			input: "## template: go\n{{base64 .Hostname}} {{cidrhost \"10.0.0.0/24\" 5}} {{cidrhost \"10.0.0.0/24\" -2}} {{cidrnetmask \"10.0.0.0/22\"}}",
+			out:   "aG9zdA== 10.0.0.5 10.0.0.254 255.255.252.0",
+		},
+		{
+			input: "## template: go\n{{.Hostname",
+			err:   true,
+		},
+		{
+			input: "## template: go\n{{cidrhost \"10.0.0.0/24\" 256}}",
+			err:   true,
+		},
+	} {
+		out, err := env.Render(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if out != tt.out {
+			t.Errorf("bad output (#%d): want %q, got %q", i, tt.out, out)
+		}
+	}
+
+	interfaceAddrs = func() (map[string][]string, error) { return nil, errors.New("no interfaces") }
+	if _, err := env.Render("## template: go\n"); err == nil {
+		t.Errorf("bad error: want non-nil, got nil")
+	}
+}
+
+func TestCidrHost(t *testing.T) {
+	for i, tt := range []struct {
+		prefix string
+		num    int
+
+		host string
+		err  bool
+	}{
+		{prefix: "10.0.0.0/24", num: 0, host: "10.0.0.0"},
+		{prefix: "10.0.0.7/24", num: 1, host: "10.0.0.1"},
+		{prefix: "10.0.0.0/16", num: 258, host: "10.0.1.2"},
+		{prefix: "10.0.0.0/24", num: -1, host: "10.0.0.255"},
+		{prefix: "10.0.0.255/31", num: 1, host: "10.0.0.255"},
+		{prefix: "fd00::/64", num: 17, host: "fd00::11"},
+		{prefix: "fd00::/120", num: -1, host: "fd00::ff"},
+		{prefix: "10.0.0.0/24", num: 256, err: true},
+		{prefix: "10.0.0.0/24", num: -257, err: true},
+		{prefix: "10.0.0.0", num: 1, err: true},
+	} {
+		host, err := cidrHost(tt.prefix, tt.num)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if host != tt.host {
+			t.Errorf("bad host (#%d): want %q, got %q", i, tt.host, host)
+		}
+	}
+}
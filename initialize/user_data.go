@@ -36,19 +36,26 @@ func ParseUserData(contents string) (interface{}, error) {
 		return config.NewScript(contents)
 	case config.IsCloudConfig(contents):
 		log.Printf("Parsing user-data as cloud-config")
-		cc, err := config.NewCloudConfig(contents)
-		if err != nil {
-			return nil, err
-		}
-
-		if err := cc.Decode(); err != nil {
-			return nil, err
-		}
-
-		return cc, nil
+		return parseCloudConfig(contents)
+	case config.IsMultipart(contents):
+		log.Printf("Parsing user-data as MIME multipart")
+		return parseMultipart(contents)
 	case config.IsIgnitionConfig(contents):
 		return nil, ErrIgnitionConfig
 	default:
 		return nil, errors.New("Unrecognized user-data format")
 	}
 }
+
+func parseCloudConfig(contents string) (*config.CloudConfig, error) {
+	cc, err := config.NewCloudConfig(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cc.Decode(); err != nil {
+		return nil, err
+	}
+
+	return cc, nil
+}
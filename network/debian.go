@@ -34,6 +34,7 @@ func ProcessDebianNetconf(config []byte) ([]InterfaceGenerator, error) {
 			interfaces = append(interfaces, s)
 		}
 	}
+	interfaces = mergeInterfaceStanzas(interfaces)
 	log.Printf("Parsed %d network interfaces\n", len(interfaces))
 
 	log.Println("Processed Debian network config")
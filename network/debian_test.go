@@ -15,15 +15,16 @@
 package network
 
 import (
+	"strings"
 	"testing"
 )
 
 func TestFormatConfigs(t *testing.T) {
 	for in, n := range map[string]int{
-		"":                                                    0,
-		"line1\\\nis long":                                    1,
-		"#comment":                                            0,
-		"#comment\\\ncomment":                                 0,
+		"":                    0,
+		"line1\\\nis long":    1,
+		"#comment":            0,
+		"#comment\\\ncomment": 0,
 		"  #comment \\\n comment\nline 1\nline 2\\\n is long": 2,
 	} {
 		lines := formatConfig(in)
@@ -50,7 +51,25 @@ func TestProcessDebianNetconf(t *testing.T) {
 			t.Fatalf("bad failure state for %q: got %t, want %t", tt.in, failed, tt.fail)
 		}
 		if tt.n != -1 && tt.n != len(interfaces) {
-			t.Fatalf("bad number of interfaces for %q: got %d, want %q", tt.in, len(interfaces), tt.n)
+			t.Fatalf("bad number of interfaces for %q: got %d, want %d", tt.in, len(interfaces), tt.n)
+		}
+	}
+}
+
+func TestProcessDebianNetconfAcceptRA(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		acceptRA string
+	}{
+		{"iface eth0 inet6 static\n address 2001:db8::2/64\n", "IPv6AcceptRA=true\n"},
+		{"iface eth0 inet6 static\n address 2001:db8::2/64\n gateway 2001:db8::1\n", "IPv6AcceptRA=false\n"},
+	} {
+		interfaces, err := ProcessDebianNetconf([]byte(tt.in))
+		if err != nil || len(interfaces) != 1 {
+			t.Fatalf("bad interfaces for %q: got %d (%v), want 1", tt.in, len(interfaces), err)
+		}
+		if network := interfaces[0].Network(); !strings.Contains(network, tt.acceptRA) {
+			t.Fatalf("bad network for %q: got %q, want %q", tt.in, network, tt.acceptRA)
 		}
 	}
 }
@@ -39,11 +39,13 @@ type networkInterface interface {
 }
 
 type logicalInterface struct {
-	name        string
-	hwaddr      net.HardwareAddr
-	config      configMethod
-	children    []networkInterface
-	configDepth int
+	name         string
+	hwaddr       net.HardwareAddr
+	config       configMethod
+	config6      configMethod
+	ipv6AcceptRA string
+	children     []networkInterface
+	configDepth  int
 }
 
 func (i *logicalInterface) Name() string {
@@ -66,30 +68,66 @@ func (i *logicalInterface) Network() string {
 			config += fmt.Sprintf("VLAN=%s\n", iface.name)
 		case *bondInterface:
 			config += fmt.Sprintf("Bond=%s\n", iface.name)
+		case *bridgeInterface:
+			config += fmt.Sprintf("Bridge=%s\n", iface.name)
 		}
 	}
 
-	switch conf := i.config.(type) {
-	case configMethodStatic:
-		if len(conf.domains) > 0 {
-			config += fmt.Sprintf("Domains=%s\n", strings.Join(conf.domains, " "))
-		}
-		for _, nameserver := range conf.nameservers {
-			config += fmt.Sprintf("DNS=%s\n", nameserver)
-		}
-		for _, addr := range conf.addresses {
-			config += fmt.Sprintf("\n[Address]\nAddress=%s\n", addr.String())
+	var domains []string
+	var nameservers []net.IP
+	var addresses []net.IPNet
+	var routes []route
+	for _, c := range []configMethod{i.config, i.config6} {
+		if conf, ok := c.(configMethodStatic); ok {
+			domains = append(domains, conf.domains...)
+			nameservers = append(nameservers, conf.nameservers...)
+			addresses = append(addresses, conf.addresses...)
+			routes = append(routes, conf.routes...)
 		}
-		for _, route := range conf.routes {
-			config += fmt.Sprintf("\n[Route]\nDestination=%s\nGateway=%s\n", route.destination.String(), route.gateway)
-		}
-	case configMethodDHCP:
+	}
+
+	if len(domains) > 0 {
+		config += fmt.Sprintf("Domains=%s\n", strings.Join(domains, " "))
+	}
+	for _, nameserver := range nameservers {
+		config += fmt.Sprintf("DNS=%s\n", nameserver)
+	}
+
+	dhcp4, dhcp6 := usesDHCP(i.config), usesDHCP(i.config6)
+	switch {
+	case dhcp4 && (dhcp6 || i.config6 == nil):
 		config += "DHCP=true\n"
+	case dhcp4:
+		config += "DHCP=ipv4\n"
+	case dhcp6:
+		config += "DHCP=ipv6\n"
+	}
+	if i.ipv6AcceptRA != "" {
+		config += fmt.Sprintf("IPv6AcceptRA=%s\n", i.ipv6AcceptRA)
+	}
+
+	for _, addr := range addresses {
+		config += fmt.Sprintf("\n[Address]\nAddress=%s\n", addr.String())
+	}
+	for _, route := range routes {
+		config += fmt.Sprintf("\n[Route]\nDestination=%s\nGateway=%s\n", route.destination.String(), route.gateway)
 	}
 
 	return config
 }
 
+// usesDHCP returns whether the config method uses DHCP, on its own or
+// alongside static addresses.
+func usesDHCP(c configMethod) bool {
+	switch conf := c.(type) {
+	case configMethodDHCP:
+		return true
+	case configMethodStatic:
+		return conf.dhcp
+	}
+	return false
+}
+
 func (i *logicalInterface) Link() string {
 	return ""
 }
@@ -167,16 +205,22 @@ type vlanInterface struct {
 
 func (v *vlanInterface) Netdev() string {
 	config := fmt.Sprintf("[NetDev]\nKind=vlan\nName=%s\n", v.name)
-	switch c := v.config.(type) {
-	case configMethodStatic:
-		if c.hwaddress != nil {
-			config += fmt.Sprintf("MACAddress=%s\n", c.hwaddress)
-		}
-	case configMethodDHCP:
-		if c.hwaddress != nil {
-			config += fmt.Sprintf("MACAddress=%s\n", c.hwaddress)
+	var hwaddress net.HardwareAddr
+	for _, conf := range []configMethod{v.config6, v.config} {
+		switch c := conf.(type) {
+		case configMethodStatic:
+			if c.hwaddress != nil {
+				hwaddress = c.hwaddress
+			}
+		case configMethodDHCP:
+			if c.hwaddress != nil {
+				hwaddress = c.hwaddress
+			}
 		}
 	}
+	if hwaddress != nil {
+		config += fmt.Sprintf("MACAddress=%s\n", hwaddress)
+	}
 	config += fmt.Sprintf("\n[VLAN]\nId=%d\n", v.id)
 	return config
 }
@@ -185,8 +229,50 @@ func (v *vlanInterface) Type() string {
 	return "vlan"
 }
 
+type bridgeInterface struct {
+	logicalInterface
+	ports   []string
+	options map[string]string
+}
+
+func (b *bridgeInterface) Netdev() string {
+	config := fmt.Sprintf("[NetDev]\nKind=bridge\nName=%s\n", b.name)
+	if b.hwaddr != nil {
+		config += fmt.Sprintf("MACAddress=%s\n", b.hwaddr.String())
+	}
+
+	if len(b.options) > 0 {
+		config += fmt.Sprintf("\n[Bridge]\n")
+		for _, name := range sortedKeys(b.options) {
+			config += fmt.Sprintf("%s=%s\n", name, b.options[name])
+		}
+	}
+
+	return config
+}
+
+func (b *bridgeInterface) Type() string {
+	return "bridge"
+}
+
+// bridgeOptions maps the Debian bridge-utils options onto their networkd
+// equivalents.
+var bridgeOptions = map[string]string{
+	"bridge_ageing":     "AgeingTimeSec",
+	"bridge_bridgeprio": "Priority",
+	"bridge_fd":         "ForwardDelaySec",
+	"bridge_hello":      "HelloTimeSec",
+	"bridge_maxage":     "MaxAgeSec",
+	"bridge_stp":        "STP",
+}
+
 func buildInterfaces(stanzas []*stanzaInterface) []InterfaceGenerator {
-	interfaceMap := createInterfaces(stanzas)
+	return generateInterfaces(createInterfaces(stanzas))
+}
+
+// generateInterfaces links the interfaces in interfaceMap to their parents and
+// returns them sorted by key. Bonds and VLANs must be keyed by their name.
+func generateInterfaces(interfaceMap map[string]networkInterface) []InterfaceGenerator {
 	linkAncestors(interfaceMap)
 	markConfigDepths(interfaceMap)
 
@@ -211,9 +297,11 @@ func createInterfaces(stanzas []*stanzaInterface) map[string]networkInterface {
 			}
 			interfaceMap[iface.name] = &bondInterface{
 				logicalInterface{
-					name:     iface.name,
-					config:   iface.configMethod,
-					children: []networkInterface{},
+					name:         iface.name,
+					config:       iface.configMethod,
+					config6:      iface.configMethod6,
+					ipv6AcceptRA: ipv6AcceptRA(iface),
+					children:     []networkInterface{},
 				},
 				iface.options["bond-slaves"],
 				bondOptions,
@@ -230,15 +318,47 @@ func createInterfaces(stanzas []*stanzaInterface) map[string]networkInterface {
 				}
 			}
 
+		case interfaceBridge:
+			options := make(map[string]string)
+			for k, v := range iface.options {
+				if name, ok := bridgeOptions[k]; ok && len(v) > 0 {
+					options[name] = v[0]
+				}
+			}
+			interfaceMap[iface.name] = &bridgeInterface{
+				logicalInterface{
+					name:         iface.name,
+					config:       iface.configMethod,
+					config6:      iface.configMethod6,
+					ipv6AcceptRA: ipv6AcceptRA(iface),
+					children:     []networkInterface{},
+				},
+				iface.options["ports"],
+				options,
+			}
+			for _, port := range iface.options["ports"] {
+				if _, ok := interfaceMap[port]; !ok {
+					interfaceMap[port] = &physicalInterface{
+						logicalInterface{
+							name:     port,
+							config:   configMethodManual{},
+							children: []networkInterface{},
+						},
+					}
+				}
+			}
+
 		case interfacePhysical:
-			if _, ok := iface.configMethod.(configMethodLoopback); ok {
+			if isLoopback(iface) {
 				continue
 			}
 			interfaceMap[iface.name] = &physicalInterface{
 				logicalInterface{
-					name:     iface.name,
-					config:   iface.configMethod,
-					children: []networkInterface{},
+					name:         iface.name,
+					config:       iface.configMethod,
+					config6:      iface.configMethod6,
+					ipv6AcceptRA: ipv6AcceptRA(iface),
+					children:     []networkInterface{},
 				},
 			}
 
@@ -259,9 +379,11 @@ func createInterfaces(stanzas []*stanzaInterface) map[string]networkInterface {
 			}
 			interfaceMap[iface.name] = &vlanInterface{
 				logicalInterface{
-					name:     iface.name,
-					config:   iface.configMethod,
-					children: []networkInterface{},
+					name:         iface.name,
+					config:       iface.configMethod,
+					config6:      iface.configMethod6,
+					ipv6AcceptRA: ipv6AcceptRA(iface),
+					children:     []networkInterface{},
 				},
 				id,
 				rawDevice,
@@ -271,34 +393,80 @@ func createInterfaces(stanzas []*stanzaInterface) map[string]networkInterface {
 	return interfaceMap
 }
 
+func isLoopback(iface *stanzaInterface) bool {
+	if _, ok := iface.configMethod.(configMethodLoopback); ok {
+		return true
+	}
+	_, ok := iface.configMethod6.(configMethodLoopback)
+	return ok && iface.configMethod == nil
+}
+
+// ipv6AcceptRA returns the value of IPv6AcceptRA= for the interface. Like
+// ifupdown, router advertisements are ignored for static inet6 stanzas with a
+// gateway and accepted for other static, dhcp and auto ones unless accept_ra
+// says otherwise.
+func ipv6AcceptRA(iface *stanzaInterface) string {
+	if iface.configMethod6 == nil {
+		return ""
+	}
+	if acceptRA := iface.options["accept_ra"]; len(acceptRA) == 1 {
+		switch acceptRA[0] {
+		case "0":
+			return "false"
+		case "1", "2":
+			return "true"
+		}
+	}
+	switch conf := iface.configMethod6.(type) {
+	case configMethodStatic:
+		// The only route of a static inet6 stanza is its gateway
+		if len(conf.routes) > 0 {
+			return "false"
+		}
+		return "true"
+	case configMethodDHCP, configMethodAuto:
+		return "true"
+	}
+	return ""
+}
+
 func linkAncestors(interfaceMap map[string]networkInterface) {
 	for _, name := range sortedInterfaces(interfaceMap) {
 		iface := interfaceMap[name]
 		switch i := iface.(type) {
 		case *vlanInterface:
 			if parent, ok := interfaceMap[i.rawDevice]; ok {
-				switch p := parent.(type) {
-				case *physicalInterface:
-					p.children = append(p.children, iface)
-				case *bondInterface:
-					p.children = append(p.children, iface)
-				}
+				addChild(parent, iface)
 			}
 		case *bondInterface:
 			for _, slave := range i.slaves {
 				if parent, ok := interfaceMap[slave]; ok {
-					switch p := parent.(type) {
-					case *physicalInterface:
-						p.children = append(p.children, iface)
-					case *bondInterface:
-						p.children = append(p.children, iface)
-					}
+					addChild(parent, iface)
+				}
+			}
+		case *bridgeInterface:
+			for _, port := range i.ports {
+				if parent, ok := interfaceMap[port]; ok {
+					addChild(parent, iface)
 				}
 			}
 		}
 	}
 }
 
+func addChild(parent networkInterface, child networkInterface) {
+	switch p := parent.(type) {
+	case *physicalInterface:
+		p.children = append(p.children, child)
+	case *bondInterface:
+		p.children = append(p.children, child)
+	case *vlanInterface:
+		p.children = append(p.children, child)
+	case *bridgeInterface:
+		p.children = append(p.children, child)
+	}
+}
+
 func markConfigDepths(interfaceMap map[string]networkInterface) {
 	rootInterfaceMap := make(map[string]networkInterface)
 	for k, v := range interfaceMap {
@@ -72,6 +72,101 @@ func TestInterfaceGenerators(t *testing.T) {
 			kind:    "vlan",
 			iface:   &vlanInterface{logicalInterface{name: "testname"}, 1, ""},
 		},
+		{
+			name:    "testname",
+			netdev:  "[NetDev]\nKind=bridge\nName=testname\n",
+			network: "[Match]\nName=testname\n\n[Network]\nVLAN=testvlan1\nDHCP=true\n",
+			kind:    "bridge",
+			iface: &bridgeInterface{logicalInterface: logicalInterface{
+				name:   "testname",
+				config: configMethodDHCP{},
+				children: []networkInterface{
+					&vlanInterface{logicalInterface: logicalInterface{name: "testvlan1"}, id: 1},
+				},
+			}},
+		},
+		{
+			name:    "testname",
+			netdev:  "[NetDev]\nKind=bridge\nName=testname\nMACAddress=00:01:02:03:04:05\n\n[Bridge]\nForwardDelaySec=0\nSTP=off\n",
+			network: "[Match]\nName=testname\nMACAddress=00:01:02:03:04:05\n\n[Network]\n",
+			kind:    "bridge",
+			iface: &bridgeInterface{
+				logicalInterface{
+					name:   "testname",
+					hwaddr: net.HardwareAddr([]byte{0, 1, 2, 3, 4, 5}),
+				},
+				nil,
+				map[string]string{"STP": "off", "ForwardDelaySec": "0"},
+			},
+		},
+		{
+			name:    "testname",
+			network: "[Match]\nName=testname\n\n[Network]\nDHCP=ipv4\nIPv6AcceptRA=true\n",
+			kind:    "physical",
+			iface: &physicalInterface{logicalInterface{
+				name:         "testname",
+				config:       configMethodDHCP{},
+				config6:      configMethodAuto{},
+				ipv6AcceptRA: "true",
+			}},
+		},
+		{
+			name:    "testname",
+			network: "[Match]\nName=testname\n\n[Network]\nDHCP=true\nIPv6AcceptRA=true\n",
+			kind:    "physical",
+			iface: &physicalInterface{logicalInterface{
+				name:         "testname",
+				config:       configMethodDHCP{},
+				config6:      configMethodDHCP{},
+				ipv6AcceptRA: "true",
+			}},
+		},
+		{
+			name:    "testname",
+			network: "[Match]\nName=testname\n\n[Network]\nDNS=8.8.8.8\nDHCP=ipv6\nIPv6AcceptRA=false\n\n[Address]\nAddress=192.168.1.100/24\n",
+			kind:    "physical",
+			iface: &physicalInterface{logicalInterface{
+				name: "testname",
+				config: configMethodStatic{
+					addresses:   []net.IPNet{{IP: []byte{192, 168, 1, 100}, Mask: []byte{255, 255, 255, 0}}},
+					nameservers: []net.IP{[]byte{8, 8, 8, 8}},
+				},
+				config6:      configMethodDHCP{},
+				ipv6AcceptRA: "false",
+			}},
+		},
+		{
+			name: "testname",
+			network: "[Match]\nName=testname\n\n[Network]\nDNS=8.8.8.8\nDNS=2001:4860:4860::8888\nIPv6AcceptRA=false\n" +
+				"\n[Address]\nAddress=192.168.1.100/24\n\n[Address]\nAddress=2001:db8::2/64\n" +
+				"\n[Route]\nDestination=0.0.0.0/0\nGateway=1.2.3.4\n\n[Route]\nDestination=::/0\nGateway=2001:db8::1\n",
+			kind: "physical",
+			iface: &physicalInterface{logicalInterface{
+				name: "testname",
+				config: configMethodStatic{
+					addresses:   []net.IPNet{{IP: []byte{192, 168, 1, 100}, Mask: []byte{255, 255, 255, 0}}},
+					nameservers: []net.IP{[]byte{8, 8, 8, 8}},
+					routes:      []route{{destination: net.IPNet{IP: []byte{0, 0, 0, 0}, Mask: []byte{0, 0, 0, 0}}, gateway: []byte{1, 2, 3, 4}}},
+				},
+				config6: configMethodStatic{
+					addresses:   []net.IPNet{{IP: net.ParseIP("2001:db8::2"), Mask: net.CIDRMask(64, 128)}},
+					nameservers: []net.IP{net.ParseIP("2001:4860:4860::8888")},
+					routes:      []route{{destination: net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, gateway: net.ParseIP("2001:db8::1")}},
+				},
+				ipv6AcceptRA: "false",
+			}},
+		},
+		{
+			name:    "testname",
+			network: "[Match]\nName=testname\n\n[Network]\nBridge=testbridge\n",
+			kind:    "physical",
+			iface: &physicalInterface{logicalInterface{
+				name: "testname",
+				children: []networkInterface{
+					&bridgeInterface{logicalInterface: logicalInterface{name: "testbridge"}},
+				},
+			}},
+		},
 		{
 			name:    "testname",
 			netdev:  "[NetDev]\nKind=vlan\nName=testname\nMACAddress=00:01:02:03:04:05\n\n[VLAN]\nId=1\n",
@@ -167,6 +262,27 @@ func TestBuildInterfacesLo(t *testing.T) {
 	}
 }
 
+func TestIPv6AcceptRA(t *testing.T) {
+	for _, tt := range []struct {
+		iface    stanzaInterface
+		acceptRA string
+	}{
+		{iface: stanzaInterface{configMethod: configMethodDHCP{}}, acceptRA: ""},
+		{iface: stanzaInterface{configMethod6: configMethodManual{}}, acceptRA: ""},
+		{iface: stanzaInterface{configMethod6: configMethodStatic{}}, acceptRA: "true"},
+		{iface: stanzaInterface{configMethod6: configMethodStatic{routes: []route{{destination: net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, gateway: net.ParseIP("2001:db8::1")}}}}, acceptRA: "false"},
+		{iface: stanzaInterface{configMethod6: configMethodStatic{}, options: map[string][]string{"accept_ra": {"0"}}}, acceptRA: "false"},
+		{iface: stanzaInterface{configMethod6: configMethodDHCP{}}, acceptRA: "true"},
+		{iface: stanzaInterface{configMethod6: configMethodAuto{}}, acceptRA: "true"},
+		{iface: stanzaInterface{configMethod6: configMethodStatic{}, options: map[string][]string{"accept_ra": {"2"}}}, acceptRA: "true"},
+		{iface: stanzaInterface{configMethod6: configMethodAuto{}, options: map[string][]string{"accept_ra": {"0"}}}, acceptRA: "false"},
+	} {
+		if acceptRA := ipv6AcceptRA(&tt.iface); acceptRA != tt.acceptRA {
+			t.Fatalf("bad IPv6AcceptRA (%#v): want %q, got %q", tt.iface, tt.acceptRA, acceptRA)
+		}
+	}
+}
+
 func TestBuildInterfacesBlindBond(t *testing.T) {
 	stanzas := []*stanzaInterface{
 		{
@@ -242,6 +358,76 @@ func TestBuildInterfacesBlindVLAN(t *testing.T) {
 	}
 }
 
+func TestBuildInterfacesBridge(t *testing.T) {
+	stanzas := []*stanzaInterface{
+		{
+			name:         "bond0",
+			kind:         interfaceBond,
+			auto:         false,
+			configMethod: configMethodManual{},
+			options: map[string][]string{
+				"bond-slaves": {"eth0", "eth1"},
+			},
+		},
+		{
+			name:         "br0",
+			kind:         interfaceBridge,
+			auto:         false,
+			configMethod: configMethodDHCP{},
+			options: map[string][]string{
+				"ports":        {"bond0"},
+				"bridge_stp":   {"off"},
+				"bridge_fd":    {"0"},
+				"bridge_ports": {"bond0"},
+			},
+		},
+	}
+	interfaces := buildInterfaces(stanzas)
+	br0 := &bridgeInterface{
+		logicalInterface{
+			name:        "br0",
+			config:      configMethodDHCP{},
+			children:    []networkInterface{},
+			configDepth: 0,
+		},
+		[]string{"bond0"},
+		map[string]string{
+			"STP":             "off",
+			"ForwardDelaySec": "0",
+		},
+	}
+	bond0 := &bondInterface{
+		logicalInterface{
+			name:        "bond0",
+			config:      configMethodManual{},
+			children:    []networkInterface{br0},
+			configDepth: 1,
+		},
+		[]string{"eth0", "eth1"},
+		map[string]string{},
+	}
+	eth0 := &physicalInterface{
+		logicalInterface{
+			name:        "eth0",
+			config:      configMethodManual{},
+			children:    []networkInterface{bond0},
+			configDepth: 2,
+		},
+	}
+	eth1 := &physicalInterface{
+		logicalInterface{
+			name:        "eth1",
+			config:      configMethodManual{},
+			children:    []networkInterface{bond0},
+			configDepth: 2,
+		},
+	}
+	expect := []InterfaceGenerator{bond0, br0, eth0, eth1}
+	if !reflect.DeepEqual(interfaces, expect) {
+		t.FailNow()
+	}
+}
+
 func TestBuildInterfaces(t *testing.T) {
 	stanzas := []*stanzaInterface{
 		{
@@ -363,7 +549,7 @@ func TestFilename(t *testing.T) {
 		{logicalInterface{name: "iface", hwaddr: net.HardwareAddr([]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab}), configDepth: 1}, "01-iface"},
 	} {
 		if tt.i.Filename() != tt.f {
-			t.Fatalf("bad filename (%q): got %q, want %q", tt.i, tt.i.Filename(), tt.f)
+			t.Fatalf("bad filename (%+v): got %q, want %q", tt.i, tt.i.Filename(), tt.f)
 		}
 	}
 }
@@ -0,0 +1,692 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/yaml"
+)
+
+type netplanConfig struct {
+	Version int `yaml:"version"`
+
+	// Version 1
+	Config []netplanV1Entry `yaml:"config"`
+
+	// Version 2
+	Ethernets map[string]netplanV2Device `yaml:"ethernets"`
+	Bonds     map[string]netplanV2Device `yaml:"bonds"`
+	VLANs     map[string]netplanV2Device `yaml:"vlans"`
+	Bridges   map[string]netplanV2Device `yaml:"bridges"`
+}
+
+type netplanV1Entry struct {
+	Type             string            `yaml:"type"`
+	Name             string            `yaml:"name"`
+	MACAddress       string            `yaml:"mac_address"`
+	Subnets          []netplanV1Subnet `yaml:"subnets"`
+	BondInterfaces   []string          `yaml:"bond_interfaces"`
+	BridgeInterfaces []string          `yaml:"bridge_interfaces"`
+	Params           map[string]string `yaml:"params"`
+	VLANLink         string            `yaml:"vlan_link"`
+	VLANID           int               `yaml:"vlan_id"`
+	Address          interface{}       `yaml:"address"`
+	Search           interface{}       `yaml:"search"`
+}
+
+type netplanV1Subnet struct {
+	Type           string           `yaml:"type"`
+	Address        string           `yaml:"address"`
+	Netmask        string           `yaml:"netmask"`
+	Gateway        string           `yaml:"gateway"`
+	DNSNameservers interface{}      `yaml:"dns_nameservers"`
+	DNSSearch      interface{}      `yaml:"dns_search"`
+	Routes         []netplanV1Route `yaml:"routes"`
+}
+
+type netplanV1Route struct {
+	Network string `yaml:"network"`
+	Netmask string `yaml:"netmask"`
+	Gateway string `yaml:"gateway"`
+}
+
+type netplanV2Device struct {
+	Match struct {
+		Name       string `yaml:"name"`
+		MACAddress string `yaml:"macaddress"`
+	} `yaml:"match"`
+	SetName     string   `yaml:"set-name"`
+	MACAddress  string   `yaml:"macaddress"`
+	DHCP4       bool     `yaml:"dhcp4"`
+	DHCP6       bool     `yaml:"dhcp6"`
+	Addresses   []string `yaml:"addresses"`
+	Gateway4    string   `yaml:"gateway4"`
+	Gateway6    string   `yaml:"gateway6"`
+	Nameservers struct {
+		Addresses []string `yaml:"addresses"`
+		Search    []string `yaml:"search"`
+	} `yaml:"nameservers"`
+	Routes []struct {
+		To  string `yaml:"to"`
+		Via string `yaml:"via"`
+	} `yaml:"routes"`
+	Interfaces []string          `yaml:"interfaces"`
+	Parameters map[string]string `yaml:"parameters"`
+	ID         int               `yaml:"id"`
+	Link       string            `yaml:"link"`
+}
+
+// netplanAddressing collects the addressing of a single interface before it is
+// turned into a config method.
+type netplanAddressing struct {
+	dhcp4       bool
+	dhcp6       bool
+	addresses   []net.IPNet
+	routes      []route
+	nameservers []net.IP
+	domains     []string
+}
+
+// configMethod returns the IPv4 config method of the interface. Static
+// addresses of both families, along with their routes and nameservers, are
+// carried by it, and may be combined with DHCPv4.
+func (a netplanAddressing) configMethod(hwaddr net.HardwareAddr) configMethod {
+	switch {
+	case len(a.addresses) > 0:
+		return configMethodStatic{
+			addresses:   a.addresses,
+			nameservers: a.nameservers,
+			domains:     a.domains,
+			routes:      a.routes,
+			hwaddress:   hwaddr,
+			dhcp:        a.dhcp4,
+		}
+	case a.dhcp4:
+		return configMethodDHCP{hwaddress: hwaddr}
+	default:
+		return configMethodManual{}
+	}
+}
+
+// configMethod6 returns the IPv6 config method of the interface, which is
+// only used for DHCPv6.
+func (a netplanAddressing) configMethod6(hwaddr net.HardwareAddr) configMethod {
+	if a.dhcp6 {
+		return configMethodDHCP{hwaddress: hwaddr}
+	}
+	return configMethodManual{}
+}
+
+// netplanBondOptions maps netplan bond parameters onto their networkd
+// equivalents.
+var netplanBondOptions = map[string]string{
+	"mode":                 "Mode",
+	"lacp-rate":            "LACPTransmitRate",
+	"mii-monitor-interval": "MIIMonitorSec",
+	"up-delay":             "UpDelaySec",
+	"down-delay":           "DownDelaySec",
+	"transmit-hash-policy": "TransmitHashPolicy",
+	"min-links":            "MinLinks",
+}
+
+// netplanBridgeOptions maps netplan bridge parameters onto their networkd
+// equivalents.
+var netplanBridgeOptions = map[string]string{
+	"ageing-time":   "AgeingTimeSec",
+	"forward-delay": "ForwardDelaySec",
+	"hello-time":    "HelloTimeSec",
+	"max-age":       "MaxAgeSec",
+	"priority":      "Priority",
+	"stp":           "STP",
+}
+
+// netplanV1BondParams maps the "bond-" parameters of version 1 onto the
+// netplan names.
+var netplanV1BondParams = map[string]string{
+	"bond-mode":             "mode",
+	"bond-lacp-rate":        "lacp-rate",
+	"bond-miimon":           "mii-monitor-interval",
+	"bond-updelay":          "up-delay",
+	"bond-downdelay":        "down-delay",
+	"bond-xmit-hash-policy": "transmit-hash-policy",
+	"bond-min-links":        "min-links",
+}
+
+// ProcessNetplanNetconf translates a cloud-init network config, either version
+// 1 or version 2 (netplan), into interface generators. The config may be
+// wrapped in a top level "network" key.
+func ProcessNetplanNetconf(config []byte) ([]InterfaceGenerator, error) {
+	log.Println("Processing netplan network config")
+	if len(config) == 0 {
+		return nil, nil
+	}
+
+	// Interface names and netplan keys may contain hyphens; leave them as
+	// they are.
+	yaml.UnmarshalMappingKeyTransform = func(nameIn string) (nameOut string) {
+		return nameIn
+	}
+	var wrapper struct {
+		Network *netplanConfig `yaml:"network"`
+	}
+	if err := yaml.Unmarshal(config, &wrapper); err != nil {
+		return nil, err
+	}
+	netconf := wrapper.Network
+	if netconf == nil {
+		netconf = &netplanConfig{}
+		if err := yaml.Unmarshal(config, netconf); err != nil {
+			return nil, err
+		}
+	}
+
+	var interfaceMap map[string]networkInterface
+	var err error
+	switch netconf.Version {
+	case 1:
+		interfaceMap, err = processNetplanV1(netconf.Config)
+	case 2:
+		interfaceMap, err = processNetplanV2(netconf)
+	default:
+		return nil, fmt.Errorf("unsupported network config version %d", netconf.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Parsed %d network interfaces\n", len(interfaceMap))
+
+	log.Println("Processed netplan network config")
+	return generateInterfaces(interfaceMap), nil
+}
+
+func processNetplanV1(entries []netplanV1Entry) (map[string]networkInterface, error) {
+	var nameservers []net.IP
+	var domains []string
+	for _, entry := range entries {
+		if entry.Type != "nameserver" {
+			continue
+		}
+		servers, err := parseNetplanNameservers(entry.Address)
+		if err != nil {
+			return nil, err
+		}
+		search, err := netplanStrings(entry.Search)
+		if err != nil {
+			return nil, err
+		}
+		nameservers = append(nameservers, servers...)
+		domains = append(domains, search...)
+	}
+
+	interfaceMap := make(map[string]networkInterface)
+	for _, entry := range entries {
+		switch entry.Type {
+		case "nameserver":
+			continue
+		case "physical", "bond", "vlan", "bridge":
+		default:
+			log.Printf("Skipping network config entry of type %q\n", entry.Type)
+			continue
+		}
+
+		if entry.Name == "" {
+			return nil, fmt.Errorf("missing name for interface of type %q", entry.Type)
+		}
+		var hwaddr net.HardwareAddr
+		if entry.MACAddress != "" {
+			var err error
+			if hwaddr, err = net.ParseMAC(entry.MACAddress); err != nil {
+				return nil, fmt.Errorf("malformed MAC address for interface %q: %v", entry.Name, err)
+			}
+		}
+
+		addressing := netplanAddressing{
+			nameservers: append([]net.IP{}, nameservers...),
+			domains:     append([]string{}, domains...),
+		}
+		for _, subnet := range entry.Subnets {
+			if err := addNetplanV1Subnet(&addressing, subnet); err != nil {
+				return nil, fmt.Errorf("invalid subnet for interface %q: %v", entry.Name, err)
+			}
+		}
+
+		logical := logicalInterface{
+			name:     entry.Name,
+			hwaddr:   hwaddr,
+			children: []networkInterface{},
+		}
+		switch entry.Type {
+		case "physical":
+			logical.config = addressing.configMethod(nil)
+			logical.config6 = addressing.configMethod6(nil)
+			interfaceMap[entry.Name] = &physicalInterface{logical}
+		case "bond":
+			params := make(map[string]string)
+			for k, v := range entry.Params {
+				if name, ok := netplanV1BondParams[k]; ok {
+					params[name] = v
+				}
+			}
+			logical.config = addressing.configMethod(nil)
+			logical.config6 = addressing.configMethod6(nil)
+			interfaceMap[entry.Name] = &bondInterface{
+				logical,
+				entry.BondInterfaces,
+				netplanBondOptionsFor(params),
+			}
+		case "vlan":
+			logical.hwaddr = nil
+			logical.config = addressing.configMethod(hwaddr)
+			logical.config6 = addressing.configMethod6(hwaddr)
+			interfaceMap[entry.Name] = &vlanInterface{
+				logical,
+				entry.VLANID,
+				entry.VLANLink,
+			}
+		case "bridge":
+			options := make(map[string]string)
+			for k, v := range entry.Params {
+				if name, ok := bridgeOptions[k]; ok {
+					options[name] = v
+				}
+			}
+			logical.config = addressing.configMethod(nil)
+			logical.config6 = addressing.configMethod6(nil)
+			interfaceMap[entry.Name] = &bridgeInterface{
+				logical,
+				entry.BridgeInterfaces,
+				options,
+			}
+		}
+	}
+
+	if err := checkNetplanReferences(interfaceMap); err != nil {
+		return nil, err
+	}
+	return interfaceMap, nil
+}
+
+func addNetplanV1Subnet(addressing *netplanAddressing, subnet netplanV1Subnet) error {
+	switch subnet.Type {
+	case "dhcp", "dhcp4":
+		addressing.dhcp4 = true
+		return nil
+	case "dhcp6", "ipv6_dhcpv6-stateful", "ipv6_dhcpv6-stateless":
+		addressing.dhcp6 = true
+		return nil
+	case "manual", "ipv6_slaac":
+		return nil
+	case "static", "static6":
+	default:
+		return fmt.Errorf("unsupported subnet type %q", subnet.Type)
+	}
+
+	address, err := parseAddress(subnet.Address, subnet.Netmask)
+	if err != nil {
+		return err
+	}
+	addressing.addresses = append(addressing.addresses, address)
+
+	if subnet.Gateway != "" {
+		r, err := defaultNetplanRoute(subnet.Gateway)
+		if err != nil {
+			return err
+		}
+		addressing.routes = append(addressing.routes, r)
+	}
+	for _, sr := range subnet.Routes {
+		destination, err := parseAddress(sr.Network, sr.Netmask)
+		if err != nil {
+			return err
+		}
+		gateway := net.ParseIP(sr.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("invalid gateway: %q", sr.Gateway)
+		}
+		addressing.routes = append(addressing.routes, route{
+			destination: net.IPNet{IP: destination.IP.Mask(destination.Mask), Mask: destination.Mask},
+			gateway:     gateway,
+		})
+	}
+
+	nameservers, err := parseNetplanNameservers(subnet.DNSNameservers)
+	if err != nil {
+		return err
+	}
+	domains, err := netplanStrings(subnet.DNSSearch)
+	if err != nil {
+		return err
+	}
+	addressing.nameservers = append(addressing.nameservers, nameservers...)
+	addressing.domains = append(addressing.domains, domains...)
+	return nil
+}
+
+func processNetplanV2(netconf *netplanConfig) (map[string]networkInterface, error) {
+	// Ethernets are referred to by their netplan ID but may only be matched
+	// by MAC address; work out the key of each one first.
+	keys := make(map[string]string)
+	for id, device := range netconf.Ethernets {
+		switch {
+		case device.Match.Name != "":
+			keys[id] = device.Match.Name
+		case device.Match.MACAddress != "":
+			hwaddr, err := net.ParseMAC(device.Match.MACAddress)
+			if err != nil {
+				return nil, fmt.Errorf("malformed MAC address for interface %q: %v", id, err)
+			}
+			keys[id] = hwaddr.String()
+		default:
+			keys[id] = id
+		}
+	}
+	for id := range netconf.Bonds {
+		keys[id] = id
+	}
+	for id := range netconf.VLANs {
+		keys[id] = id
+	}
+	for id := range netconf.Bridges {
+		keys[id] = id
+	}
+
+	interfaceMap := make(map[string]networkInterface)
+	for _, id := range sortedDevices(netconf.Ethernets) {
+		device := netconf.Ethernets[id]
+		if device.SetName != "" {
+			log.Printf("Ignoring set-name %q for interface %q\n", device.SetName, id)
+		}
+		addressing, err := netplanV2Addressing(device)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config for interface %q: %v", id, err)
+		}
+		logical := logicalInterface{
+			config:   addressing.configMethod(nil),
+			config6:  addressing.configMethod6(nil),
+			children: []networkInterface{},
+		}
+		if device.Match.MACAddress != "" {
+			logical.hwaddr, _ = net.ParseMAC(device.Match.MACAddress)
+		}
+		if device.Match.Name != "" || device.Match.MACAddress == "" {
+			logical.name = keys[id]
+		}
+		interfaceMap[keys[id]] = &physicalInterface{logical}
+	}
+
+	for _, id := range sortedDevices(netconf.Bonds) {
+		device := netconf.Bonds[id]
+		addressing, err := netplanV2Addressing(device)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config for interface %q: %v", id, err)
+		}
+		var hwaddr net.HardwareAddr
+		if device.MACAddress != "" {
+			if hwaddr, err = net.ParseMAC(device.MACAddress); err != nil {
+				return nil, fmt.Errorf("malformed MAC address for interface %q: %v", id, err)
+			}
+		}
+		var slaves []string
+		for _, slave := range device.Interfaces {
+			key, ok := keys[slave]
+			if !ok {
+				return nil, fmt.Errorf("bond %q references unknown interface %q", id, slave)
+			}
+			slaves = append(slaves, key)
+		}
+		interfaceMap[id] = &bondInterface{
+			logicalInterface{
+				name:     id,
+				hwaddr:   hwaddr,
+				config:   addressing.configMethod(nil),
+				config6:  addressing.configMethod6(nil),
+				children: []networkInterface{},
+			},
+			slaves,
+			netplanBondOptionsFor(device.Parameters),
+		}
+	}
+
+	for _, id := range sortedDevices(netconf.VLANs) {
+		device := netconf.VLANs[id]
+		addressing, err := netplanV2Addressing(device)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config for interface %q: %v", id, err)
+		}
+		var hwaddr net.HardwareAddr
+		if device.MACAddress != "" {
+			if hwaddr, err = net.ParseMAC(device.MACAddress); err != nil {
+				return nil, fmt.Errorf("malformed MAC address for interface %q: %v", id, err)
+			}
+		}
+		link, ok := keys[device.Link]
+		if !ok {
+			return nil, fmt.Errorf("vlan %q references unknown interface %q", id, device.Link)
+		}
+		interfaceMap[id] = &vlanInterface{
+			logicalInterface{
+				name:     id,
+				config:   addressing.configMethod(hwaddr),
+				config6:  addressing.configMethod6(hwaddr),
+				children: []networkInterface{},
+			},
+			device.ID,
+			link,
+		}
+	}
+
+	for _, id := range sortedDevices(netconf.Bridges) {
+		device := netconf.Bridges[id]
+		addressing, err := netplanV2Addressing(device)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config for interface %q: %v", id, err)
+		}
+		var hwaddr net.HardwareAddr
+		if device.MACAddress != "" {
+			if hwaddr, err = net.ParseMAC(device.MACAddress); err != nil {
+				return nil, fmt.Errorf("malformed MAC address for interface %q: %v", id, err)
+			}
+		}
+		var ports []string
+		for _, port := range device.Interfaces {
+			key, ok := keys[port]
+			if !ok {
+				return nil, fmt.Errorf("bridge %q references unknown interface %q", id, port)
+			}
+			ports = append(ports, key)
+		}
+		options := make(map[string]string)
+		for k, v := range device.Parameters {
+			if name, ok := netplanBridgeOptions[k]; ok {
+				options[name] = v
+			} else {
+				log.Printf("Ignoring unsupported bridge parameter %q\n", k)
+			}
+		}
+		interfaceMap[id] = &bridgeInterface{
+			logicalInterface{
+				name:     id,
+				hwaddr:   hwaddr,
+				config:   addressing.configMethod(nil),
+				config6:  addressing.configMethod6(nil),
+				children: []networkInterface{},
+			},
+			ports,
+			options,
+		}
+	}
+
+	return interfaceMap, nil
+}
+
+func netplanV2Addressing(device netplanV2Device) (addressing netplanAddressing, err error) {
+	addressing.dhcp4, addressing.dhcp6 = device.DHCP4, device.DHCP6
+	for _, a := range device.Addresses {
+		address, err := parseAddress(a, "")
+		if err != nil {
+			return addressing, err
+		}
+		addressing.addresses = append(addressing.addresses, address)
+	}
+	for _, gateway := range []string{device.Gateway4, device.Gateway6} {
+		if gateway == "" {
+			continue
+		}
+		r, err := defaultNetplanRoute(gateway)
+		if err != nil {
+			return addressing, err
+		}
+		addressing.routes = append(addressing.routes, r)
+	}
+	for _, r := range device.Routes {
+		gateway := net.ParseIP(r.Via)
+		if gateway == nil {
+			return addressing, fmt.Errorf("invalid gateway: %q", r.Via)
+		}
+		if r.To == "default" {
+			dr, _ := defaultNetplanRoute(r.Via)
+			addressing.routes = append(addressing.routes, dr)
+			continue
+		}
+		_, destination, err := net.ParseCIDR(r.To)
+		if err != nil {
+			return addressing, err
+		}
+		addressing.routes = append(addressing.routes, route{destination: *destination, gateway: gateway})
+	}
+	if addressing.nameservers, err = parseNetplanNameservers(device.Nameservers.Addresses); err != nil {
+		return
+	}
+	addressing.domains = device.Nameservers.Search
+	return
+}
+
+// netplanBondOptionsFor translates netplan bond parameters into networkd
+// options. Durations given as plain numbers are in milliseconds.
+func netplanBondOptionsFor(params map[string]string) map[string]string {
+	options := make(map[string]string)
+	for k, v := range params {
+		name, ok := netplanBondOptions[k]
+		if !ok {
+			log.Printf("Ignoring unsupported bond parameter %q\n", k)
+			continue
+		}
+		if strings.HasSuffix(name, "Sec") {
+			if _, err := strconv.Atoi(v); err == nil {
+				v += "ms"
+			}
+		}
+		options[name] = v
+	}
+	return options
+}
+
+func defaultNetplanRoute(gateway string) (route, error) {
+	ip := net.ParseIP(gateway)
+	if ip == nil {
+		return route{}, fmt.Errorf("invalid gateway: %q", gateway)
+	}
+	if ip.To4() != nil {
+		return route{
+			destination: net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			gateway:     ip,
+		}, nil
+	}
+	return route{
+		destination: net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+		gateway:     ip,
+	}, nil
+}
+
+// checkNetplanReferences makes sure that the bond slaves, VLAN links and
+// bridge ports of a version 1 config name interfaces that were declared.
+func checkNetplanReferences(interfaceMap map[string]networkInterface) error {
+	for _, name := range sortedInterfaces(interfaceMap) {
+		switch i := interfaceMap[name].(type) {
+		case *bondInterface:
+			for _, slave := range i.slaves {
+				if _, ok := interfaceMap[slave]; !ok {
+					return fmt.Errorf("bond %q references unknown interface %q", name, slave)
+				}
+			}
+		case *vlanInterface:
+			if _, ok := interfaceMap[i.rawDevice]; !ok {
+				return fmt.Errorf("vlan %q references unknown interface %q", name, i.rawDevice)
+			}
+		case *bridgeInterface:
+			for _, port := range i.ports {
+				if _, ok := interfaceMap[port]; !ok {
+					return fmt.Errorf("bridge %q references unknown interface %q", name, port)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func parseNetplanNameservers(raw interface{}) ([]net.IP, error) {
+	addresses, err := netplanStrings(raw)
+	if err != nil {
+		return nil, err
+	}
+	var nameservers []net.IP
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid nameserver: %q", address)
+		}
+		nameservers = append(nameservers, ip)
+	}
+	return nameservers, nil
+}
+
+// netplanStrings accepts either a single string or a list of strings, both of
+// which are used for nameservers and search domains in version 1.
+func netplanStrings(raw interface{}) ([]string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		var out []string
+		for _, s := range v {
+			str, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string, got %v", s)
+			}
+			out = append(out, str)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("expected string or list of strings, got %v", raw)
+	}
+}
+
+func sortedDevices(m map[string]netplanV2Device) (keys []string) {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
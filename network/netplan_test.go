@@ -0,0 +1,412 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestProcessNetplanNetconf(t *testing.T) {
+	tests := []struct {
+		config string
+
+		units []generatedUnit
+		err   error
+	}{
+		{},
+		{
+			config: "version: 3\n",
+			err:    errors.New("unsupported network config version 3"),
+		},
+		{
+			config: `
+version: 1
+config:
+  - type: physical
+    name: eth0
+    mac_address: "00:11:22:33:44:55"
+    subnets:
+      - type: dhcp
+`,
+			units: []generatedUnit{
+				{
+					filename: "00-eth0",
+					network:  "[Match]\nName=eth0\nMACAddress=00:11:22:33:44:55\n\n[Network]\nDHCP=ipv4\n",
+				},
+			},
+		},
+		{
+			config: `
+network:
+  version: 1
+  config:
+    - type: physical
+      name: eth0
+      subnets:
+        - type: static
+          address: 10.0.0.2
+          netmask: 255.255.255.0
+          gateway: 10.0.0.1
+          dns_nameservers: 10.0.0.1
+          routes:
+            - network: 192.168.0.0
+              netmask: 255.255.0.0
+              gateway: 10.0.0.254
+        - type: static6
+          address: 2001:db8::2/64
+    - type: nameserver
+      address: [8.8.8.8]
+      search: example.com
+`,
+			units: []generatedUnit{
+				{
+					filename: "00-eth0",
+					network: "[Match]\nName=eth0\n\n[Network]\nDomains=example.com\nDNS=8.8.8.8\nDNS=10.0.0.1\n" +
+						"\n[Address]\nAddress=10.0.0.2/24\n" +
+						"\n[Address]\nAddress=2001:db8::2/64\n" +
+						"\n[Route]\nDestination=0.0.0.0/0\nGateway=10.0.0.1\n" +
+						"\n[Route]\nDestination=192.168.0.0/16\nGateway=10.0.0.254\n",
+				},
+			},
+		},
+		{
+			config: `
+version: 1
+config:
+  - type: physical
+    name: eth0
+  - type: physical
+    name: eth1
+  - type: bond
+    name: bond0
+    bond_interfaces: [eth0, eth1]
+    params:
+      bond-mode: 802.3ad
+      bond-miimon: 100
+  - type: vlan
+    name: vlan42
+    vlan_link: bond0
+    vlan_id: 42
+    mac_address: "00:11:22:33:44:77"
+    subnets:
+      - type: dhcp4
+`,
+			units: []generatedUnit{
+				{
+					filename: "01-bond0",
+					netdev:   "[NetDev]\nKind=bond\nName=bond0\n\n[Bond]\nMIIMonitorSec=100ms\nMode=802.3ad\n",
+					network:  "[Match]\nName=bond0\n\n[Network]\nVLAN=vlan42\n",
+				},
+				{
+					filename: "02-eth0",
+					network:  "[Match]\nName=eth0\n\n[Network]\nBond=bond0\n",
+				},
+				{
+					filename: "02-eth1",
+					network:  "[Match]\nName=eth1\n\n[Network]\nBond=bond0\n",
+				},
+				{
+					filename: "00-vlan42",
+					netdev:   "[NetDev]\nKind=vlan\nName=vlan42\nMACAddress=00:11:22:33:44:77\n\n[VLAN]\nId=42\n",
+					network:  "[Match]\nName=vlan42\n\n[Network]\nDHCP=ipv4\n",
+				},
+			},
+		},
+		{
+			config: `
+version: 1
+config:
+  - type: bond
+    name: bond0
+    bond_interfaces: [eth0]
+`,
+			err: errors.New(`bond "bond0" references unknown interface "eth0"`),
+		},
+		{
+			config: `
+version: 1
+config:
+  - type: physical
+    name: eth0
+    subnets:
+      - type: static
+        address: bad
+`,
+			err: errors.New(`invalid subnet for interface "eth0": invalid address "bad"`),
+		},
+		{
+			config: `
+version: 2
+ethernets:
+  nic0:
+    match:
+      macaddress: "00:11:22:33:44:55"
+    set-name: eth0
+    dhcp4: true
+  nic1:
+    match:
+      name: eth1
+    addresses: [10.0.0.2/24]
+    gateway4: 10.0.0.1
+    nameservers:
+      addresses: [8.8.8.8]
+      search: [example.com]
+    routes:
+      - to: 192.168.0.0/16
+        via: 10.0.0.254
+`,
+			units: []generatedUnit{
+				{
+					filename: "00-00:11:22:33:44:55",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nDHCP=ipv4\n",
+				},
+				{
+					filename: "00-eth1",
+					network: "[Match]\nName=eth1\n\n[Network]\nDomains=example.com\nDNS=8.8.8.8\n" +
+						"\n[Address]\nAddress=10.0.0.2/24\n" +
+						"\n[Route]\nDestination=0.0.0.0/0\nGateway=10.0.0.1\n" +
+						"\n[Route]\nDestination=192.168.0.0/16\nGateway=10.0.0.254\n",
+				},
+			},
+		},
+		{
+			config: `
+network:
+  version: 2
+  ethernets:
+    eno1: {}
+    eno2: {}
+  bonds:
+    bond0:
+      interfaces: [eno1, eno2]
+      macaddress: "00:11:22:33:44:55"
+      parameters:
+        mode: active-backup
+        mii-monitor-interval: 100
+  vlans:
+    vlan10:
+      id: 10
+      link: bond0
+      addresses: ["2001:db8::2/64"]
+`,
+			units: []generatedUnit{
+				{
+					filename: "01-bond0",
+					netdev:   "[NetDev]\nKind=bond\nName=bond0\nMACAddress=00:11:22:33:44:55\n\n[Bond]\nMIIMonitorSec=100ms\nMode=active-backup\n",
+					network:  "[Match]\nName=bond0\nMACAddress=00:11:22:33:44:55\n\n[Network]\nVLAN=vlan10\n",
+				},
+				{
+					filename: "02-eno1",
+					network:  "[Match]\nName=eno1\n\n[Network]\nBond=bond0\n",
+				},
+				{
+					filename: "02-eno2",
+					network:  "[Match]\nName=eno2\n\n[Network]\nBond=bond0\n",
+				},
+				{
+					filename: "00-vlan10",
+					netdev:   "[NetDev]\nKind=vlan\nName=vlan10\n\n[VLAN]\nId=10\n",
+					network:  "[Match]\nName=vlan10\n\n[Network]\n\n[Address]\nAddress=2001:db8::2/64\n",
+				},
+			},
+		},
+		{
+			config: `
+version: 1
+config:
+  - type: physical
+    name: eth0
+  - type: bridge
+    name: br0
+    bridge_interfaces: [eth0]
+    params:
+      bridge_stp: "off"
+      bridge_fd: 0
+    subnets:
+      - type: dhcp
+`,
+			units: []generatedUnit{
+				{
+					filename: "00-br0",
+					netdev:   "[NetDev]\nKind=bridge\nName=br0\n\n[Bridge]\nForwardDelaySec=0\nSTP=off\n",
+					network:  "[Match]\nName=br0\n\n[Network]\nDHCP=ipv4\n",
+				},
+				{
+					filename: "01-eth0",
+					network:  "[Match]\nName=eth0\n\n[Network]\nBridge=br0\n",
+				},
+			},
+		},
+		{
+			config: `
+version: 2
+ethernets:
+  eno1: {}
+bonds:
+  bond0:
+    interfaces: [eno1]
+bridges:
+  br0:
+    interfaces: [bond0]
+    dhcp4: true
+    parameters:
+      stp: false
+      forward-delay: 4
+`,
+			units: []generatedUnit{
+				{
+					filename: "01-bond0",
+					netdev:   "[NetDev]\nKind=bond\nName=bond0\n\n[Bond]\n",
+					network:  "[Match]\nName=bond0\n\n[Network]\nBridge=br0\n",
+				},
+				{
+					filename: "00-br0",
+					netdev:   "[NetDev]\nKind=bridge\nName=br0\n\n[Bridge]\nForwardDelaySec=4\nSTP=false\n",
+					network:  "[Match]\nName=br0\n\n[Network]\nDHCP=ipv4\n",
+				},
+				{
+					filename: "02-eno1",
+					network:  "[Match]\nName=eno1\n\n[Network]\nBond=bond0\n",
+				},
+			},
+		},
+		{
+			config: `
+version: 2
+bridges:
+  br0:
+    interfaces: [eth0]
+`,
+			err: errors.New(`bridge "br0" references unknown interface "eth0"`),
+		},
+		{
+			config: `
+version: 2
+vlans:
+  vlan10:
+    id: 10
+    link: eth0
+`,
+			err: errors.New(`vlan "vlan10" references unknown interface "eth0"`),
+		},
+		{
+			config: `
+version: 2
+ethernets:
+  eth0:
+    dhcp6: true
+  eth1:
+    dhcp4: true
+    dhcp6: true
+  eth2:
+    dhcp4: true
+    addresses: [10.0.0.2/24, "2001:db8::2/64"]
+`,
+			units: []generatedUnit{
+				{
+					filename: "00-eth0",
+					network:  "[Match]\nName=eth0\n\n[Network]\nDHCP=ipv6\n",
+				},
+				{
+					filename: "00-eth1",
+					network:  "[Match]\nName=eth1\n\n[Network]\nDHCP=true\n",
+				},
+				{
+					filename: "00-eth2",
+					network: "[Match]\nName=eth2\n\n[Network]\nDHCP=ipv4\n" +
+						"\n[Address]\nAddress=10.0.0.2/24\n" +
+						"\n[Address]\nAddress=2001:db8::2/64\n",
+				},
+			},
+		},
+		{
+			config: `
+version: 1
+config:
+  - type: physical
+    name: eth0
+    subnets:
+      - type: static
+        address: 10.0.0.2/24
+      - type: dhcp6
+  - type: vlan
+    name: vlan42
+    vlan_link: eth0
+    vlan_id: 42
+    mac_address: "00:11:22:33:44:77"
+    subnets:
+      - type: ipv6_dhcpv6-stateful
+`,
+			units: []generatedUnit{
+				{
+					filename: "01-eth0",
+					network: "[Match]\nName=eth0\n\n[Network]\nVLAN=vlan42\nDHCP=ipv6\n" +
+						"\n[Address]\nAddress=10.0.0.2/24\n",
+				},
+				{
+					filename: "00-vlan42",
+					netdev:   "[NetDev]\nKind=vlan\nName=vlan42\nMACAddress=00:11:22:33:44:77\n\n[VLAN]\nId=42\n",
+					network:  "[Match]\nName=vlan42\n\n[Network]\nDHCP=ipv6\n",
+				},
+			},
+		},
+		{
+			config: `
+version: 2
+ethernets:
+  eth0:
+    nameservers:
+      addresses: [test dns]
+`,
+			err: errors.New(`invalid config for interface "eth0": invalid nameserver: "test dns"`),
+		},
+	}
+
+	for i, tt := range tests {
+		interfaces, err := ProcessNetplanNetconf([]byte(tt.config))
+		if !reflect.DeepEqual(tt.err, err) {
+			t.Errorf("bad error (#%d): want %v, got %v", i, tt.err, err)
+		}
+		if units := generateUnits(interfaces); !reflect.DeepEqual(tt.units, units) {
+			t.Errorf("bad units (#%d): want %#v, got %#v", i, tt.units, units)
+		}
+	}
+}
+
+func TestNetplanBondOptionsFor(t *testing.T) {
+	for _, tt := range []struct {
+		params  map[string]string
+		options map[string]string
+	}{
+		{
+			params:  map[string]string{},
+			options: map[string]string{},
+		},
+		{
+			params:  map[string]string{"mode": "802.3ad", "lacp-rate": "fast", "up-delay": "200", "down-delay": "1s"},
+			options: map[string]string{"Mode": "802.3ad", "LACPTransmitRate": "fast", "UpDelaySec": "200ms", "DownDelaySec": "1s"},
+		},
+		{
+			params:  map[string]string{"unknown": "value"},
+			options: map[string]string{},
+		},
+	} {
+		if options := netplanBondOptionsFor(tt.params); !reflect.DeepEqual(tt.options, options) {
+			t.Errorf("bad options (%q): want %q, got %q", tt.params, tt.options, options)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+type openstackLink struct {
+	ID                 string   `json:"id"`
+	Type               string   `json:"type"`
+	MAC                string   `json:"ethernet_mac_address"`
+	BondLinks          []string `json:"bond_links"`
+	BondMode           string   `json:"bond_mode"`
+	BondMIIMon         int      `json:"bond_miimon"`
+	BondXmitHashPolicy string   `json:"bond_xmit_hash_policy"`
+	VLANLink           string   `json:"vlan_link"`
+	VLANID             int      `json:"vlan_id"`
+	VLANMAC            string   `json:"vlan_mac_address"`
+}
+
+type openstackRoute struct {
+	Network string `json:"network"`
+	Netmask string `json:"netmask"`
+	Gateway string `json:"gateway"`
+}
+
+type openstackService struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+type openstackNetwork struct {
+	ID        string             `json:"id"`
+	Type      string             `json:"type"`
+	Link      string             `json:"link"`
+	IPAddress string             `json:"ip_address"`
+	Netmask   string             `json:"netmask"`
+	Routes    []openstackRoute   `json:"routes"`
+	Services  []openstackService `json:"services"`
+}
+
+type openstackNetworkData struct {
+	Links    []openstackLink    `json:"links"`
+	Networks []openstackNetwork `json:"networks"`
+	Services []openstackService `json:"services"`
+}
+
+// ProcessOpenStackNetconf translates the contents of an OpenStack
+// network_data.json document into interface generators.
+func ProcessOpenStackNetconf(config []byte) ([]InterfaceGenerator, error) {
+	log.Println("Processing OpenStack network config")
+	if len(config) == 0 {
+		return nil, nil
+	}
+
+	var netdata openstackNetworkData
+	if err := json.Unmarshal(config, &netdata); err != nil {
+		return nil, err
+	}
+
+	nameservers, err := parseOpenStackNameservers(netdata.Services)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]*openstackAddressing)
+	for _, network := range netdata.Networks {
+		if _, ok := configs[network.Link]; !ok {
+			configs[network.Link] = &openstackAddressing{}
+		}
+		if err := addOpenStackNetwork(configs[network.Link], network, nameservers); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("Parsed %d networks\n", len(netdata.Networks))
+
+	interfaceMap, err := createOpenStackInterfaces(netdata.Links, configs)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Parsed %d network interfaces\n", len(interfaceMap))
+
+	log.Println("Processed OpenStack network config")
+	return generateInterfaces(interfaceMap), nil
+}
+
+// openstackAddressing collects the networks of a single link before they are
+// turned into config methods.
+type openstackAddressing struct {
+	dhcp4  bool
+	dhcp6  bool
+	static *configMethodStatic
+}
+
+// configMethod returns the IPv4 config method of the link. Static addresses
+// of both families, along with their routes and nameservers, are carried by
+// it, and may be combined with DHCPv4.
+func (a *openstackAddressing) configMethod(hwaddr net.HardwareAddr) configMethod {
+	switch {
+	case a == nil:
+		return configMethodManual{}
+	case a.static != nil:
+		static := *a.static
+		static.hwaddress = hwaddr
+		static.dhcp = a.dhcp4
+		return static
+	case a.dhcp4:
+		return configMethodDHCP{hwaddress: hwaddr}
+	default:
+		return configMethodManual{}
+	}
+}
+
+// configMethod6 returns the IPv6 config method of the link, which is only used
+// for DHCPv6.
+func (a *openstackAddressing) configMethod6(hwaddr net.HardwareAddr) configMethod {
+	if a != nil && a.dhcp6 {
+		return configMethodDHCP{hwaddress: hwaddr}
+	}
+	return configMethodManual{}
+}
+
+// createOpenStackInterfaces builds the interface map for the given links.
+// Bonds and VLANs are keyed by their generated name and physical interfaces by
+// their MAC address; slave and raw device references are translated from link
+// IDs to those keys.
+func createOpenStackInterfaces(links []openstackLink, configs map[string]*openstackAddressing) (map[string]networkInterface, error) {
+	byID := make(map[string]openstackLink)
+	keys := make(map[string]string)
+	names := make(map[string]string)
+	bonds, physicals := 0, 0
+	for _, link := range links {
+		if link.ID == "" {
+			return nil, fmt.Errorf("missing id for link of type %q", link.Type)
+		}
+		byID[link.ID] = link
+		switch link.Type {
+		case "bond":
+			names[link.ID] = fmt.Sprintf("bond%d", bonds)
+			keys[link.ID] = names[link.ID]
+			bonds++
+		case "vlan":
+		default:
+			if link.MAC == "" {
+				return nil, fmt.Errorf("missing MAC address for link %q", link.ID)
+			}
+			hwaddr, err := net.ParseMAC(link.MAC)
+			if err != nil {
+				return nil, fmt.Errorf("malformed MAC address for link %q: %v", link.ID, err)
+			}
+			// Physical links are matched by MAC address and only
+			// named for the sake of the VLANs on top of them
+			names[link.ID] = fmt.Sprintf("link%d", physicals)
+			keys[link.ID] = hwaddr.String()
+			physicals++
+		}
+	}
+
+	// VLANs are named after their parent and ID, which may itself be a
+	// VLAN listed later on.
+	var vlanName func(id string, seen map[string]bool) (string, error)
+	vlanName = func(id string, seen map[string]bool) (string, error) {
+		if name, ok := names[id]; ok {
+			return name, nil
+		}
+		if seen[id] {
+			return "", fmt.Errorf("vlan %q is its own ancestor", id)
+		}
+		seen[id] = true
+		link := byID[id]
+		if _, ok := byID[link.VLANLink]; !ok {
+			return "", fmt.Errorf("vlan %q references unknown link %q", link.ID, link.VLANLink)
+		}
+		parent, err := vlanName(link.VLANLink, seen)
+		if err != nil {
+			return "", err
+		}
+		names[id] = fmt.Sprintf("%s.%d", parent, link.VLANID)
+		return names[id], nil
+	}
+	for _, link := range links {
+		if link.Type != "vlan" {
+			continue
+		}
+		name, err := vlanName(link.ID, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		keys[link.ID] = name
+	}
+
+	interfaceMap := make(map[string]networkInterface)
+	for _, link := range links {
+		key := keys[link.ID]
+		if _, ok := interfaceMap[key]; ok {
+			return nil, fmt.Errorf("duplicate interface %q for link %q", key, link.ID)
+		}
+		switch link.Type {
+		case "bond":
+			var hwaddr net.HardwareAddr
+			if link.MAC != "" {
+				var err error
+				if hwaddr, err = net.ParseMAC(link.MAC); err != nil {
+					return nil, fmt.Errorf("malformed MAC address for link %q: %v", link.ID, err)
+				}
+			}
+			var slaves []string
+			for _, slave := range link.BondLinks {
+				slaveKey, ok := keys[slave]
+				if !ok {
+					return nil, fmt.Errorf("bond %q references unknown link %q", link.ID, slave)
+				}
+				slaves = append(slaves, slaveKey)
+			}
+			options := make(map[string]string)
+			if link.BondMode != "" {
+				options["Mode"] = link.BondMode
+			}
+			if link.BondMIIMon != 0 {
+				options["MIIMonitorSec"] = fmt.Sprintf("%dms", link.BondMIIMon)
+			}
+			if link.BondXmitHashPolicy != "" {
+				options["TransmitHashPolicy"] = link.BondXmitHashPolicy
+			}
+			interfaceMap[key] = &bondInterface{
+				logicalInterface{
+					name:     key,
+					hwaddr:   hwaddr,
+					config:   configs[link.ID].configMethod(nil),
+					config6:  configs[link.ID].configMethod6(nil),
+					children: []networkInterface{},
+				},
+				slaves,
+				options,
+			}
+
+		case "vlan":
+			var hwaddr net.HardwareAddr
+			if link.VLANMAC != "" {
+				var err error
+				if hwaddr, err = net.ParseMAC(link.VLANMAC); err != nil {
+					return nil, fmt.Errorf("malformed VLAN MAC address for link %q: %v", link.ID, err)
+				}
+			}
+			interfaceMap[key] = &vlanInterface{
+				logicalInterface{
+					name:     key,
+					config:   configs[link.ID].configMethod(hwaddr),
+					config6:  configs[link.ID].configMethod6(hwaddr),
+					children: []networkInterface{},
+				},
+				link.VLANID,
+				keys[link.VLANLink],
+			}
+
+		default:
+			hwaddr, _ := net.ParseMAC(link.MAC)
+			interfaceMap[key] = &physicalInterface{
+				logicalInterface{
+					hwaddr:   hwaddr,
+					config:   configs[link.ID].configMethod(nil),
+					config6:  configs[link.ID].configMethod6(nil),
+					children: []networkInterface{},
+				},
+			}
+		}
+	}
+
+	return interfaceMap, nil
+}
+
+// addOpenStackNetwork folds a single entry of "networks" into the addressing
+// collected so far for its link.
+func addOpenStackNetwork(addressing *openstackAddressing, network openstackNetwork, nameservers []net.IP) error {
+	switch network.Type {
+	case "ipv4", "ipv6":
+	case "ipv4_dhcp":
+		addressing.dhcp4 = true
+		return nil
+	case "ipv6_dhcp", "ipv6_dhcpv6-stateful", "ipv6_dhcpv6-stateless":
+		addressing.dhcp6 = true
+		return nil
+	case "ipv6_slaac":
+		// Router advertisements are accepted by default; nothing to add.
+		return nil
+	default:
+		return fmt.Errorf("unsupported network type %q in network %q", network.Type, network.ID)
+	}
+
+	if addressing.static == nil {
+		addressing.static = &configMethodStatic{nameservers: nameservers}
+	}
+	static := addressing.static
+
+	address, err := parseAddress(network.IPAddress, network.Netmask)
+	if err != nil {
+		return fmt.Errorf("malformed address in network %q: %v", network.ID, err)
+	}
+	static.addresses = append(static.addresses, address)
+
+	for _, r := range network.Routes {
+		destination, err := parseAddress(r.Network, r.Netmask)
+		if err != nil {
+			return fmt.Errorf("malformed route in network %q: %v", network.ID, err)
+		}
+		gateway := net.ParseIP(r.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("malformed gateway in network %q: %q", network.ID, r.Gateway)
+		}
+		static.routes = append(static.routes, route{
+			destination: net.IPNet{IP: destination.IP.Mask(destination.Mask), Mask: destination.Mask},
+			gateway:     gateway,
+		})
+	}
+
+	servers, err := parseOpenStackNameservers(network.Services)
+	if err != nil {
+		return err
+	}
+	static.nameservers = append(static.nameservers, servers...)
+	return nil
+}
+
+// parseAddress accepts either an address in CIDR notation or an
+// address with a separate netmask (dotted quad for IPv4, address form or
+// prefix length for IPv6).
+func parseAddress(address, netmask string) (net.IPNet, error) {
+	if strings.Contains(address, "/") {
+		ip, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return net.IPNet{}, err
+		}
+		return net.IPNet{IP: ip, Mask: network.Mask}, nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return net.IPNet{}, fmt.Errorf("invalid address %q", address)
+	}
+
+	bits := net.IPv6len * 8
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = net.IPv4len * 8
+	}
+
+	if mask := net.ParseIP(netmask); mask != nil {
+		if ip.To4() != nil {
+			return net.IPNet{IP: ip, Mask: net.IPMask(mask.To4())}, nil
+		}
+		return net.IPNet{IP: ip, Mask: net.IPMask(mask.To16())}, nil
+	}
+
+	var prefix int
+	if _, err := fmt.Sscanf(netmask, "%d", &prefix); err != nil || prefix < 0 || prefix > bits {
+		return net.IPNet{}, fmt.Errorf("invalid netmask %q", netmask)
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, bits)}, nil
+}
+
+func parseOpenStackNameservers(services []openstackService) ([]net.IP, error) {
+	var nameservers []net.IP
+	for _, service := range services {
+		if service.Type != "dns" {
+			continue
+		}
+		ip := net.ParseIP(service.Address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid nameserver: %q", service.Address)
+		}
+		nameservers = append(nameservers, ip)
+	}
+	return nameservers, nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type generatedUnit struct {
+	filename string
+	netdev   string
+	network  string
+}
+
+func generateUnits(interfaces []InterfaceGenerator) (units []generatedUnit) {
+	for _, iface := range interfaces {
+		units = append(units, generatedUnit{
+			filename: iface.Filename(),
+			netdev:   iface.Netdev(),
+			network:  iface.Network(),
+		})
+	}
+	return
+}
+
+func TestProcessOpenStackNetconf(t *testing.T) {
+	tests := []struct {
+		config string
+
+		units []generatedUnit
+		err   error
+	}{
+		{},
+		{
+			config: `{
+				"links": [{"id": "tap0", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:55"}],
+				"networks": [{"id": "net0", "type": "ipv4_dhcp", "link": "tap0"}]
+			}`,
+			units: []generatedUnit{
+				{
+					filename: "00-00:11:22:33:44:55",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nDHCP=ipv4\n",
+				},
+			},
+		},
+		{
+			config: `{
+				"links": [{"id": "tap0", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:55"}],
+				"networks": [
+					{"id": "net0", "type": "ipv4_dhcp", "link": "tap0"},
+					{"id": "net1", "type": "ipv6_dhcp", "link": "tap0"}
+				]
+			}`,
+			units: []generatedUnit{
+				{
+					filename: "00-00:11:22:33:44:55",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nDHCP=true\n",
+				},
+			},
+		},
+		{
+			config: `{
+				"links": [{"id": "tap0", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:55"}],
+				"networks": [
+					{"id": "net0", "type": "ipv4", "link": "tap0", "ip_address": "10.0.0.2/24"},
+					{"id": "net1", "type": "ipv6_dhcpv6-stateful", "link": "tap0"}
+				]
+			}`,
+			units: []generatedUnit{
+				{
+					filename: "00-00:11:22:33:44:55",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nDHCP=ipv6\n\n[Address]\nAddress=10.0.0.2/24\n",
+				},
+			},
+		},
+		{
+			config: `{
+				"links": [{"id": "tap0", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:55"}],
+				"networks": [
+					{"id": "net0", "type": "ipv4_dhcp", "link": "tap0"},
+					{"id": "net1", "type": "ipv6", "link": "tap0", "ip_address": "2001:db8::2/64"}
+				]
+			}`,
+			units: []generatedUnit{
+				{
+					filename: "00-00:11:22:33:44:55",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nDHCP=ipv4\n\n[Address]\nAddress=2001:db8::2/64\n",
+				},
+			},
+		},
+		{
+			config: `{
+				"links": [{"id": "tap0", "type": "ovs", "ethernet_mac_address": "00:11:22:33:44:55"}],
+				"networks": [
+					{"id": "net0", "type": "ipv4", "link": "tap0", "ip_address": "10.0.0.2", "netmask": "255.255.255.0",
+					 "routes": [{"network": "0.0.0.0", "netmask": "0.0.0.0", "gateway": "10.0.0.1"}]},
+					{"id": "net1", "type": "ipv6", "link": "tap0", "ip_address": "2001:db8::2/64"},
+					{"id": "net2", "type": "ipv6_slaac", "link": "tap0"}
+				],
+				"services": [{"type": "dns", "address": "8.8.8.8"}, {"type": "ntp", "address": "10.0.0.1"}]
+			}`,
+			units: []generatedUnit{
+				{
+					filename: "00-00:11:22:33:44:55",
+					network: "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nDNS=8.8.8.8\n" +
+						"\n[Address]\nAddress=10.0.0.2/24\n" +
+						"\n[Address]\nAddress=2001:db8::2/64\n" +
+						"\n[Route]\nDestination=0.0.0.0/0\nGateway=10.0.0.1\n",
+				},
+			},
+		},
+		{
+			config: `{
+				"links": [
+					{"id": "eth0", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:55"},
+					{"id": "eth1", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:66"},
+					{"id": "bond", "type": "bond", "ethernet_mac_address": "00:11:22:33:44:55", "bond_links": ["eth0", "eth1"],
+					 "bond_mode": "802.3ad", "bond_miimon": 100, "bond_xmit_hash_policy": "layer3+4"},
+					{"id": "vlan", "type": "vlan", "vlan_link": "bond", "vlan_id": 42, "vlan_mac_address": "00:11:22:33:44:77"}
+				],
+				"networks": [
+					{"id": "net0", "type": "ipv4", "link": "vlan", "ip_address": "10.0.0.2/24",
+					 "services": [{"type": "dns", "address": "10.0.0.1"}]}
+				]
+			}`,
+			units: []generatedUnit{
+				{
+					filename: "02-00:11:22:33:44:55",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nBond=bond0\n",
+				},
+				{
+					filename: "02-00:11:22:33:44:66",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:66\n\n[Network]\nBond=bond0\n",
+				},
+				{
+					filename: "01-bond0",
+					netdev:   "[NetDev]\nKind=bond\nName=bond0\nMACAddress=00:11:22:33:44:55\n\n[Bond]\nMIIMonitorSec=100ms\nMode=802.3ad\nTransmitHashPolicy=layer3+4\n",
+					network:  "[Match]\nName=bond0\nMACAddress=00:11:22:33:44:55\n\n[Network]\nVLAN=bond0.42\n",
+				},
+				{
+					filename: "00-bond0.42",
+					netdev:   "[NetDev]\nKind=vlan\nName=bond0.42\nMACAddress=00:11:22:33:44:77\n\n[VLAN]\nId=42\n",
+					network:  "[Match]\nName=bond0.42\n\n[Network]\nDNS=10.0.0.1\n\n[Address]\nAddress=10.0.0.2/24\n",
+				},
+			},
+		},
+		{
+			config: `{"links": [{"id": "tap0", "type": "phy"}]}`,
+			err:    errors.New(`missing MAC address for link "tap0"`),
+		},
+		{
+			config: `{"links": [{"id": "vlan", "type": "vlan", "vlan_link": "eth0", "vlan_id": 1}]}`,
+			err:    errors.New(`vlan "vlan" references unknown link "eth0"`),
+		},
+		{
+			config: `{
+				"links": [
+					{"id": "eth0", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:55"},
+					{"id": "eth1", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:66"},
+					{"id": "vlan-a", "type": "vlan", "vlan_link": "eth0", "vlan_id": 42},
+					{"id": "vlan-b", "type": "vlan", "vlan_link": "eth1", "vlan_id": 42}
+				]
+			}`,
+			units: []generatedUnit{
+				{
+					filename: "01-00:11:22:33:44:55",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:55\n\n[Network]\nVLAN=link0.42\n",
+				},
+				{
+					filename: "01-00:11:22:33:44:66",
+					network:  "[Match]\nMACAddress=00:11:22:33:44:66\n\n[Network]\nVLAN=link1.42\n",
+				},
+				{
+					filename: "00-link0.42",
+					netdev:   "[NetDev]\nKind=vlan\nName=link0.42\n\n[VLAN]\nId=42\n",
+					network:  "[Match]\nName=link0.42\n\n[Network]\n",
+				},
+				{
+					filename: "00-link1.42",
+					netdev:   "[NetDev]\nKind=vlan\nName=link1.42\n\n[VLAN]\nId=42\n",
+					network:  "[Match]\nName=link1.42\n\n[Network]\n",
+				},
+			},
+		},
+		{
+			config: `{"links": [{"id": "vlan-a", "type": "vlan", "vlan_link": "vlan-b", "vlan_id": 1}, {"id": "vlan-b", "type": "vlan", "vlan_link": "vlan-a", "vlan_id": 2}]}`,
+			err:    errors.New(`vlan "vlan-a" is its own ancestor`),
+		},
+		{
+			config: `{"links": [{"id": "eth0", "type": "phy", "ethernet_mac_address": "00:11:22:33:44:55"}, {"id": "vlan-a", "type": "vlan", "vlan_link": "eth0", "vlan_id": 42}, {"id": "vlan-b", "type": "vlan", "vlan_link": "eth0", "vlan_id": 42}]}`,
+			err:    errors.New(`duplicate interface "link0.42" for link "vlan-b"`),
+		},
+		{
+			config: `{"networks": [{"id": "net0", "type": "ipv4", "link": "tap0", "ip_address": "bad"}]}`,
+			err:    errors.New(`malformed address in network "net0": invalid address "bad"`),
+		},
+		{
+			config: `{"networks": [{"id": "net0", "type": "ipx", "link": "tap0"}]}`,
+			err:    errors.New(`unsupported network type "ipx" in network "net0"`),
+		},
+		{
+			config: `{"services": [{"type": "dns", "address": "test dns"}]}`,
+			err:    errors.New(`invalid nameserver: "test dns"`),
+		},
+	}
+
+	for i, tt := range tests {
+		interfaces, err := ProcessOpenStackNetconf([]byte(tt.config))
+		if !reflect.DeepEqual(tt.err, err) {
+			t.Errorf("bad error (#%d): want %v, got %v", i, tt.err, err)
+		}
+		if units := generateUnits(interfaces); !reflect.DeepEqual(tt.units, units) {
+			t.Errorf("bad units (#%d): want %#v, got %#v", i, tt.units, units)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	for _, tt := range []struct {
+		address string
+		netmask string
+
+		ipnet net.IPNet
+		err   error
+	}{
+		{
+			address: "10.0.0.2",
+			netmask: "255.255.255.0",
+			ipnet:   net.IPNet{IP: net.IPv4(10, 0, 0, 2).To4(), Mask: net.CIDRMask(24, 32)},
+		},
+		{
+			address: "10.0.0.2",
+			netmask: "24",
+			ipnet:   net.IPNet{IP: net.IPv4(10, 0, 0, 2).To4(), Mask: net.CIDRMask(24, 32)},
+		},
+		{
+			address: "2001:db8::2",
+			netmask: "ffff:ffff:ffff:ffff::",
+			ipnet:   net.IPNet{IP: net.ParseIP("2001:db8::2"), Mask: net.CIDRMask(64, 128)},
+		},
+		{
+			address: "2001:db8::2",
+			netmask: "64",
+			ipnet:   net.IPNet{IP: net.ParseIP("2001:db8::2"), Mask: net.CIDRMask(64, 128)},
+		},
+		{
+			address: "10.0.0.2",
+			netmask: "33",
+			err:     errors.New(`invalid netmask "33"`),
+		},
+	} {
+		ipnet, err := parseAddress(tt.address, tt.netmask)
+		if !reflect.DeepEqual(tt.err, err) {
+			t.Errorf("bad error (%q, %q): want %v, got %v", tt.address, tt.netmask, tt.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if ipnet.String() != tt.ipnet.String() {
+			t.Errorf("bad address (%q, %q): want %s, got %s", tt.address, tt.netmask, tt.ipnet.String(), ipnet.String())
+		}
+	}
+}
@@ -28,11 +28,12 @@ type stanzaAuto struct {
 }
 
 type stanzaInterface struct {
-	name         string
-	kind         interfaceKind
-	auto         bool
-	configMethod configMethod
-	options      map[string][]string
+	name          string
+	kind          interfaceKind
+	auto          bool
+	configMethod  configMethod
+	configMethod6 configMethod
+	options       map[string][]string
 }
 
 type interfaceKind int
@@ -41,6 +42,7 @@ const (
 	interfaceBond = interfaceKind(iota)
 	interfacePhysical
 	interfaceVLAN
+	interfaceBridge
 )
 
 type route struct {
@@ -56,6 +58,8 @@ type configMethodStatic struct {
 	domains     []string
 	routes      []route
 	hwaddress   net.HardwareAddr
+	// dhcp is set if DHCP is used alongside the static addresses
+	dhcp bool
 }
 
 type configMethodLoopback struct{}
@@ -66,6 +70,9 @@ type configMethodDHCP struct {
 	hwaddress net.HardwareAddr
 }
 
+// configMethodAuto is IPv6 stateless address autoconfiguration.
+type configMethodAuto struct{}
+
 func parseStanzas(lines []string) (stanzas []stanza, err error) {
 	rawStanzas, err := splitStanzas(lines)
 	if err != nil {
@@ -102,6 +109,38 @@ func parseStanzas(lines []string) (stanzas []stanza, err error) {
 	return stanzas, nil
 }
 
+// mergeInterfaceStanzas combines the inet and inet6 stanzas of each interface
+// into a single stanza, keeping the order in which interfaces first appear.
+func mergeInterfaceStanzas(stanzas []*stanzaInterface) []*stanzaInterface {
+	merged := make([]*stanzaInterface, 0, len(stanzas))
+	byName := make(map[string]*stanzaInterface)
+	for _, s := range stanzas {
+		m, ok := byName[s.name]
+		if !ok {
+			byName[s.name] = s
+			merged = append(merged, s)
+			continue
+		}
+
+		m.auto = m.auto || s.auto
+		if s.configMethod != nil {
+			m.configMethod = s.configMethod
+		}
+		if s.configMethod6 != nil {
+			m.configMethod6 = s.configMethod6
+		}
+		if m.kind == interfacePhysical {
+			m.kind = s.kind
+		}
+		for k, v := range s.options {
+			if _, ok := m.options[k]; !ok {
+				m.options[k] = v
+			}
+		}
+	}
+	return merged
+}
+
 func splitStanzas(lines []string) ([][]string, error) {
 	var curStanza []string
 	stanzas := make([][]string, 0)
@@ -174,6 +213,7 @@ func parseInterfaceStanza(attributes []string, options []string) (*stanzaInterfa
 	}
 
 	iface := attributes[0]
+	family := attributes[1]
 	confMethod := attributes[2]
 
 	optionMap := make(map[string][]string, 0)
@@ -204,6 +244,23 @@ func parseInterfaceStanza(attributes []string, options []string) (*stanzaInterfa
 		}
 	}
 
+	switch family {
+	case "inet":
+	case "inet6":
+		conf6, err := parseInet6ConfigMethod(iface, confMethod, optionMap)
+		if err != nil {
+			return nil, err
+		}
+		stanza, err := newInterfaceStanza(iface, nil, attributes, optionMap)
+		if err != nil {
+			return nil, err
+		}
+		stanza.configMethod6 = conf6
+		return stanza, nil
+	default:
+		return nil, fmt.Errorf("invalid address family %q", family)
+	}
+
 	var conf configMethod
 	switch confMethod {
 	case "static":
@@ -284,6 +341,55 @@ func parseInterfaceStanza(attributes []string, options []string) (*stanzaInterfa
 		return nil, fmt.Errorf("invalid config method %q", confMethod)
 	}
 
+	return newInterfaceStanza(iface, conf, attributes, optionMap)
+}
+
+func parseInet6ConfigMethod(iface string, confMethod string, optionMap map[string][]string) (configMethod, error) {
+	switch confMethod {
+	case "static":
+		config := configMethodStatic{
+			routes:      make([]route, 0),
+			nameservers: make([]net.IP, 0),
+		}
+		var address, netmask string
+		if addresses := optionMap["address"]; len(addresses) == 1 {
+			address = addresses[0]
+		}
+		if netmasks := optionMap["netmask"]; len(netmasks) == 1 {
+			netmask = netmasks[0]
+		}
+		ipnet, err := parseAddress(address, netmask)
+		if err != nil || ipnet.IP.To4() != nil {
+			return nil, fmt.Errorf("malformed static inet6 network config for %q", iface)
+		}
+		config.addresses = []net.IPNet{ipnet}
+		if gateways := optionMap["gateway"]; len(gateways) == 1 {
+			config.routes = append(config.routes, route{
+				destination: net.IPNet{
+					IP:   net.IPv6zero,
+					Mask: net.CIDRMask(0, 128),
+				},
+				gateway: net.ParseIP(gateways[0]),
+			})
+		}
+		for _, nameserver := range optionMap["dns-nameservers"] {
+			config.nameservers = append(config.nameservers, net.ParseIP(nameserver))
+		}
+		return config, nil
+	case "dhcp":
+		return configMethodDHCP{}, nil
+	case "auto":
+		return configMethodAuto{}, nil
+	case "loopback":
+		return configMethodLoopback{}, nil
+	case "manual":
+		return configMethodManual{}, nil
+	default:
+		return nil, fmt.Errorf("invalid inet6 config method %q", confMethod)
+	}
+}
+
+func newInterfaceStanza(iface string, conf configMethod, attributes []string, optionMap map[string][]string) (*stanzaInterface, error) {
 	if _, ok := optionMap["vlan_raw_device"]; ok {
 		return parseVLANStanza(iface, conf, attributes, optionMap)
 	}
@@ -292,6 +398,10 @@ func parseInterfaceStanza(attributes []string, options []string) (*stanzaInterfa
 		return parseVLANStanza(iface, conf, attributes, optionMap)
 	}
 
+	if _, ok := optionMap["bridge_ports"]; ok {
+		return parseBridgeStanza(iface, conf, attributes, optionMap)
+	}
+
 	if _, ok := optionMap["bond-slaves"]; ok {
 		return parseBondStanza(iface, conf, attributes, optionMap)
 	}
@@ -316,6 +426,15 @@ func parseBondStanza(iface string, conf configMethod, attributes []string, optio
 	return &stanzaInterface{name: iface, kind: interfaceBond, configMethod: conf, options: options}, nil
 }
 
+func parseBridgeStanza(iface string, conf configMethod, attributes []string, options map[string][]string) (*stanzaInterface, error) {
+	ports := options["bridge_ports"]
+	if len(ports) == 1 && ports[0] == "none" {
+		ports = []string{}
+	}
+	options["ports"] = ports
+	return &stanzaInterface{name: iface, kind: interfaceBridge, configMethod: conf, options: options}, nil
+}
+
 func parsePhysicalStanza(iface string, conf configMethod, attributes []string, options map[string][]string) (*stanzaInterface, error) {
 	return &stanzaInterface{name: iface, kind: interfacePhysical, configMethod: conf, options: options}, nil
 }
@@ -58,6 +58,10 @@ func TestBadParseInterfaceStanza(t *testing.T) {
 		{[]string{"eth", "inet", "static"}, []string{"address 192.168.1.100", "netmask invalid"}, "malformed static network config"},
 		{[]string{"eth", "inet", "static"}, []string{"address 192.168.1.100", "netmask 255.255.255.0", "hwaddress ether NotAnAddress"}, "malformed hwaddress option"},
 		{[]string{"eth", "inet", "dhcp"}, []string{"hwaddress ether NotAnAddress"}, "malformed hwaddress option"},
+		{[]string{"eth", "ipx", "static"}, nil, "invalid address family"},
+		{[]string{"eth", "inet6", "invalid"}, nil, "invalid inet6 config method"},
+		{[]string{"eth", "inet6", "static"}, []string{"address 2001:db8::2"}, "malformed static inet6 network config"},
+		{[]string{"eth", "inet6", "static"}, []string{"address 192.168.1.100", "netmask 24"}, "malformed static inet6 network config"},
 	} {
 		_, err := parseInterfaceStanza(tt.in, tt.opts)
 		if err == nil || !strings.HasPrefix(err.Error(), tt.e) {
@@ -497,6 +501,97 @@ func TestParseInterfaceStanzaBond(t *testing.T) {
 	}
 }
 
+func TestParseInterfaceStanzaBridge(t *testing.T) {
+	iface, err := parseInterfaceStanza([]string{"br0", "inet", "manual"}, []string{"bridge_ports eth0 eth1", "bond-slaves eth2"})
+	if err != nil {
+		t.FailNow()
+	}
+	if iface.kind != interfaceBridge {
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(iface.options["ports"], []string{"eth0", "eth1"}) {
+		t.FailNow()
+	}
+}
+
+func TestParseInterfaceStanzaBridgeNoPorts(t *testing.T) {
+	iface, err := parseInterfaceStanza([]string{"br0", "inet", "manual"}, []string{"bridge_ports none"})
+	if err != nil {
+		t.FailNow()
+	}
+	if iface.kind != interfaceBridge {
+		t.FailNow()
+	}
+	if len(iface.options["ports"]) != 0 {
+		t.FailNow()
+	}
+}
+
+func TestParseInterfaceStanzaInet6(t *testing.T) {
+	for _, tt := range []struct {
+		attributes []string
+		options    []string
+		conf       configMethod
+	}{
+		{
+			attributes: []string{"eth", "inet6", "static"},
+			options:    []string{"address 2001:db8::2", "netmask 64", "gateway 2001:db8::1", "dns-nameservers 2001:4860:4860::8888"},
+			conf: configMethodStatic{
+				addresses:   []net.IPNet{{IP: net.ParseIP("2001:db8::2"), Mask: net.CIDRMask(64, 128)}},
+				routes:      []route{{destination: net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, gateway: net.ParseIP("2001:db8::1")}},
+				nameservers: []net.IP{net.ParseIP("2001:4860:4860::8888")},
+			},
+		},
+		{
+			attributes: []string{"eth", "inet6", "static"},
+			options:    []string{"address 2001:db8::2/64"},
+			conf: configMethodStatic{
+				addresses:   []net.IPNet{{IP: net.ParseIP("2001:db8::2"), Mask: net.CIDRMask(64, 128)}},
+				routes:      []route{},
+				nameservers: []net.IP{},
+			},
+		},
+		{
+			attributes: []string{"eth", "inet6", "dhcp"},
+			conf:       configMethodDHCP{},
+		},
+		{
+			attributes: []string{"eth", "inet6", "auto"},
+			conf:       configMethodAuto{},
+		},
+		{
+			attributes: []string{"eth", "inet6", "manual"},
+			conf:       configMethodManual{},
+		},
+	} {
+		iface, err := parseInterfaceStanza(tt.attributes, tt.options)
+		if err != nil {
+			t.Fatalf("bad error (%q): want nil, got %q", tt.attributes, err)
+		}
+		if iface.configMethod != nil {
+			t.Fatalf("bad config method (%q): want nil, got %#v", tt.attributes, iface.configMethod)
+		}
+		if !reflect.DeepEqual(iface.configMethod6, tt.conf) {
+			t.Fatalf("bad inet6 config method (%q): want %#v, got %#v", tt.attributes, tt.conf, iface.configMethod6)
+		}
+	}
+}
+
+func TestMergeInterfaceStanzas(t *testing.T) {
+	stanzas := []*stanzaInterface{
+		{name: "eth0", kind: interfacePhysical, configMethod6: configMethodAuto{}, options: map[string][]string{"accept_ra": {"0"}}},
+		{name: "eth1", kind: interfacePhysical, configMethod: configMethodDHCP{}, options: map[string][]string{}},
+		{name: "eth0", kind: interfacePhysical, auto: true, configMethod: configMethodDHCP{}, options: map[string][]string{}},
+	}
+	expect := []*stanzaInterface{
+		{name: "eth0", kind: interfacePhysical, auto: true, configMethod: configMethodDHCP{}, configMethod6: configMethodAuto{}, options: map[string][]string{"accept_ra": {"0"}}},
+		{name: "eth1", kind: interfacePhysical, configMethod: configMethodDHCP{}, options: map[string][]string{}},
+	}
+	if merged := mergeInterfaceStanzas(stanzas); !reflect.DeepEqual(merged, expect) {
+		t.Fatalf("bad merge: want %#v, got %#v", expect, merged)
+	}
+}
+
 func TestParseInterfaceStanzaVLANName(t *testing.T) {
 	iface, err := parseInterfaceStanza([]string{"eth0.1", "inet", "manual"}, nil)
 	if err != nil {
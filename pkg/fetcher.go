@@ -0,0 +1,138 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Fetcher is a Getter which fetches http(s), tftp, file and data URLs, with
+// the retry and backoff semantics of its HttpClient.
+type Fetcher struct {
+	*HttpClient
+
+	// Timeout of each TFTP packet exchange. Defaults to 5 seconds
+	TftpTimeout time.Duration
+}
+
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		HttpClient:  NewHttpClient(),
+		TftpTimeout: 5 * time.Second,
+	}
+}
+
+// GetRetry fetches a given URL with support for exponential backoff and maximum retries
+func (f *Fetcher) GetRetry(rawurl string) ([]byte, error) {
+	return f.GetRetryContext(f.context(), rawurl)
+}
+
+// GetRetryContext is GetRetry with the given context instead of f.Context.
+func (f *Fetcher) GetRetryContext(ctx context.Context, rawurl string) ([]byte, error) {
+	if rawurl == "" {
+		return nil, ErrInvalid{errors.New("URL is empty. Skipping.")}
+	}
+
+	url, err := neturl.Parse(rawurl)
+	if err != nil {
+		return nil, ErrInvalid{err}
+	}
+
+	switch url.Scheme {
+	case "http", "https", "tftp", "file", "data":
+	default:
+		return nil, ErrInvalid{fmt.Errorf("URL %s does not have a supported scheme. Skipping.", rawurl)}
+	}
+
+	return f.retry(ctx, rawurl, f.GetContext)
+}
+
+// Get fetches the given URL once.
+func (f *Fetcher) Get(rawurl string) ([]byte, error) {
+	return f.GetContext(f.context(), rawurl)
+}
+
+// GetContext is Get with the given context instead of f.Context.
+func (f *Fetcher) GetContext(ctx context.Context, rawurl string) ([]byte, error) {
+	url, err := neturl.Parse(rawurl)
+	if err != nil {
+		return nil, ErrInvalid{err}
+	}
+
+	switch url.Scheme {
+	case "http", "https":
+		return f.HttpClient.GetContext(ctx, rawurl)
+	case "tftp":
+		return tftpGet(ctx, url.Host, strings.TrimPrefix(url.Path, "/"), f.TftpTimeout)
+	case "file":
+		return readFileURL(url)
+	case "data":
+		return decodeDataURL(rawurl)
+	default:
+		return nil, ErrInvalid{fmt.Errorf("URL %s does not have a supported scheme", rawurl)}
+	}
+}
+
+func readFileURL(url *neturl.URL) ([]byte, error) {
+	if url.Host != "" && url.Host != "localhost" {
+		return nil, ErrInvalid{fmt.Errorf("file URL %s refers to another host", url)}
+	}
+	p := url.Path
+	if p == "" {
+		p = url.Opaque
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound{err}
+	} else if err != nil {
+		return nil, ErrInvalid{err}
+	}
+	return data, nil
+}
+
+// decodeDataURL decodes an RFC 2397 data URL, e.g.
+// "data:;base64,I2Nsb3VkLWNvbmZpZwo=" or "data:,%23cloud-config%0A".
+func decodeDataURL(rawurl string) ([]byte, error) {
+	idx := strings.Index(rawurl, ",")
+	if !strings.HasPrefix(rawurl, "data:") || idx < 0 {
+		return nil, ErrInvalid{errors.New("data URL has no ','")}
+	}
+	mediaType, data := rawurl[len("data:"):idx], rawurl[idx+1:]
+
+	decoded, err := neturl.PathUnescape(data)
+	if err != nil {
+		return nil, ErrInvalid{err}
+	}
+	if strings.HasSuffix(mediaType, ";base64") {
+		b, err := base64.StdEncoding.DecodeString(decoded)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(decoded)
+		}
+		if err != nil {
+			return nil, ErrInvalid{fmt.Errorf("invalid base64 in data URL: %v", err)}
+		}
+		return b, nil
+	}
+	return []byte(decoded), nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeDataURL(t *testing.T) {
+	for i, tt := range []struct {
+		url  string
+		data string
+		err  bool
+	}{
+		{"data:,%23cloud-config%0Ahostname:%20foo%0A", "#cloud-config\nhostname: foo\n", false},
+		{"data:text/cloud-config,#cloud-config", "#cloud-config", false},
+		{"data:;base64,I2Nsb3VkLWNvbmZpZwo=", "#cloud-config\n", false},
+		{"data:text/plain;charset=utf-8;base64,I2Nsb3VkLWNvbmZpZwo%3D", "#cloud-config\n", false},
+		{"data:;base64,!!!", "", true},
+		{"data:no-comma", "", true},
+	} {
+		data, err := decodeDataURL(tt.url)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want %v, got %v", i, tt.err, err)
+		}
+		if _, ok := err.(ErrInvalid); err != nil && !ok {
+			t.Errorf("bad error type (#%d): want ErrInvalid, got %T", i, err)
+		}
+		if string(data) != tt.data {
+			t.Errorf("bad data (#%d): want %q, got %q", i, tt.data, data)
+		}
+	}
+}
+
+func TestFetcherGetRetry(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "user_data")
+	if err := ioutil.WriteFile(file, []byte("from file"), 0644); err != nil {
+		t.Fatalf("failed writing file: %v", err)
+	}
+
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts++; attempts < 2 {
+			http.Error(w, "", 500)
+			return
+		}
+		w.Write([]byte("from http"))
+	}))
+	defer ts.Close()
+
+	tftp := newTFTPServer(t, map[string][]byte{"pxe/user_data": []byte("from tftp")}, false)
+	defer tftp.Close()
+
+	for i, tt := range []struct {
+		url  string
+		data []byte
+		err  error
+	}{
+		{ts.URL, []byte("from http"), nil},
+		{"file://" + file, []byte("from file"), nil},
+		{"file:" + file, []byte("from file"), nil},
+		{"data:,from%20data", []byte("from data"), nil},
+		{"tftp://" + tftp.Addr() + "/pxe/user_data", []byte("from tftp"), nil},
+		{"file://" + path.Join(dir, "missing"), nil, ErrNotFound{}},
+		{"file://remote" + file, nil, ErrInvalid{}},
+		{"ftp://example.com/user_data", nil, ErrInvalid{}},
+		{"", nil, ErrInvalid{}},
+	} {
+		f := NewFetcher()
+		f.MaxRetries = 3
+		data, err := f.GetRetry(tt.url)
+		if reflect.TypeOf(err) != reflect.TypeOf(tt.err) {
+			t.Errorf("bad error (#%d): want %T, got %v", i, tt.err, err)
+		}
+		if !reflect.DeepEqual(data, tt.data) {
+			t.Errorf("bad data (#%d): want %q, got %q", i, tt.data, data)
+		}
+	}
+}
@@ -15,10 +15,12 @@
 package pkg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	neturl "net/url"
 	"strings"
@@ -52,6 +54,38 @@ type ErrNetwork struct {
 	Err
 }
 
+type ErrUnauthorized struct {
+	Err
+}
+
+type ErrCanceled struct {
+	Err
+}
+
+// ErrStatus is the Err of the ErrUnauthorized, ErrNotFound and ErrServer
+// returned for an HTTP response, and holds the response's status code.
+type ErrStatus struct {
+	Err
+	StatusCode int
+}
+
+// StatusCode returns the HTTP status code of the response for which err was
+// returned, or 0 if err was not returned for a response.
+func StatusCode(err error) int {
+	switch e := err.(type) {
+	case ErrUnauthorized:
+		err = e.Err
+	case ErrNotFound:
+		err = e.Err
+	case ErrServer:
+		err = e.Err
+	}
+	if s, ok := err.(ErrStatus); ok {
+		return s.StatusCode
+	}
+	return 0
+}
+
 type HttpClient struct {
 	// Initial backoff duration. Defaults to 50 milliseconds
 	InitialBackoff time.Duration
@@ -62,6 +96,10 @@ type HttpClient struct {
 	// Maximum number of connection retries. Defaults to 15
 	MaxRetries int
 
+	// Context of every request; cancelling it aborts requests in flight
+	// and retries. Defaults to context.Background()
+	Context context.Context
+
 	// Headers to add to the request.
 	Header http.Header
 
@@ -73,34 +111,77 @@ type Getter interface {
 	GetRetry(string) ([]byte, error)
 }
 
+// ContextGetter is implemented by Getters whose requests can be aborted by
+// cancelling the given context.
+type ContextGetter interface {
+	GetContext(context.Context, string) ([]byte, error)
+}
+
+// GetContext calls g.GetContext if g is a ContextGetter, and g.Get otherwise.
+func GetContext(ctx context.Context, g Getter, url string) ([]byte, error) {
+	if cg, ok := g.(ContextGetter); ok {
+		return cg.GetContext(ctx, url)
+	}
+	return g.Get(url)
+}
+
 func NewHttpClient() *HttpClient {
 	return NewHttpClientHeader(nil)
 }
 
+// NewHttpClientHeader returns an HttpClient which adds the given headers to
+// every request. Its timeouts and retries default to those passed to
+// Configure.
 func NewHttpClientHeader(header http.Header) *HttpClient {
+	cfg, _ := currentConfig()
 	hc := &HttpClient{
 		InitialBackoff: 50 * time.Millisecond,
 		MaxBackoff:     time.Second * 5,
 		MaxRetries:     15,
 		Header:         header,
+		Context:        context.Background(),
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: configuredTransport{},
 		},
 	}
+	if cfg.InitialBackoff > 0 {
+		hc.InitialBackoff = cfg.InitialBackoff
+	}
+	if cfg.MaxBackoff > 0 {
+		hc.MaxBackoff = cfg.MaxBackoff
+	}
+	if cfg.MaxRetries > 0 {
+		hc.MaxRetries = cfg.MaxRetries
+	}
+	if cfg.Timeout > 0 {
+		hc.client.Timeout = cfg.Timeout
+	}
 
 	return hc
 }
 
+// ExpBackoff doubles interval up to max, and then takes off a random jitter
+// of up to half of it so that clients which start together do not retry in
+// lockstep.
 func ExpBackoff(interval, max time.Duration) time.Duration {
 	interval = interval * 2
 	if interval > max {
 		interval = max
 	}
-	return interval
+	if interval <= 1 {
+		return interval
+	}
+	return interval - time.Duration(rand.Int63n(int64(interval/2)+1))
 }
 
 // GetRetry fetches a given URL with support for exponential backoff and maximum retries
 func (h *HttpClient) GetRetry(rawurl string) ([]byte, error) {
+	return h.GetRetryContext(h.context(), rawurl)
+}
+
+// GetRetryContext is GetRetry with the given context instead of h.Context.
+func (h *HttpClient) GetRetryContext(ctx context.Context, rawurl string) ([]byte, error) {
 	if rawurl == "" {
 		return nil, ErrInvalid{errors.New("URL is empty. Skipping.")}
 	}
@@ -116,18 +197,26 @@ func (h *HttpClient) GetRetry(rawurl string) ([]byte, error) {
 		return nil, ErrInvalid{fmt.Errorf("URL %s does not have a valid HTTP scheme. Skipping.", rawurl)}
 	}
 
-	dataURL := url.String()
+	return h.retry(ctx, url.String(), h.GetContext)
+}
 
+// retry calls get with the given URL until it succeeds, fails with an error
+// other than ErrNetwork or ErrServer, or MaxRetries is reached, backing off
+// exponentially in between.
+func (h *HttpClient) retry(ctx context.Context, dataURL string, get func(context.Context, string) ([]byte, error)) ([]byte, error) {
 	duration := h.InitialBackoff
 	for retry := 1; retry <= h.MaxRetries; retry++ {
 		log.Printf("Fetching data from %s. Attempt #%d", dataURL, retry)
 
-		data, err := h.Get(dataURL)
+		data, err := get(ctx, dataURL)
+		if ctx.Err() != nil {
+			return nil, ErrCanceled{fmt.Errorf("Fetching %s was canceled: %v", dataURL, ctx.Err())}
+		}
 		switch err.(type) {
 		case ErrNetwork:
-			log.Printf(err.Error())
+			log.Println(err)
 		case ErrServer:
-			log.Printf(err.Error())
+			log.Println(err)
 		case ErrNotFound:
 			return data, err
 		default:
@@ -136,14 +225,51 @@ func (h *HttpClient) GetRetry(rawurl string) ([]byte, error) {
 
 		duration = ExpBackoff(duration, h.MaxBackoff)
 		log.Printf("Sleeping for %v...", duration)
-		time.Sleep(duration)
+		select {
+		case <-time.After(duration):
+		case <-ctx.Done():
+			return nil, ErrCanceled{fmt.Errorf("Fetching %s was canceled: %v", dataURL, ctx.Err())}
+		}
 	}
 
 	return nil, ErrTimeout{fmt.Errorf("Unable to fetch data. Maximum retries reached: %d", h.MaxRetries)}
 }
 
 func (h *HttpClient) Get(dataURL string) ([]byte, error) {
-	request, err := http.NewRequest("GET", dataURL, nil)
+	return h.GetContext(h.context(), dataURL)
+}
+
+// GetContext is Get with the given context instead of h.Context.
+func (h *HttpClient) GetContext(ctx context.Context, dataURL string) ([]byte, error) {
+	return h.request(ctx, "GET", dataURL)
+}
+
+// Put issues a PUT request with an empty body to the given URL. It is used for
+// handshakes such as fetching a metadata session token.
+func (h *HttpClient) Put(dataURL string) ([]byte, error) {
+	return h.PutContext(h.context(), dataURL)
+}
+
+// PutContext is Put with the given context instead of h.Context.
+func (h *HttpClient) PutContext(ctx context.Context, dataURL string) ([]byte, error) {
+	return h.request(ctx, "PUT", dataURL)
+}
+
+// PutRetryContext is PutContext with the same backoff and retries as
+// GetRetry.
+func (h *HttpClient) PutRetryContext(ctx context.Context, dataURL string) ([]byte, error) {
+	return h.retry(ctx, dataURL, h.PutContext)
+}
+
+func (h *HttpClient) context() context.Context {
+	if h.Context == nil {
+		return context.Background()
+	}
+	return h.Context
+}
+
+func (h *HttpClient) request(ctx context.Context, method, dataURL string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, dataURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -155,9 +281,12 @@ func (h *HttpClient) Get(dataURL string) ([]byte, error) {
 		case HTTP_2xx:
 			return ioutil.ReadAll(resp.Body)
 		case HTTP_4xx:
-			return nil, ErrNotFound{fmt.Errorf("Not found. HTTP status code: %d", resp.StatusCode)}
+			if resp.StatusCode == http.StatusUnauthorized {
+				return nil, ErrUnauthorized{ErrStatus{fmt.Errorf("Unauthorized. HTTP status code: %d", resp.StatusCode), resp.StatusCode}}
+			}
+			return nil, ErrNotFound{ErrStatus{fmt.Errorf("Not found. HTTP status code: %d", resp.StatusCode), resp.StatusCode}}
 		default:
-			return nil, ErrServer{fmt.Errorf("Server error. HTTP status code: %d", resp.StatusCode)}
+			return nil, ErrServer{ErrStatus{fmt.Errorf("Server error. HTTP status code: %d", resp.StatusCode), resp.StatusCode}}
 		}
 	} else {
 		return nil, ErrNetwork{fmt.Errorf("Unable to fetch data: %s", err.Error())}
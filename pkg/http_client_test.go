@@ -15,6 +15,8 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -152,3 +154,144 @@ func TestGetMalformedURL(t *testing.T) {
 		}
 	}
 }
+
+// Test that a 401 is reported as unauthorized rather than not found
+func TestGetURL401(t *testing.T) {
+	client := NewHttpClient()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", 401)
+	}))
+	defer ts.Close()
+
+	_, err := client.GetRetry(ts.URL)
+	if _, ok := err.(ErrUnauthorized); !ok {
+		t.Errorf("Incorrect result\ngot:  %#v\nwant: %s", err, "ErrUnauthorized")
+	}
+}
+
+// Test that the status code of error responses can be told apart
+func TestStatusCode(t *testing.T) {
+	for _, status := range []int{400, 401, 403, 404, 405, 500} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "", status)
+		}))
+		_, err := NewHttpClient().Get(ts.URL)
+		ts.Close()
+		if code := StatusCode(err); code != status {
+			t.Errorf("bad status code: want %d, got %d (%v)", status, code, err)
+		}
+	}
+	if code := StatusCode(ErrNetwork{errors.New("unreachable")}); code != 0 {
+		t.Errorf("bad status code: want %d, got %d", 0, code)
+	}
+}
+
+// Test that Put issues a PUT with the client's headers
+func TestPut(t *testing.T) {
+	client := NewHttpClientHeader(http.Header{"X-Test": {"value"}})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.Error(w, "", 405)
+			return
+		}
+		fmt.Fprint(w, r.Header.Get("X-Test"))
+	}))
+	defer ts.Close()
+
+	data, err := client.Put(ts.URL)
+	if err != nil {
+		t.Errorf("Incorrect result\ngot:  %v\nwant: %v", err, nil)
+	}
+	if string(data) != "value" {
+		t.Errorf("Incorrect result\ngot:  %s\nwant: %s", data, "value")
+	}
+}
+
+// Test that PutRetryContext retries server errors
+func TestPutRetryContext(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Method != "PUT" || attempts < 3 {
+			http.Error(w, "", 500)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	client := NewHttpClient()
+	client.InitialBackoff = time.Millisecond
+	client.MaxBackoff = time.Millisecond
+	data, err := client.PutRetryContext(context.Background(), ts.URL)
+	if err != nil || string(data) != "ok" {
+		t.Errorf("bad result: want (%q, nil), got (%q, %v)", "ok", data, err)
+	}
+	if attempts != 3 {
+		t.Errorf("bad attempts: want %d, got %d", 3, attempts)
+	}
+}
+
+// Test that ExpBackoff takes off at most half of the doubled interval and
+// that the jitter actually varies
+func TestExpBackoffJitter(t *testing.T) {
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := ExpBackoff(time.Second, time.Minute)
+		if d < time.Second || d > 2*time.Second {
+			t.Fatalf("bad backoff: want 1s-2s, got %v", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("bad jitter: want varying backoffs, got %v", seen)
+	}
+}
+
+func TestNewHttpClientConfigured(t *testing.T) {
+	if err := Configure(HttpConfig{Timeout: time.Minute, MaxRetries: 3, InitialBackoff: time.Second, MaxBackoff: time.Hour}); err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	defer Configure(HttpConfig{})
+
+	client := NewHttpClient()
+	if client.client.Timeout != time.Minute || client.MaxRetries != 3 || client.InitialBackoff != time.Second || client.MaxBackoff != time.Hour {
+		t.Errorf("bad client: want timeout 1m, 3 retries and backoff 1s-1h, got %v, %d and %v-%v", client.client.Timeout, client.MaxRetries, client.InitialBackoff, client.MaxBackoff)
+	}
+}
+
+// Test that cancelling the context aborts both requests in flight and the
+// backoff between retries
+func TestGetRetryContextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", 500)
+	}))
+	defer failing.Close()
+
+	for i, url := range []string{slow.URL, failing.URL} {
+		client := NewHttpClient()
+		client.InitialBackoff = time.Hour
+		client.MaxBackoff = time.Hour
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		_, err := client.GetRetryContext(ctx, url)
+		cancel()
+
+		if _, ok := err.(ErrCanceled); !ok {
+			t.Errorf("bad error (#%d): want ErrCanceled, got %v", i, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("bad duration (#%d): want <5s, got %v", i, elapsed)
+		}
+	}
+}
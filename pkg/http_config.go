@@ -0,0 +1,222 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HttpConfig holds the settings shared by every HttpClient.
+type HttpConfig struct {
+	// Proxy is the URL of the proxy through which requests are made. If it
+	// is empty, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables are used.
+	Proxy string
+
+	// NoProxy is a comma-separated list of hosts, domains (".example.com")
+	// and CIDRs which are reached without Proxy. Defaults to NO_PROXY.
+	NoProxy string
+
+	// CAFile is a PEM bundle of certificates which are trusted in addition
+	// to the system's.
+	CAFile string
+
+	// CertFile and KeyFile are the PEM client certificate and key presented
+	// to servers which ask for one.
+	CertFile string
+	KeyFile  string
+
+	// BearerToken is sent in the Authorization header of HTTPS requests to
+	// BearerTokenHost (the host, and port if any, of the config URL). It is
+	// never sent to any other host or over plain HTTP.
+	BearerToken     string
+	BearerTokenHost string
+
+	// Timeout of each request, MaxRetries of GetRetry and the InitialBackoff
+	// and MaxBackoff between retries of HttpClients created after Configure.
+	// Zero values keep the defaults of NewHttpClientHeader.
+	Timeout        time.Duration
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var (
+	configMutex   sync.RWMutex
+	httpConfig    HttpConfig
+	httpTransport http.RoundTripper = http.DefaultTransport
+)
+
+// Configure applies the given settings to every HttpClient, including those
+// which have already been created.
+func Configure(cfg HttpConfig) error {
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	httpConfig = cfg
+	httpTransport = transport
+	return nil
+}
+
+func currentConfig() (HttpConfig, http.RoundTripper) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return httpConfig, httpTransport
+}
+
+func newTransport(cfg HttpConfig) (*http.Transport, error) {
+	proxy, err := proxyFunc(cfg.Proxy, cfg.NoProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("a client certificate requires both a certificate and a key")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}, nil
+}
+
+// proxyFunc returns the function which picks the proxy for a request. Hosts
+// matched by noProxy, as well as loopback and link-local addresses (where
+// metadata services live), are always reached directly.
+func proxyFunc(proxy, noProxy string) (func(*http.Request) (*neturl.URL, error), error) {
+	if proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	proxyURL, err := neturl.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", proxy, err)
+	}
+	if noProxy == "" {
+		noProxy = os.Getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+	}
+
+	return func(req *http.Request) (*neturl.URL, error) {
+		if useProxy(req.URL.Hostname(), noProxy) {
+			return proxyURL, nil
+		}
+		return nil, nil
+	}, nil
+}
+
+func useProxy(host, noProxy string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast()) {
+		return false
+	}
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return false
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+				return false
+			}
+		case strings.HasPrefix(entry, "."):
+			if strings.HasSuffix(host, entry) || host == entry[1:] {
+				return false
+			}
+		default:
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sendsBearerToken returns whether the BearerToken is to be sent with a
+// request for u.
+func (cfg HttpConfig) sendsBearerToken(u *neturl.URL) bool {
+	return cfg.BearerToken != "" && cfg.BearerTokenHost != "" &&
+		u.Scheme == "https" && strings.EqualFold(u.Host, cfg.BearerTokenHost)
+}
+
+// configuredTransport is the http.RoundTripper of every HttpClient. It uses
+// the settings passed to Configure at the time of the request.
+type configuredTransport struct{}
+
+func (configuredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg, transport := currentConfig()
+	if cfg.sendsBearerToken(req.URL) && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		req.Header.Set("Authorization", "Bearer "+cfg.BearerToken)
+	}
+	return transport.RoundTrip(req)
+}
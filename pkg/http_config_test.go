@@ -0,0 +1,248 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUseProxy(t *testing.T) {
+	for i, tt := range []struct {
+		host    string
+		noProxy string
+		proxy   bool
+	}{
+		{"example.com", "", true},
+		{"localhost", "", false},
+		{"127.0.0.1", "", false},
+		{"169.254.169.254", "", false},
+		{"fe80::1", "", false},
+		{"example.com", "*", false},
+		{"example.com", "example.com", false},
+		{"config.example.com", "example.com", false},
+		{"config.example.com", ".example.com", false},
+		{"example.com", ".example.com", false},
+		{"badexample.com", "example.com", true},
+		{"10.1.2.3", "192.168.0.0/16, 10.0.0.0/8", false},
+		{"172.16.0.1", "192.168.0.0/16,10.0.0.0/8", true},
+		{"Config.Example.COM", "example.com", false},
+	} {
+		if proxy := useProxy(tt.host, tt.noProxy); proxy != tt.proxy {
+			t.Errorf("bad proxy decision for %q with %q (#%d): want %v, got %v", tt.host, tt.noProxy, i, tt.proxy, proxy)
+		}
+	}
+}
+
+func TestConfigureProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	if err := Configure(HttpConfig{Proxy: proxy.URL, NoProxy: "direct.example.com"}); err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	defer Configure(HttpConfig{})
+
+	data, err := NewHttpClient().Get("http://config.example.com/user-data")
+	if err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	if string(data) != "proxied" || proxied != "http://config.example.com/user-data" {
+		t.Errorf("bad proxied request: want %q, got %q (%q)", "http://config.example.com/user-data", proxied, data)
+	}
+}
+
+func TestConfigureBearerToken(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var auth []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+	})
+	ts := httptest.NewTLSServer(handler)
+	defer ts.Close()
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	caFile := path.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatalf("failed writing CA: %v", err)
+	}
+	tsURL, _ := url.Parse(ts.URL)
+	if err := Configure(HttpConfig{BearerToken: "s3cr3t", BearerTokenHost: tsURL.Host, CAFile: caFile}); err != nil {
+		t.Fatalf("bad error: want %v, got %v", nil, err)
+	}
+	defer Configure(HttpConfig{})
+
+	// The token also applies to clients created before Configure, but
+	// only over HTTPS to the configured host
+	for _, tt := range []struct {
+		client *HttpClient
+		url    string
+	}{
+		{NewHttpClient(), ts.URL},
+		{NewHttpClientHeader(http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}}), ts.URL},
+		{NewHttpClient(), plain.URL},
+	} {
+		if _, err := tt.client.Get(tt.url); err != nil {
+			t.Fatalf("bad error: want %v, got %v", nil, err)
+		}
+	}
+	want := []string{"Bearer s3cr3t", "Basic Zm9vOmJhcg==", ""}
+	if !reflect.DeepEqual(want, auth) {
+		t.Errorf("bad Authorization headers: want %q, got %q", want, auth)
+	}
+}
+
+func TestSendsBearerToken(t *testing.T) {
+	cfg := HttpConfig{BearerToken: "s3cr3t", BearerTokenHost: "config.example.com"}
+	for i, tt := range []struct {
+		cfg HttpConfig
+		url string
+
+		send bool
+	}{
+		{cfg, "https://config.example.com/user-data", true},
+		{cfg, "https://CONFIG.example.com/include", true},
+		{cfg, "http://config.example.com/user-data", false},
+		{cfg, "https://config.example.com:8443/user-data", false},
+		{cfg, "https://other.example.com/user-data", false},
+		{cfg, "http://169.254.169.254/latest/user-data", false},
+		{HttpConfig{BearerToken: "s3cr3t"}, "https://config.example.com/user-data", false},
+		{HttpConfig{BearerTokenHost: "config.example.com"}, "https://config.example.com/user-data", false},
+	} {
+		u, _ := url.Parse(tt.url)
+		if send := tt.cfg.sendsBearerToken(u); send != tt.send {
+			t.Errorf("bad send (#%d): want %t, got %t", i, tt.send, send)
+		}
+	}
+}
+
+func TestConfigureTLS(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCert, clientKey := writeCertificate(t, dir)
+	clientPool := x509.NewCertPool()
+	certPEM, _ := ioutil.ReadFile(clientCert)
+	clientPool.AppendCertsFromPEM(certPEM)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mtls"))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientPool}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := path.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatalf("failed writing CA: %v", err)
+	}
+
+	for i, tt := range []struct {
+		cfg HttpConfig
+		ok  bool
+	}{
+		{HttpConfig{}, false},
+		{HttpConfig{CAFile: caFile}, false},
+		{HttpConfig{CAFile: caFile, CertFile: clientCert, KeyFile: clientKey}, true},
+	} {
+		if err := Configure(tt.cfg); err != nil {
+			t.Fatalf("bad error (#%d): want %v, got %v", i, nil, err)
+		}
+		data, err := NewHttpClient().Get(ts.URL)
+		if ok := err == nil && string(data) == "mtls"; ok != tt.ok {
+			t.Errorf("bad request result (#%d): want %v, got %v (%v)", i, tt.ok, ok, err)
+		}
+	}
+	Configure(HttpConfig{})
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	for i, cfg := range []HttpConfig{
+		{CAFile: "/does/not/exist"},
+		{CAFile: "/dev/null"},
+		{CertFile: "/cert.pem"},
+		{KeyFile: "/key.pem"},
+		{CertFile: "/does/not/exist", KeyFile: "/does/not/exist"},
+		{Proxy: "http://[::1"},
+	} {
+		if err := Configure(cfg); err == nil {
+			t.Errorf("bad error (#%d): want non-nil, got nil", i)
+		}
+	}
+}
+
+// writeCertificate writes a self-signed client certificate and its key to
+// dir and returns their paths.
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "coreos-cloudinit"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed marshalling key: %v", err)
+	}
+
+	certFile, keyFile := path.Join(dir, "cert.pem"), path.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("failed writing certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed writing key: %v", err)
+	}
+	return certFile, keyFile
+}
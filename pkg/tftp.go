@@ -0,0 +1,151 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TFTP opcodes and error codes (RFC 1350)
+const (
+	tftpRRQ   = 1
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+
+	tftpErrNotFound   = 1
+	tftpErrAccess     = 2
+	tftpErrUnknownTID = 5
+
+	tftpBlockSize   = 512
+	tftpPort        = "69"
+	tftpMaxAttempts = 5
+)
+
+// tftpGet downloads file from the TFTP server at host in octet mode. Each
+// packet is retransmitted up to tftpMaxAttempts times if no reply arrives
+// within timeout.
+func tftpGet(ctx context.Context, host, file string, timeout time.Duration) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, tftpPort)
+	}
+	server, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, ErrNetwork{fmt.Errorf("Unable to fetch data: %v", err)}
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, ErrNetwork{fmt.Errorf("Unable to fetch data: %v", err)}
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	rrq := &bytes.Buffer{}
+	binary.Write(rrq, binary.BigEndian, uint16(tftpRRQ))
+	rrq.WriteString(file + "\x00octet\x00")
+
+	var (
+		data     bytes.Buffer
+		last     = rrq.Bytes()
+		dest     = server
+		peer     *net.UDPAddr
+		block    = uint16(1)
+		attempts = 0
+		buf      = make([]byte, tftpBlockSize+4)
+	)
+	if _, err := conn.WriteToUDP(last, dest); err != nil {
+		return nil, ErrNetwork{fmt.Errorf("Unable to fetch data: %v", err)}
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			if attempts++; attempts >= tftpMaxAttempts {
+				return nil, ErrNetwork{fmt.Errorf("Unable to fetch data: TFTP server %s timed out", host)}
+			}
+			conn.WriteToUDP(last, dest)
+			continue
+		} else if ctx.Err() != nil {
+			return nil, ErrCanceled{fmt.Errorf("Fetching %s from %s was canceled: %v", file, host, ctx.Err())}
+		} else if err != nil {
+			return nil, ErrNetwork{fmt.Errorf("Unable to fetch data: %v", err)}
+		}
+		if n < 4 {
+			continue
+		}
+
+		// The server replies from a new port (its transfer ID), which
+		// identifies the transfer from then on.
+		if peer == nil {
+			peer, dest = addr, addr
+		} else if !addr.IP.Equal(peer.IP) || addr.Port != peer.Port {
+			conn.WriteToUDP(tftpError(tftpErrUnknownTID, "Unknown transfer ID"), addr)
+			continue
+		}
+
+		opcode := binary.BigEndian.Uint16(buf[0:2])
+		arg := binary.BigEndian.Uint16(buf[2:4])
+		switch opcode {
+		case tftpDATA:
+			if arg == block {
+				data.Write(buf[4:n])
+				last = tftpAck(block)
+				attempts = 0
+				conn.WriteToUDP(last, dest)
+				if n-4 < tftpBlockSize {
+					return data.Bytes(), nil
+				}
+				block++
+			} else if arg == block-1 {
+				// Our ACK got lost
+				conn.WriteToUDP(last, dest)
+			}
+		case tftpERROR:
+			msg := string(bytes.TrimRight(buf[4:n], "\x00"))
+			if arg == tftpErrNotFound || arg == tftpErrAccess {
+				return nil, ErrNotFound{fmt.Errorf("Not found. TFTP error: %s", msg)}
+			}
+			return nil, ErrServer{fmt.Errorf("Server error. TFTP error %d: %s", arg, msg)}
+		}
+	}
+}
+
+func tftpAck(block uint16) []byte {
+	ack := make([]byte, 4)
+	binary.BigEndian.PutUint16(ack[0:2], tftpACK)
+	binary.BigEndian.PutUint16(ack[2:4], block)
+	return ack
+}
+
+func tftpError(code uint16, msg string) []byte {
+	e := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(e[0:2], tftpERROR)
+	binary.BigEndian.PutUint16(e[2:4], code)
+	return append(append(e, msg...), 0)
+}
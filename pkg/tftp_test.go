@@ -0,0 +1,161 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tftpServer is a minimal read-only TFTP server. Every transfer is served
+// from a new port, as required by RFC 1350. If dropAck is set, the first
+// ACK of each block is ignored to exercise retransmission.
+type tftpServer struct {
+	conn    *net.UDPConn
+	files   map[string][]byte
+	dropAck bool
+}
+
+func newTFTPServer(t *testing.T, files map[string][]byte, dropAck bool) *tftpServer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+	s := &tftpServer{conn: conn, files: files, dropAck: dropAck}
+	go s.serve()
+	return s
+}
+
+func (s *tftpServer) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *tftpServer) Close() {
+	s.conn.Close()
+}
+
+func (s *tftpServer) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n < 2 || binary.BigEndian.Uint16(buf) != tftpRRQ {
+			continue
+		}
+		fields := strings.Split(string(buf[2:n]), "\x00")
+		go s.transfer(fields[0], addr)
+	}
+}
+
+func (s *tftpServer) transfer(file string, client *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	data, ok := s.files[file]
+	if !ok {
+		conn.WriteToUDP(tftpError(tftpErrNotFound, "File not found"), client)
+		return
+	}
+
+	buf := make([]byte, 16)
+	for block := uint16(1); ; block++ {
+		start := int(block-1) * tftpBlockSize
+		end := start + tftpBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		pkt := &bytes.Buffer{}
+		binary.Write(pkt, binary.BigEndian, []uint16{tftpDATA, block})
+		pkt.Write(data[start:end])
+
+		dropped := !s.dropAck
+		for {
+			conn.WriteToUDP(pkt.Bytes(), client)
+			conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				continue
+			}
+			if n == 4 && binary.BigEndian.Uint16(buf) == tftpACK && binary.BigEndian.Uint16(buf[2:]) == block {
+				if !dropped {
+					dropped = true
+					continue
+				}
+				break
+			}
+		}
+		if end-start < tftpBlockSize {
+			return
+		}
+	}
+}
+
+func TestTFTPGet(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 100)
+	exact := bytes.Repeat([]byte("x"), 2*tftpBlockSize)
+	files := map[string][]byte{
+		"small":     []byte("#cloud-config\n"),
+		"empty":     {},
+		"dir/large": large,
+		"dir/exact": exact,
+	}
+
+	for _, dropAck := range []bool{false, true} {
+		s := newTFTPServer(t, files, dropAck)
+		for i, tt := range []struct {
+			file string
+			data []byte
+			err  error
+		}{
+			{"small", files["small"], nil},
+			{"empty", nil, nil},
+			{"dir/large", large, nil},
+			{"dir/exact", exact, nil},
+			{"missing", nil, ErrNotFound{}},
+		} {
+			data, err := tftpGet(context.Background(), s.Addr(), tt.file, time.Second)
+			if reflect.TypeOf(err) != reflect.TypeOf(tt.err) {
+				t.Errorf("bad error (#%d, dropAck=%v): want %T, got %v", i, dropAck, tt.err, err)
+			}
+			if !bytes.Equal(data, tt.data) {
+				t.Errorf("bad data (#%d, dropAck=%v): want %d bytes, got %d", i, dropAck, len(tt.data), len(data))
+			}
+		}
+		s.Close()
+	}
+}
+
+func TestTFTPGetTimeout(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := tftpGet(context.Background(), conn.LocalAddr().String(), "user_data", 10*time.Millisecond); reflect.TypeOf(err) != reflect.TypeOf(ErrNetwork{}) {
+		t.Errorf("bad error: want %T, got %v", ErrNetwork{}, err)
+	}
+}
@@ -72,6 +72,11 @@ func mergeEnvContents(old []byte, pending map[string]string) []byte {
 // Existing ordering and any unknown formatting such as comments are
 // preserved. If no changes are required the file is untouched.
 func WriteEnvFile(ef *EnvFile, root string) error {
+	return writeEnvFile(ef, root, WriteFile)
+}
+
+// writeEnvFile does the work of WriteEnvFile, using write to store the file.
+func writeEnvFile(ef *EnvFile, root string, write func(*File, string) (string, error)) error {
 	// validate new keys, mergeEnvContents uses pending to track writes
 	pending := make(map[string]string, len(ef.Vars))
 	for key, value := range ef.Vars {
@@ -100,7 +105,7 @@ func WriteEnvFile(ef *EnvFile, root string) error {
 	}
 
 	ef.File.Content = string(newContent)
-	_, err = WriteFile(ef.File, root)
+	_, err = write(ef.File, root)
 	return err
 }
 
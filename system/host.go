@@ -0,0 +1,87 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/network"
+)
+
+// Host is the set of operations through which a cloud-config is applied to
+// the system. Every change made by initialize.Apply goes through a Host so
+// that it can be recorded (see Plan) instead of carried out.
+type Host interface {
+	UnitManager
+	WriteFile(f *File, root string) (string, error)
+	WriteEnvFile(ef *EnvFile, root string) error
+	SetHostname(hostname string) error
+	UserExists(u *config.User) bool
+	CreateUser(u *config.User) error
+	SetUserPassword(user, hash string) error
+	AuthorizeSSHKeys(user string, keysName string, keys []string) error
+	RestartNetwork(interfaces []network.InterfaceGenerator) error
+	RegenerateSSHHostKeys(root string) error
+	RegenerateMachineID(root string) error
+}
+
+// NewHost returns a Host which makes changes to the running system, with
+// units placed relative to root.
+func NewHost(root string) Host {
+	return &host{NewUnitManager(root)}
+}
+
+type host struct {
+	UnitManager
+}
+
+func (h *host) WriteFile(f *File, root string) (string, error) {
+	return WriteFile(f, root)
+}
+
+func (h *host) WriteEnvFile(ef *EnvFile, root string) error {
+	return WriteEnvFile(ef, root)
+}
+
+func (h *host) SetHostname(hostname string) error {
+	return SetHostname(hostname)
+}
+
+func (h *host) UserExists(u *config.User) bool {
+	return UserExists(u)
+}
+
+func (h *host) CreateUser(u *config.User) error {
+	return CreateUser(u)
+}
+
+func (h *host) SetUserPassword(user, hash string) error {
+	return SetUserPassword(user, hash)
+}
+
+func (h *host) AuthorizeSSHKeys(user string, keysName string, keys []string) error {
+	return AuthorizeSSHKeys(user, keysName, keys)
+}
+
+func (h *host) RestartNetwork(interfaces []network.InterfaceGenerator) error {
+	return RestartNetwork(interfaces)
+}
+
+func (h *host) RegenerateSSHHostKeys(root string) error {
+	return RegenerateSSHHostKeys(root)
+}
+
+func (h *host) RegenerateMachineID(root string) error {
+	return RegenerateMachineID(root)
+}
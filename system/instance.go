@@ -0,0 +1,63 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+)
+
+// RegenerateSSHHostKeys replaces the SSH host keys of the system rooted at
+// root with freshly generated ones.
+func RegenerateSSHHostKeys(root string) error {
+	keys, err := filepath.Glob(path.Join(root, "etc", "ssh", "ssh_host_*key*"))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := os.Remove(key); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"-A"}
+	if root != "/" {
+		args = append(args, "-f", root)
+	}
+	if output, err := exec.Command("ssh-keygen", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Call to ssh-keygen failed with %v: %s", err, output)
+	}
+	return nil
+}
+
+// RegenerateMachineID replaces the machine ID of the system rooted at root
+// with a freshly generated one.
+func RegenerateMachineID(root string) error {
+	if err := os.Remove(path.Join(root, "etc", "machine-id")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	args := []string{}
+	if root != "/" {
+		args = append(args, "--root", root)
+	}
+	if output, err := exec.Command("systemd-machine-id-setup", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Call to systemd-machine-id-setup failed with %v: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/network"
+)
+
+// NewOfflineUnitManager returns a UnitManager which manipulates the unit
+// files of the system image rooted at root directly, without talking to
+// systemd. Units are enabled by creating the symlinks described by their
+// [Install] section and unit commands are skipped.
+func NewOfflineUnitManager(root string) UnitManager {
+	return &offlineSystemd{&systemd{root}}
+}
+
+type offlineSystemd struct {
+	*systemd
+}
+
+// EnableUnitFile creates a symlink to the unit in the .wants or .requires
+// directory of every unit named by WantedBy= or RequiredBy=, analogous to
+// `systemctl --root enable`.
+func (s *offlineSystemd) EnableUnitFile(u Unit) error {
+	source := u.Destination("/")
+	content := u.Content
+	if content == "" {
+		var err error
+		if source, content, err = s.findUnit(u); err != nil {
+			return err
+		}
+	}
+
+	for _, dep := range installDependencies(u.Name, content) {
+		link := path.Join(u.prefix(s.root), dep)
+		if err := os.MkdirAll(path.Dir(link), os.FileMode(0755)); err != nil {
+			return err
+		}
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(source, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findUnit locates the existing unit file for u within the root, returning
+// its path relative to the root and its contents.
+func (s *offlineSystemd) findUnit(u Unit) (string, string, error) {
+	for _, dir := range []string{
+		u.prefix("/"),
+		"/usr/lib/systemd/" + u.Group(),
+		"/lib/systemd/" + u.Group(),
+	} {
+		p := path.Join(dir, u.Name)
+		content, err := ioutil.ReadFile(path.Join(s.root, p))
+		if err == nil {
+			return p, string(content), nil
+		} else if !os.IsNotExist(err) {
+			return "", "", err
+		}
+	}
+	return "", "", fmt.Errorf("unit %q not found in %s", u.Name, s.root)
+}
+
+func (s *offlineSystemd) RunUnitCommand(u Unit, c string) (string, error) {
+	log.Printf("Offline, skipping %q of unit %q", c, u.Name)
+	return "skipped", nil
+}
+
+func (s *offlineSystemd) DaemonReload() error {
+	return nil
+}
+
+// installDependencies returns the paths, relative to the unit directory, of
+// the symlinks which enable the named unit with the given contents.
+func installDependencies(name, content string) (deps []string) {
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+		if section != "[Install]" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var suffix string
+		switch strings.TrimSpace(parts[0]) {
+		case "WantedBy":
+			suffix = ".wants"
+		case "RequiredBy":
+			suffix = ".requires"
+		default:
+			continue
+		}
+		for _, target := range strings.Fields(parts[1]) {
+			deps = append(deps, path.Join(target+suffix, name))
+		}
+	}
+	return
+}
+
+// NewOfflineHost returns a Host which applies changes to the system image
+// rooted at root without relying on D-Bus or any of the services running on
+// the current machine.
+func NewOfflineHost(root string) Host {
+	return &offlineHost{NewOfflineUnitManager(root), root}
+}
+
+type offlineHost struct {
+	UnitManager
+	root string
+}
+
+func (h *offlineHost) WriteFile(f *File, root string) (string, error) {
+	return WriteFile(f, root)
+}
+
+func (h *offlineHost) WriteEnvFile(ef *EnvFile, root string) error {
+	return WriteEnvFile(ef, root)
+}
+
+// SetHostname writes the hostname to /etc/hostname within the root.
+func (h *offlineHost) SetHostname(hostname string) error {
+	_, err := WriteFile(&File{config.File{
+		Path:               "/etc/hostname",
+		Content:            hostname + "\n",
+		RawFilePermissions: "0644",
+	}}, h.root)
+	return err
+}
+
+func (h *offlineHost) UserExists(u *config.User) bool {
+	_, err := lookupPasswd(h.root, u.Name)
+	return err == nil
+}
+
+func (h *offlineHost) CreateUser(u *config.User) error {
+	return createUser(u, h.root)
+}
+
+func (h *offlineHost) SetUserPassword(user, hash string) error {
+	return setUserPassword(user, hash, h.root)
+}
+
+// AuthorizeSSHKeys stores the keys in the user's authorized_keys.d directory
+// and regenerates authorized_keys from it, as update-ssh-keys would.
+func (h *offlineHost) AuthorizeSSHKeys(user string, keysName string, keys []string) error {
+	pw, err := lookupPasswd(h.root, user)
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		keys[i] = strings.TrimSpace(key)
+	}
+	sshDir := path.Join(h.root, pw.home, ".ssh")
+	keysDir := path.Join(sshDir, "authorized_keys.d")
+	if err := os.MkdirAll(keysDir, os.FileMode(0700)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(keysDir, keysName), []byte(strings.Join(keys, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+
+	fis, err := ioutil.ReadDir(keysDir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	var all []byte
+	for _, name := range names {
+		b, err := ioutil.ReadFile(path.Join(keysDir, name))
+		if err != nil {
+			return err
+		}
+		all = append(all, b...)
+	}
+	authorized := path.Join(sshDir, "authorized_keys")
+	if err := ioutil.WriteFile(authorized, all, 0600); err != nil {
+		return err
+	}
+
+	for _, p := range []string{sshDir, keysDir, path.Join(keysDir, keysName), authorized} {
+		if err := os.Lchown(p, pw.uid, pw.gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestartNetwork does nothing; the network units are picked up when the image
+// is booted.
+func (h *offlineHost) RestartNetwork(interfaces []network.InterfaceGenerator) error {
+	log.Printf("Offline, skipping network restart")
+	return nil
+}
+
+func (h *offlineHost) RegenerateSSHHostKeys(root string) error {
+	return RegenerateSSHHostKeys(root)
+}
+
+func (h *offlineHost) RegenerateMachineID(root string) error {
+	return RegenerateMachineID(root)
+}
+
+type passwdEntry struct {
+	uid  int
+	gid  int
+	home string
+}
+
+// lookupPasswd finds the named user in the passwd file within root.
+func lookupPasswd(root, name string) (*passwdEntry, error) {
+	f, err := os.Open(path.Join(root, "etc", "passwd"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 6 || fields[0] != name {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid for user %q: %q", name, fields[2])
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid for user %q: %q", name, fields[3])
+		}
+		return &passwdEntry{uid: uid, gid: gid, home: fields[5]}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("user %q not found", name)
+}
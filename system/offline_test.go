@@ -0,0 +1,161 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/config"
+)
+
+func TestInstallDependencies(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+
+		deps []string
+	}{
+		{
+			content: "[Service]\nExecStart=/bin/true\n",
+		},
+		{
+			content: "[Unit]\nWantedBy=ignored.target\n[Install]\nWantedBy=multi-user.target\n",
+			deps:    []string{"multi-user.target.wants/foo.service"},
+		},
+		{
+			content: "[Install]\nWantedBy = a.target b.target\nRequiredBy=c.target\nAlias=bar.service\n",
+			deps:    []string{"a.target.wants/foo.service", "b.target.wants/foo.service", "c.target.requires/foo.service"},
+		},
+	} {
+		if deps := installDependencies("foo.service", tt.content); !reflect.DeepEqual(tt.deps, deps) {
+			t.Errorf("bad dependencies (%q): want %q, got %q", tt.content, tt.deps, deps)
+		}
+	}
+}
+
+func TestOfflineEnableUnitFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	shipped := path.Join(dir, "usr", "lib", "systemd", "system", "shipped.service")
+	if err := os.MkdirAll(path.Dir(shipped), 0755); err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+	if err := ioutil.WriteFile(shipped, []byte("[Install]\nWantedBy=multi-user.target\n"), 0644); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+
+	um := NewOfflineUnitManager(dir)
+	for _, tt := range []struct {
+		unit config.Unit
+
+		link   string
+		target string
+		err    error
+	}{
+		{
+			unit:   config.Unit{Name: "foo.service", Content: "[Install]\nWantedBy=default.target\n"},
+			link:   "etc/systemd/system/default.target.wants/foo.service",
+			target: "/etc/systemd/system/foo.service",
+		},
+		{
+			unit:   config.Unit{Name: "foo.service", Runtime: true, Content: "[Install]\nRequiredBy=local-fs.target\n"},
+			link:   "run/systemd/system/local-fs.target.requires/foo.service",
+			target: "/run/systemd/system/foo.service",
+		},
+		{
+			unit:   config.Unit{Name: "shipped.service"},
+			link:   "etc/systemd/system/multi-user.target.wants/shipped.service",
+			target: "/usr/lib/systemd/system/shipped.service",
+		},
+		{
+			unit: config.Unit{Name: "missing.service"},
+			err:  errors.New(`unit "missing.service" not found in ` + dir),
+		},
+	} {
+		err := um.EnableUnitFile(Unit{tt.unit})
+		if !reflect.DeepEqual(tt.err, err) {
+			t.Errorf("bad error (%+v): want %v, got %v", tt.unit, tt.err, err)
+		}
+		if tt.link == "" {
+			continue
+		}
+		if target, err := os.Readlink(path.Join(dir, tt.link)); err != nil || target != tt.target {
+			t.Errorf("bad link (%+v): want %q, got %q (%v)", tt.unit, tt.target, target, err)
+		}
+	}
+}
+
+func TestOfflineHost(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(path.Join(dir, "etc"), 0755); err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+	// core is the user running the test, so that its home can be chowned
+	// to it without being root
+	passwd := fmt.Sprintf("root:x:0:0:root:/root:/bin/bash\ncore:x:%d:%d::/home/core:/bin/bash\n", os.Getuid(), os.Getgid())
+	if err := ioutil.WriteFile(path.Join(dir, "etc", "passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+
+	host := NewOfflineHost(dir)
+
+	if err := host.SetHostname("example"); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if c, err := ioutil.ReadFile(path.Join(dir, "etc", "hostname")); err != nil || string(c) != "example\n" {
+		t.Errorf("bad hostname: want %q, got %q (%v)", "example\n", c, err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		exists bool
+	}{
+		{"core", true},
+		{"root", true},
+		{"nobody", false},
+	} {
+		if exists := host.UserExists(&config.User{Name: tt.name}); exists != tt.exists {
+			t.Errorf("bad existence (%q): want %t, got %t", tt.name, tt.exists, exists)
+		}
+	}
+
+	if err := host.AuthorizeSSHKeys("core", "a", []string{" key1 ", "key2"}); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if err := host.AuthorizeSSHKeys("core", "b", []string{"key3"}); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	want := "key1\nkey2\nkey3\n"
+	if c, err := ioutil.ReadFile(path.Join(dir, "home", "core", ".ssh", "authorized_keys")); err != nil || string(c) != want {
+		t.Errorf("bad authorized_keys: want %q, got %q (%v)", want, c, err)
+	}
+	if err := host.AuthorizeSSHKeys("nobody", "a", nil); !reflect.DeepEqual(errors.New(`user "nobody" not found`), err) {
+		t.Errorf("bad error: want %v, got %v", errors.New(`user "nobody" not found`), err)
+	}
+}
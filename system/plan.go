@@ -0,0 +1,219 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coreos/coreos-cloudinit/config"
+	"github.com/coreos/coreos-cloudinit/network"
+)
+
+// Change is a single modification that applying a cloud-config would make.
+type Change struct {
+	Action string
+	Target string
+	Detail string
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s %s\n", c.Action, c.Target)
+	if c.Detail != "" {
+		s += c.Detail
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+	}
+	return s
+}
+
+// Plan is a Host which records the changes it is asked to make instead of
+// making them. The current state of the system is only read, never modified.
+type Plan struct {
+	Changes []Change
+
+	root       string
+	hostname   func() (string, error)
+	userExists func(*config.User) bool
+}
+
+// NewPlan returns an empty Plan for a system whose units are placed relative
+// to root.
+func NewPlan(root string) *Plan {
+	return &Plan{
+		root:       root,
+		hostname:   Hostname,
+		userExists: UserExists,
+	}
+}
+
+func (p *Plan) String() string {
+	var buf bytes.Buffer
+	for _, c := range p.Changes {
+		buf.WriteString(c.String())
+	}
+	return buf.String()
+}
+
+func (p *Plan) record(action, target, detail string) {
+	p.Changes = append(p.Changes, Change{Action: action, Target: target, Detail: detail})
+}
+
+// WriteFile records the file which would be written along with a diff
+// against the current contents at that path.
+func (p *Plan) WriteFile(f *File, root string) (string, error) {
+	if f.Encoding != "" {
+		return "", fmt.Errorf("Unable to write file with encoding %s", f.Encoding)
+	}
+	perm, err := f.Permissions()
+	if err != nil {
+		return "", err
+	}
+
+	fullpath := path.Join(root, f.Path)
+	diff, err := diffFile(fullpath, f.Content)
+	if err != nil {
+		return "", err
+	}
+	detail := fmt.Sprintf("mode %04o", perm)
+	if f.Owner != "" {
+		detail += fmt.Sprintf(", owner %s", f.Owner)
+	}
+	p.record("write", fullpath, detail+"\n"+diff)
+	return fullpath, nil
+}
+
+func (p *Plan) WriteEnvFile(ef *EnvFile, root string) error {
+	return writeEnvFile(ef, root, p.WriteFile)
+}
+
+func (p *Plan) PlaceUnit(u Unit) error {
+	dst := u.Destination(p.root)
+	diff, err := diffFile(dst, u.Content)
+	if err != nil {
+		return err
+	}
+	p.record("place", dst, diff)
+	return nil
+}
+
+func (p *Plan) PlaceUnitDropIn(u Unit, d config.UnitDropIn) error {
+	dst := u.DropInDestination(p.root, d)
+	diff, err := diffFile(dst, d.Content)
+	if err != nil {
+		return err
+	}
+	p.record("place", dst, diff)
+	return nil
+}
+
+func (p *Plan) EnableUnitFile(u Unit) error {
+	p.record("enable", u.Name, "")
+	return nil
+}
+
+func (p *Plan) RunUnitCommand(u Unit, c string) (string, error) {
+	p.record(c, u.Name, "")
+	return "", nil
+}
+
+func (p *Plan) MaskUnit(u Unit) error {
+	p.record("mask", u.Name, "")
+	return nil
+}
+
+// UnmaskUnit only records a change if the unit is currently masked.
+func (p *Plan) UnmaskUnit(u Unit) error {
+	ne, err := nullOrEmpty(u.Destination(p.root))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if ne {
+		p.record("unmask", u.Name, "")
+	}
+	return nil
+}
+
+func (p *Plan) DaemonReload() error {
+	p.record("daemon-reload", "systemd", "")
+	return nil
+}
+
+// SetHostname only records a change if the hostname differs from the
+// current one.
+func (p *Plan) SetHostname(hostname string) error {
+	if current, err := p.hostname(); err == nil && current == hostname {
+		return nil
+	}
+	p.record("set-hostname", hostname, "")
+	return nil
+}
+
+func (p *Plan) UserExists(u *config.User) bool {
+	return p.userExists(u)
+}
+
+func (p *Plan) CreateUser(u *config.User) error {
+	p.record("create-user", u.Name, "")
+	return nil
+}
+
+func (p *Plan) SetUserPassword(user, hash string) error {
+	p.record("set-password", user, "")
+	return nil
+}
+
+func (p *Plan) AuthorizeSSHKeys(user string, keysName string, keys []string) error {
+	p.record("authorize-ssh-keys", user, fmt.Sprintf("%d key(s) from %s\n", len(keys), keysName))
+	return nil
+}
+
+func (p *Plan) RestartNetwork(interfaces []network.InterfaceGenerator) error {
+	var names []string
+	for _, iface := range interfaces {
+		names = append(names, iface.Name())
+	}
+	p.record("restart-network", strings.Join(names, " "), "")
+	return nil
+}
+
+func (p *Plan) RegenerateSSHHostKeys(root string) error {
+	p.record("regenerate", path.Join(root, "etc", "ssh", "ssh_host_*key*"), "")
+	return nil
+}
+
+func (p *Plan) RegenerateMachineID(root string) error {
+	p.record("regenerate", path.Join(root, "etc", "machine-id"), "")
+	return nil
+}
+
+// diffFile returns a unified diff between the file at fullpath, which need
+// not exist, and content.
+func diffFile(fullpath, content string) (string, error) {
+	old, err := ioutil.ReadFile(fullpath)
+	if os.IsNotExist(err) {
+		return unifiedDiff("", fullpath, "", content), nil
+	} else if err != nil {
+		return "", err
+	}
+	return unifiedDiff(fullpath, fullpath, string(old), content), nil
+}
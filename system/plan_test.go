@@ -0,0 +1,133 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/coreos-cloudinit/config"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tt := range []struct {
+		oldName string
+		old     string
+		new     string
+
+		diff string
+	}{
+		{
+			oldName: "a",
+			old:     "same\n",
+			new:     "same\n",
+		},
+		{
+			new:  "one\ntwo\n",
+			diff: "--- /dev/null\n+++ b\n@@ -0,0 +1,2 @@\n+one\n+two\n",
+		},
+		{
+			oldName: "a",
+			old:     "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:     "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n",
+			diff:    "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			oldName: "a",
+			old:     "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:     "one\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			diff:    "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,3 @@\n 7\n 8\n 9\n-10\n",
+		},
+		{
+			oldName: "a",
+			old:     "x\n",
+			new:     "x",
+			diff:    "--- a\n+++ b\n@@ -1,1 +1,1 @@\n-x\n+x\n\\ No newline at end of file\n",
+		},
+	} {
+		if diff := unifiedDiff(tt.oldName, "b", tt.old, tt.new); diff != tt.diff {
+			t.Errorf("bad diff (%q -> %q): want %q, got %q", tt.old, tt.new, tt.diff, diff)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	existing := path.Join(dir, "etc", "existing")
+	if err := os.MkdirAll(path.Dir(existing), 0755); err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+	if err := ioutil.WriteFile(existing, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+
+	p := NewPlan(dir)
+	p.hostname = func() (string, error) { return "current", nil }
+	p.userExists = func(u *config.User) bool { return u.Name == "core" }
+
+	if _, err := p.WriteFile(&File{config.File{Path: "/etc/existing", Content: "new\n"}}, dir); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if _, err := p.WriteFile(&File{config.File{Path: "/etc/created", Content: "new\n", RawFilePermissions: "0600", Owner: "core"}}, dir); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	if _, err := p.WriteFile(&File{config.File{Path: "/etc/encoded", Encoding: "base64"}}, dir); err == nil {
+		t.Fatalf("bad error: want non-nil, got nil")
+	}
+	u := Unit{config.Unit{Name: "foo.service", Content: "[Service]\n"}}
+	if err := p.PlaceUnit(u); err != nil {
+		t.Fatalf("bad error: want nil, got %v", err)
+	}
+	p.EnableUnitFile(u)
+	p.RunUnitCommand(u, "start")
+	p.MaskUnit(Unit{config.Unit{Name: "bar.service"}})
+	p.UnmaskUnit(Unit{config.Unit{Name: "baz.service"}})
+	p.SetHostname("current")
+	p.SetHostname("new")
+	for _, name := range []string{"core", "user"} {
+		if !p.UserExists(&config.User{Name: name}) {
+			p.CreateUser(&config.User{Name: name})
+		}
+	}
+
+	want := []Change{
+		{Action: "write", Target: existing, Detail: "mode 0644\n--- " + existing + "\n+++ " + existing + "\n@@ -1,1 +1,1 @@\n-old\n+new\n"},
+		{Action: "write", Target: path.Join(dir, "etc", "created"), Detail: "mode 0600, owner core\n--- /dev/null\n+++ " + path.Join(dir, "etc", "created") + "\n@@ -0,0 +1,1 @@\n+new\n"},
+		{Action: "place", Target: u.Destination(dir), Detail: "--- /dev/null\n+++ " + u.Destination(dir) + "\n@@ -0,0 +1,1 @@\n+[Service]\n"},
+		{Action: "enable", Target: "foo.service"},
+		{Action: "start", Target: "foo.service"},
+		{Action: "mask", Target: "bar.service"},
+		{Action: "set-hostname", Target: "new"},
+		{Action: "create-user", Target: "user"},
+	}
+	if !reflect.DeepEqual(want, p.Changes) {
+		t.Errorf("bad changes: want %#v, got %#v", want, p.Changes)
+	}
+
+	if c, err := ioutil.ReadFile(existing); err != nil || string(c) != "old\n" {
+		t.Errorf("bad contents: want %q, got %q (%v)", "old\n", c, err)
+	}
+	if _, err := os.Stat(path.Join(dir, "etc", "created")); !os.IsNotExist(err) {
+		t.Errorf("bad error: want not exist, got %v", err)
+	}
+}
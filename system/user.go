@@ -30,8 +30,17 @@ func UserExists(u *config.User) bool {
 }
 
 func CreateUser(u *config.User) error {
+	return createUser(u, "/")
+}
+
+// createUser runs useradd against the system rooted at root.
+func createUser(u *config.User, root string) error {
 	args := []string{}
 
+	if root != "/" {
+		args = append(args, "--root", root)
+	}
+
 	if u.PasswordHash != "" {
 		args = append(args, "--password", u.PasswordHash)
 	} else {
@@ -86,7 +95,16 @@ func CreateUser(u *config.User) error {
 }
 
 func SetUserPassword(user, hash string) error {
-	cmd := exec.Command("/usr/sbin/chpasswd", "-e")
+	return setUserPassword(user, hash, "/")
+}
+
+// setUserPassword runs chpasswd against the system rooted at root.
+func setUserPassword(user, hash, root string) error {
+	args := []string{"-e"}
+	if root != "/" {
+		args = append(args, "--root", root)
+	}
+	cmd := exec.Command("/usr/sbin/chpasswd", args...)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -0,0 +1,198 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/coreos-cloudinit/datasource/proc_cmdline"
+	"github.com/coreos/coreos-cloudinit/pkg"
+)
+
+// timeoutCmdlinePrefix prefixes the timeout settings on the kernel command
+// line, e.g. "cloud-config-datasource-timeout=30s".
+const timeoutCmdlinePrefix = "cloud-config-"
+
+// timeoutKeys are the settings which may be given as flags, in the
+// -timeouts-file and on the kernel command line. In addition, the policy keys
+// may be given for a single datasource as "<datasource>.<key>", e.g.
+// "ec2-metadata.timeout".
+var (
+	timeoutKeys = []string{
+		"datasource-timeout",
+		"datasource-interval",
+		"datasource-max-interval",
+		"http-timeout",
+		"http-retries",
+		"http-initial-backoff",
+		"http-max-backoff",
+	}
+	policyKeys = []string{"timeout", "interval", "max-interval"}
+)
+
+// retryPolicy is how often, and for how long, the availability of a
+// datasource is checked.
+type retryPolicy struct {
+	// timeout after which the datasource is considered unavailable, or 0
+	// to keep checking until the overall timeout
+	timeout     time.Duration
+	interval    time.Duration
+	maxInterval time.Duration
+}
+
+// timeouts holds the settings for selecting a datasource.
+type timeouts struct {
+	timeout  time.Duration
+	defaults retryPolicy
+	sources  map[string]retryPolicy
+}
+
+// policy returns the retryPolicy of the named datasource: the defaults,
+// overridden by any settings for the datasource itself.
+func (t timeouts) policy(name string) retryPolicy {
+	p := t.defaults
+	if s, ok := t.sources[name]; ok {
+		if s.timeout != 0 {
+			p.timeout = s.timeout
+		}
+		if s.interval != 0 {
+			p.interval = s.interval
+		}
+		if s.maxInterval != 0 {
+			p.maxInterval = s.maxInterval
+		}
+	}
+	return p
+}
+
+// timeoutSettings gathers the timeout settings from the file, the kernel
+// command line and the flags which were set explicitly, in increasing order
+// of precedence. A missing file or kernel command line is ignored.
+func timeoutSettings(file, cmdline string, set map[string]string) (map[string]string, error) {
+	settings := map[string]string{}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		} else if err == nil {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for n := 1; scanner.Scan(); n++ {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				parts := strings.SplitN(line, "=", 2)
+				if len(parts) != 2 {
+					return nil, fmt.Errorf("%s:%d: expected <key>=<value>", file, n)
+				}
+				settings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts, err := proc_cmdline.Options(cmdline, timeoutCmdlinePrefix); err == nil {
+		for k, v := range opts {
+			if isTimeoutKey(k) {
+				settings[k] = v
+			}
+		}
+	}
+
+	for k, v := range set {
+		settings[k] = v
+	}
+	return settings, nil
+}
+
+// isTimeoutKey returns whether key is one of the timeoutKeys or a policy key
+// of a known datasource.
+func isTimeoutKey(key string) bool {
+	for _, k := range timeoutKeys {
+		if k == key {
+			return true
+		}
+	}
+	if i := strings.LastIndex(key, "."); i > 0 && isDatasourceName(key[:i]) {
+		for _, k := range policyKeys {
+			if k == key[i+1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTimeouts applies the settings to t and http.
+func parseTimeouts(settings map[string]string, t *timeouts, http *pkg.HttpConfig) error {
+	for key, value := range settings {
+		if !isTimeoutKey(key) {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+
+		if key == "http-retries" {
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 1 {
+				return fmt.Errorf("invalid %s %q: must be a positive number", key, value)
+			}
+			http.MaxRetries = retries
+			continue
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 || (d == 0 && strings.HasSuffix(key, "interval")) {
+			return fmt.Errorf("invalid %s %q: must be a duration such as 30s", key, value)
+		}
+		switch key {
+		case "datasource-timeout":
+			t.timeout = d
+		case "datasource-interval":
+			t.defaults.interval = d
+		case "datasource-max-interval":
+			t.defaults.maxInterval = d
+		case "http-timeout":
+			http.Timeout = d
+		case "http-initial-backoff":
+			http.InitialBackoff = d
+		case "http-max-backoff":
+			http.MaxBackoff = d
+		default:
+			i := strings.LastIndex(key, ".")
+			name := key[:i]
+			if t.sources == nil {
+				t.sources = map[string]retryPolicy{}
+			}
+			p := t.sources[name]
+			switch key[i+1:] {
+			case "timeout":
+				p.timeout = d
+			case "interval":
+				p.interval = d
+			case "max-interval":
+				p.maxInterval = d
+			}
+			t.sources[name] = p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-cloudinit/pkg"
+)
+
+func TestTimeoutSettings(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "coreos-cloudinit-")
+	if err != nil {
+		t.Fatalf("failed creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "timeouts.conf")
+	if err := ioutil.WriteFile(file, []byte("# slow bare metal\ndatasource-timeout = 20m\nhttp-retries=30\nhttp-timeout=30s\n\n"), 0644); err != nil {
+		t.Fatalf("failed writing file: %v", err)
+	}
+	badFile := path.Join(dir, "bad.conf")
+	if err := ioutil.WriteFile(badFile, []byte("datasource-timeout\n"), 0644); err != nil {
+		t.Fatalf("failed writing file: %v", err)
+	}
+	cmdline := path.Join(dir, "cmdline")
+	if err := ioutil.WriteFile(cmdline, []byte("cloud-config-url=http://example.com cloud_config_http_retries=5 cloud-config-ec2-metadata.timeout=1m cloud-config-bogus=1\n"), 0644); err != nil {
+		t.Fatalf("failed writing cmdline: %v", err)
+	}
+	missing := path.Join(dir, "missing")
+
+	for i, tt := range []struct {
+		file    string
+		cmdline string
+		set     map[string]string
+
+		settings map[string]string
+		err      bool
+	}{
+		{missing, missing, nil, map[string]string{}, false},
+		{
+			file, missing, nil,
+			map[string]string{"datasource-timeout": "20m", "http-retries": "30", "http-timeout": "30s"}, false,
+		},
+		{
+			file, cmdline, nil,
+			map[string]string{"datasource-timeout": "20m", "http-retries": "5", "http-timeout": "30s", "ec2-metadata.timeout": "1m"}, false,
+		},
+		{
+			file, cmdline, map[string]string{"http-retries": "1", "datasource-timeout": "10s"},
+			map[string]string{"datasource-timeout": "10s", "http-retries": "1", "http-timeout": "30s", "ec2-metadata.timeout": "1m"}, false,
+		},
+		{badFile, missing, nil, nil, true},
+		{dir, missing, nil, nil, true},
+	} {
+		settings, err := timeoutSettings(tt.file, tt.cmdline, tt.set)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if !tt.err && !reflect.DeepEqual(tt.settings, settings) {
+			t.Errorf("bad settings (#%d): want %v, got %v", i, tt.settings, settings)
+		}
+	}
+}
+
+func TestParseTimeouts(t *testing.T) {
+	for i, tt := range []struct {
+		settings map[string]string
+
+		timeouts timeouts
+		http     pkg.HttpConfig
+		err      bool
+	}{
+		{
+			settings: map[string]string{},
+			timeouts: timeouts{timeout: time.Minute, defaults: retryPolicy{interval: time.Second, maxInterval: time.Minute}},
+		},
+		{
+			settings: map[string]string{
+				"datasource-timeout":       "20m",
+				"datasource-interval":      "2s",
+				"datasource-max-interval":  "10s",
+				"http-timeout":             "30s",
+				"http-retries":             "3",
+				"http-initial-backoff":     "1s",
+				"http-max-backoff":         "1m",
+				"ec2-metadata.timeout":     "30s",
+				"configdrive.interval":     "500ms",
+				"configdrive.max-interval": "1s",
+			},
+			timeouts: timeouts{
+				timeout:  20 * time.Minute,
+				defaults: retryPolicy{interval: 2 * time.Second, maxInterval: 10 * time.Second},
+				sources: map[string]retryPolicy{
+					"ec2-metadata": {timeout: 30 * time.Second},
+					"configdrive":  {interval: 500 * time.Millisecond, maxInterval: time.Second},
+				},
+			},
+			http: pkg.HttpConfig{Timeout: 30 * time.Second, MaxRetries: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute},
+		},
+		{settings: map[string]string{"datasource-timeout": "forever"}, err: true},
+		{settings: map[string]string{"datasource-timeout": "-1s"}, err: true},
+		{settings: map[string]string{"datasource-interval": "0"}, err: true},
+		{settings: map[string]string{"http-retries": "0"}, err: true},
+		{settings: map[string]string{"http-retries": "many"}, err: true},
+		{settings: map[string]string{"floppy.timeout": "1s"}, err: true},
+		{settings: map[string]string{"ec2-metadata.retries": "1"}, err: true},
+	} {
+		to := timeouts{timeout: time.Minute, defaults: retryPolicy{interval: time.Second, maxInterval: time.Minute}}
+		http := pkg.HttpConfig{}
+		err := parseTimeouts(tt.settings, &to, &http)
+		if (err != nil) != tt.err {
+			t.Errorf("bad error (#%d): want error %t, got %v", i, tt.err, err)
+		}
+		if tt.err {
+			continue
+		}
+		if !reflect.DeepEqual(tt.timeouts, to) {
+			t.Errorf("bad timeouts (#%d): want %+v, got %+v", i, tt.timeouts, to)
+		}
+		if http != tt.http {
+			t.Errorf("bad HTTP config (#%d): want %+v, got %+v", i, tt.http, http)
+		}
+	}
+}
+
+func TestTimeoutsPolicy(t *testing.T) {
+	to := timeouts{
+		defaults: retryPolicy{interval: time.Second, maxInterval: time.Minute},
+		sources: map[string]retryPolicy{
+			"ec2-metadata": {timeout: 30 * time.Second},
+			"configdrive":  {interval: 10 * time.Millisecond},
+		},
+	}
+	for i, tt := range []struct {
+		name   string
+		policy retryPolicy
+	}{
+		{"url", retryPolicy{interval: time.Second, maxInterval: time.Minute}},
+		{"ec2-metadata", retryPolicy{timeout: 30 * time.Second, interval: time.Second, maxInterval: time.Minute}},
+		{"configdrive", retryPolicy{interval: 10 * time.Millisecond, maxInterval: time.Minute}},
+	} {
+		if p := to.policy(tt.name); p != tt.policy {
+			t.Errorf("bad policy for %q (#%d): want %+v, got %+v", tt.name, i, tt.policy, p)
+		}
+	}
+}